@@ -9,7 +9,9 @@ require (
 	github.com/go-redis/redis/v8 v8.0.0-beta.6
 	github.com/golang/protobuf v1.5.1 // indirect
 	github.com/labstack/echo/v4 v4.1.16
+	github.com/lib/pq v1.10.0
 	github.com/mattn/go-colorable v0.1.7 // indirect
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/mitchellh/mapstructure v1.3.2 // indirect
 	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d
 	github.com/pelletier/go-toml v1.8.0 // indirect
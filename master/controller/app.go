@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"clock/v3/master/param"
+	"clock/v3/storage"
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+//GetApps 应用列表 不返回 app_key 以及 secret_key
+func GetApps(c echo.Context) error {
+	resp := param.BuildResp()
+
+	apps, err := storage.GetAllApp(context.Background())
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[get apps] error to get the app from db with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	resp.Data = apps
+	return c.JSON(http.StatusOK, resp)
+}
+
+//PostApp 新增一个应用 只有创建的时候返回 app_key 以及 secret_key
+func PostApp(c echo.Context) error {
+	resp := param.BuildResp()
+
+	p := param.NewApp{}
+	if err := c.Bind(&p); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[post app] invalidate param found: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+	a := storage.App{AppName: p.AppName, Role: p.Role}
+	if a.AppName == "" {
+		resp.Code = param.Failed
+		resp.Msg = "[post app] app_name is required"
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	if err := storage.CreateApp(context.Background(), &a); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[post app] err: %v", err)
+		log.Error(resp.Msg)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	resp.Data = a
+	return c.JSON(http.StatusOK, resp)
+}
+
+//ModifyAppName 修改应用名称
+func ModifyAppName(c echo.Context) error {
+	resp := param.BuildResp()
+
+	a := param.AppName{}
+	if err := c.Bind(&a); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[modify app] invalidate param found: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	if err := storage.ModifyAppName(context.Background(), c.Param("aid"), a.AppName); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[modify app] err: %v", err)
+		log.Error(resp.Msg)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+//ModifyAppRole 修改应用角色
+func ModifyAppRole(c echo.Context) error {
+	resp := param.BuildResp()
+
+	a := param.AppRole{}
+	if err := c.Bind(&a); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[modify app role] invalidate param found: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	if err := storage.ModifyAppRole(context.Background(), c.Param("aid"), a.Role); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[modify app role] err: %v", err)
+		log.Error(resp.Msg)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+//ResetAppKeys 重新生成应用的 app_key 以及 secret_key 只有这里以及创建的时候返回
+func ResetAppKeys(c echo.Context) error {
+	resp := param.BuildResp()
+
+	a, err := storage.ResetAppKeys(context.Background(), c.Param("aid"))
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[reset app keys] err: %v", err)
+		log.Error(resp.Msg)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	resp.Data = a
+	return c.JSON(http.StatusOK, resp)
+}
+
+//DeleteApp 删除应用 使用该应用签名的 http 任务会执行失败
+func DeleteApp(c echo.Context) error {
+	resp := param.BuildResp()
+
+	if err := storage.DeleteApp(context.Background(), c.Param("aid")); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[delete app] error to delete app with: %v", err)
+		log.Error(resp.Msg)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"clock/v3/master/param"
+	"clock/v3/storage"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+//audit 记录任务变更 写入失败不影响接口返回
+//ip 为连接的地址 配置了 server.trusted_proxies 的时候才使用代理转发的 X-Forwarded-For
+func audit(c echo.Context, action string, before, after *storage.Task) {
+	if err := storage.RecordAudit(CurrentApp(c).Actor(), c.RealIP(), action, before, after); err != nil {
+		log.Errorf("[audit] record %s err: %v", action, err)
+	}
+}
+
+//auditUpdated 重新查询更新之后的任务再记录变更
+func auditUpdated(c echo.Context, action string, before storage.Task) {
+	after, err := storage.GetTask(before.Tid)
+	if err != nil {
+		log.Errorf("[audit] get task %s err: %v", before.Tid, err)
+		return
+	}
+	audit(c, action, &before, &after)
+}
+
+//GetAudits 审计日志列表 可以按照 tid 以及时间范围 (left_ts, right_ts) 过滤
+func GetAudits(c echo.Context) (err error) {
+	var query storage.AuditQuery
+
+	resp := param.BuildResp()
+
+	if err := c.Bind(&query); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[get audits] error to get the query param with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	// app 只能看到自己任务的审计日志
+	if app := CurrentApp(c); !app.IsRoot() {
+		query.Aid = app.Aid
+	}
+
+	audits, err := storage.GetAudits(&query)
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[get audits] error to get the audits: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	page := param.ListResponse{
+		Items:     audits,
+		PageQuery: query,
+	}
+
+	resp.Data = page
+
+	return c.JSON(http.StatusOK, resp)
+}
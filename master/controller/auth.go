@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"clock/v3/storage"
+	"errors"
+
+	"github.com/labstack/echo/v4"
+)
+
+//AppContextKey 认证中间件把当前调用方写入 echo context 的 key
+const AppContextKey = "app"
+
+//CurrentApp 当前调用方 没有开启认证的时候视为 root
+func CurrentApp(c echo.Context) storage.App {
+	if a, ok := c.Get(AppContextKey).(storage.App); ok {
+		return a
+	}
+	return storage.RootApp
+}
+
+//ownLogs app 只能查询以及删除自己任务的日志 需要指定 tid root 不限制
+func ownLogs(c echo.Context, tid string) error {
+	app := CurrentApp(c)
+	if app.IsRoot() {
+		return nil
+	}
+	if tid == "" {
+		return errors.New("tid is required")
+	}
+	_, err := storage.TaskOwnedBy(app, tid)
+	return err
+}
+
+//authorizeTask 当前调用方需要拥有任务 并且角色允许对该类型的任务执行 action
+func authorizeTask(c echo.Context, tid string, action string) (storage.Task, error) {
+	app := CurrentApp(c)
+	t, err := storage.TaskOwnedBy(app, tid)
+	if err != nil {
+		return t, err
+	}
+	return t, app.Authorize(action, t.Type)
+}
+
+//authorizeWorkflow 当前调用方需要拥有工作流 并且角色允许执行 action
+func authorizeWorkflow(c echo.Context, wid string, action string) (storage.Workflow, error) {
+	app := CurrentApp(c)
+	w, err := storage.WorkflowOwnedBy(app, wid)
+	if err != nil {
+		return w, err
+	}
+	return w, app.Authorize(action)
+}
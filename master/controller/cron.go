@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"clock/v3/master/param"
+	"clock/v3/storage"
+
+	"github.com/labstack/echo/v4"
+)
+
+//PreviewCron 使用和 worker 相同的解析器 返回接下来 n 次的执行时间
+func PreviewCron(c echo.Context) error {
+	resp := param.BuildResp()
+
+	q := param.CronPreview{}
+	if err := c.Bind(&q); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[cron preview] invalidate param found: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+	if q.Timezone == "" {
+		q.Timezone = "Asia/Shanghai"
+	}
+	if q.N < 1 {
+		q.N = 5
+	}
+	if q.N > 100 {
+		q.N = 100
+	}
+
+	times, err := storage.PreviewExpression(q.Expression, q.Timezone, time.Now(), q.N)
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[cron preview] err: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	next := make([]string, 0, len(times))
+	for _, t := range times {
+		next = append(next, t.Format(time.RFC3339))
+	}
+	resp.Data = next
+	return c.JSON(http.StatusOK, resp)
+}
@@ -15,14 +15,15 @@ import (
 //Ping 健康检查
 func Ping(c echo.Context) (err error) {
 	resp := param.BuildResp()
-	// 1、ping mongo
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-	if err := storage.Mdb.Client.Ping(ctx, nil); err != nil {
+	// 1、ping database
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := storage.DB.Ping(ctx); err != nil {
 		resp.Code = param.Failed
-		resp.Msg = fmt.Sprintf("[health] mongo ping err: %v", err)
+		resp.Msg = fmt.Sprintf("[health] database ping err: %v", err)
 		return c.JSON(http.StatusOK, resp)
 	}
-	log.Debugf("[health] mongo ping success")
+	log.Debugf("[health] database ping success")
 	// 2、ping redis
 	pong, err := storage.RCache.Ping()
 	if err != nil {
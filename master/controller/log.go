@@ -22,6 +22,12 @@ func GetLogs(c echo.Context) (err error) {
 		return c.JSON(http.StatusOK, resp)
 	}
 
+	if err := ownLogs(c, query.Tid); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[get logs] err: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
 	logs, err := storage.GetLogs(&query)
 	if err != nil {
 		resp.Code = param.Failed
@@ -51,6 +57,17 @@ func DeleteLogs(c echo.Context) error {
 		return c.JSON(http.StatusOK, resp)
 	}
 
+	if err := ownLogs(c, query.Tid); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[delete logs] err: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+	if err := CurrentApp(c).Authorize(storage.ActionWrite); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[delete logs] err: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
 	// 异步执行
 	go storage.DeleteLogs(&query)
 	return c.JSON(http.StatusOK, resp)
@@ -3,8 +3,10 @@ package controller
 import (
 	"clock/v3/master/param"
 	"clock/v3/storage"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	log "github.com/sirupsen/logrus"
@@ -22,6 +24,11 @@ func GetTasks(c echo.Context) (err error) {
 		return c.JSON(http.StatusOK, resp)
 	}
 
+	// app 只能看到自己的任务
+	if app := CurrentApp(c); !app.IsRoot() {
+		query.Aid = app.Aid
+	}
+
 	tasks, err := storage.GetTasks(&query)
 	if err != nil {
 		resp.Code = param.Failed
@@ -44,7 +51,7 @@ func GetTask(c echo.Context) (err error) {
 
 	taskId := c.Param("tid") // ObjectID
 
-	t, err := storage.GetTask(taskId)
+	t, err := authorizeTask(c, taskId, storage.ActionRead)
 	if err != nil {
 		resp.Code = param.Failed
 		resp.Msg = fmt.Sprintf("[get task] error to query the task with: %v", err)
@@ -68,12 +75,43 @@ func PostTask(c echo.Context) error {
 		return c.JSON(http.StatusOK, resp)
 	}
 
+	app := CurrentApp(c)
+	typ := t.Type
+	if typ == "" {
+		typ = storage.HTTPTask
+	}
+	if err := app.Authorize(storage.ActionWrite, typ); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[post task] err: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+	if t.Force {
+		if err := app.Authorize(storage.ActionForce); err != nil {
+			resp.Code = param.Failed
+			resp.Msg = fmt.Sprintf("[post task] err: %v", err)
+			return c.JSON(http.StatusOK, resp)
+		}
+	}
+	// 任务属于创建它的 app root 可以指定 aid
+	if !app.IsRoot() {
+		t.Aid = app.Aid
+	}
+	if err := app.AuthorizeSigner(t); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[post task] err: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+	t.CreatedBy = app.Actor()
+	t.UpdatedBy = t.CreatedBy
+
 	if err := storage.PostTask(&t); err != nil {
 		resp.Code = param.Failed
 		resp.Msg = fmt.Sprintf("[post task] err: %v", err)
 		return c.JSON(http.StatusOK, resp)
 	}
 
+	audit(c, storage.AuditCreate, nil, &t)
+
 	resp.Data = t.Tid
 	return c.JSON(http.StatusOK, resp)
 }
@@ -82,11 +120,19 @@ func RunTask(c echo.Context) error {
 	taskId := c.Param("tid")
 	resp := param.BuildResp()
 
+	t, err := authorizeTask(c, taskId, storage.ActionRun)
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[run task] error to query the task with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
 	if err := storage.RunTask(taskId); err != nil {
 		resp.Code = param.Failed
 		resp.Msg = fmt.Sprintf("[run task] error run task with: %v", err)
 		return c.JSON(http.StatusOK, resp)
 	}
+	audit(c, storage.AuditRun, &t, nil)
 
 	return c.JSON(http.StatusOK, resp)
 }
@@ -96,20 +142,99 @@ func DeleteTask(c echo.Context) error {
 
 	resp := param.BuildResp()
 
+	old, err := authorizeTask(c, taskId, storage.ActionWrite)
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[delete task] error to query the task with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
 	if err := storage.DeleteTask(taskId); err != nil {
 		resp.Code = param.Failed
 		resp.Msg = fmt.Sprintf("[delete task] error to delete task with:%v", err)
 		return c.JSON(http.StatusOK, resp)
 	}
+	audit(c, storage.AuditDelete, &old, nil)
 
 	return c.JSON(http.StatusOK, resp)
 }
 
+//UpdateTask 全量或者部分更新任务 没有传的字段保持不变
+func UpdateTask(c echo.Context) error {
+	taskId := c.Param("tid")
+	resp := param.BuildResp()
+
+	old, err := authorizeTask(c, taskId, storage.ActionWrite)
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[update task] error to query the task with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	patch := make(map[string]interface{})
+	if err := json.NewDecoder(c.Request().Body).Decode(&patch); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[update task] invalidate param found: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	app := CurrentApp(c)
+	// 修改作业类型的时候新的类型也需要有权限
+	if typ, ok := patch["type"].(string); ok {
+		if err := app.Authorize(storage.ActionWrite, typ); err != nil {
+			resp.Code = param.Failed
+			resp.Msg = fmt.Sprintf("[update task] err: %v", err)
+			return c.JSON(http.StatusOK, resp)
+		}
+	}
+	if force, _ := patch["force"].(bool); force {
+		if err := app.Authorize(storage.ActionForce); err != nil {
+			resp.Code = param.Failed
+			resp.Msg = fmt.Sprintf("[update task] err: %v", err)
+			return c.JSON(http.StatusOK, resp)
+		}
+	}
+	// 只有 root 可以修改任务所属的 app
+	if !app.IsRoot() {
+		delete(patch, "aid")
+	}
+	// 合并之后的 payload 整个替换 签名使用的 app 需要属于调用方
+	merged := old
+	if payload, ok := patch["payload"].(map[string]interface{}); ok {
+		merged.Payload = payload
+	}
+	if err := app.AuthorizeSigner(merged); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[update task] err: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+	patch["updated_by"] = app.Actor()
+
+	t, err := storage.UpdateTask(taskId, patch)
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[update task] err: %v", err)
+		log.Error(resp.Msg)
+		return c.JSON(http.StatusOK, resp)
+	}
+	audit(c, storage.AuditModify, &old, &t)
+
+	resp.Data = t
+	return c.JSON(http.StatusOK, resp)
+}
+
 //DisableTask 禁用/启动任务
 func DisableTask(c echo.Context) error {
 	taskId := c.Param("tid")
 	resp := param.BuildResp()
 
+	old, err := authorizeTask(c, taskId, storage.ActionWrite)
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[disable task] error to query the task with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
 	t := param.DisableTask{}
 
 	if err := c.Bind(&t); err != nil {
@@ -118,12 +243,15 @@ func DisableTask(c echo.Context) error {
 		return c.JSON(http.StatusOK, resp)
 	}
 
-	if err := storage.DisableTask(taskId, storage.Struct2bsonD(t, "json").Map()); err != nil {
+	fields := storage.Struct2bsonD(t, "json").Map()
+	fields["updated_by"] = CurrentApp(c).Actor()
+	if err := storage.DisableTask(taskId, fields); err != nil {
 		resp.Msg = fmt.Sprintf("[disable task] error to query task from db with: %v", err)
 		log.Error(resp.Msg)
 
 		return c.JSON(http.StatusOK, resp)
 	}
+	auditUpdated(c, storage.AuditDisable, old)
 
 	return c.JSON(http.StatusOK, resp)
 }
@@ -133,6 +261,13 @@ func NewSpecTask(c echo.Context) error {
 	taskId := c.Param("tid")
 	resp := param.BuildResp()
 
+	old, err := authorizeTask(c, taskId, storage.ActionWrite)
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[spec task] error to query the task with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
 	t := param.NewSpecTask{}
 
 	if err := c.Bind(&t); err != nil {
@@ -141,11 +276,64 @@ func NewSpecTask(c echo.Context) error {
 		return c.JSON(http.StatusOK, resp)
 	}
 
-	if err := storage.ModifyTask(taskId, storage.Struct2bsonD(t, "json").Map()); err != nil {
+	fields := storage.Struct2bsonD(t, "json").Map()
+	fields["updated_by"] = CurrentApp(c).Actor()
+	if err := storage.ModifyTask(taskId, fields); err != nil {
 		resp.Msg = fmt.Sprintf("[spec task] error to query task from db with: %v", err)
 		log.Error(resp.Msg)
 		return c.JSON(http.StatusOK, resp)
 	}
+	auditUpdated(c, storage.AuditModify, old)
 
 	return c.JSON(http.StatusOK, resp)
 }
+
+//StreamTaskRun 通过 SSE 推送正在运行的任务的实时输出 rid 为 latest 时跟随接下来的一次运行
+//每一条消息为 json 格式的 LogLine 收到最终状态之后结束
+func StreamTaskRun(c echo.Context) error {
+	resp := param.BuildResp()
+	ctx := c.Request().Context()
+
+	if _, err := authorizeTask(c, c.Param("tid"), storage.ActionRead); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[stream task run] error to query the task with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	msg, err := storage.TailRun(ctx, c.Param("tid"), c.Param("rid"), 256)
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[stream task run] error to tail the run with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	// 定时发送注释 防止代理因为长时间没有数据断开连接
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case line, ok := <-msg.Channel:
+			if !ok {
+				return nil
+			}
+			if _, err := fmt.Fprintf(res, "data: %s\n\n", line); err != nil {
+				return nil
+			}
+			res.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
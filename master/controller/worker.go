@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"clock/v3/master/param"
+	"clock/v3/storage"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+//GetWorkers 注册过的 worker 包括最近一次心跳 正在执行的任务以及状态 下线的 worker 在 cluster.retain 之内为 dead
+func GetWorkers(c echo.Context) error {
+	resp := param.BuildResp()
+
+	workers, err := storage.ListWorkers()
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[get workers] error to get the workers from cache with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	resp.Data = workers
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"clock/v3/master/param"
+	"clock/v3/storage"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+//GetWorkflows 列表
+func GetWorkflows(c echo.Context) error {
+	var query storage.WorkflowQuery
+
+	resp := param.BuildResp()
+
+	if err := c.Bind(&query); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[get workflows] error to get the query param with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	// app 只能看到自己的工作流
+	if app := CurrentApp(c); !app.IsRoot() {
+		query.Aid = app.Aid
+	}
+
+	workflows, err := storage.GetWorkflows(&query)
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[get workflows] error to get the workflows from db with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	resp.Data = param.ListResponse{
+		Items:     workflows,
+		PageQuery: query,
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+//GetWorkflow 得到某一个
+func GetWorkflow(c echo.Context) error {
+	resp := param.BuildResp()
+
+	w, err := authorizeWorkflow(c, c.Param("wid"), storage.ActionRead)
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[get workflow] error to query the workflow with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	resp.Data = w
+	return c.JSON(http.StatusOK, resp)
+}
+
+//PostWorkflow 新增一个工作流
+func PostWorkflow(c echo.Context) error {
+	resp := param.BuildResp()
+
+	w := storage.Workflow{}
+	if err := c.Bind(&w); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[post workflow] invalidate param found: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	app := CurrentApp(c)
+	if err := app.Authorize(storage.ActionWrite); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[post workflow] err: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+	// 工作流属于创建它的 app 节点只能是该 app 的任务
+	if !app.IsRoot() {
+		w.Aid = app.Aid
+	}
+
+	if err := storage.PostWorkflow(&w); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[post workflow] err: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	resp.Data = w.Wid
+	return c.JSON(http.StatusOK, resp)
+}
+
+//RunWorkflow 手动触发 工作流耗时较长 异步执行 结果通过运行记录查看
+func RunWorkflow(c echo.Context) error {
+	wid := c.Param("wid")
+	resp := param.BuildResp()
+
+	if _, err := authorizeWorkflow(c, wid, storage.ActionRun); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[run workflow] error to query the workflow with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	go func() {
+		if err := storage.RunWorkflow(wid); err != nil {
+			log.Errorf("[run workflow] error run workflow %s with: %v", wid, err)
+		}
+	}()
+	return c.JSON(http.StatusOK, resp)
+}
+
+//DeleteWorkflow 删除工作流
+func DeleteWorkflow(c echo.Context) error {
+	resp := param.BuildResp()
+
+	if _, err := authorizeWorkflow(c, c.Param("wid"), storage.ActionWrite); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[delete workflow] error to query the workflow with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	if err := storage.DeleteWorkflow(c.Param("wid")); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[delete workflow] error to delete workflow with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+//DisableWorkflow 禁用/启动工作流
+func DisableWorkflow(c echo.Context) error {
+	resp := param.BuildResp()
+
+	if _, err := authorizeWorkflow(c, c.Param("wid"), storage.ActionWrite); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[disable workflow] error to query the workflow with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	d := param.DisableTask{}
+	if err := c.Bind(&d); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[disable workflow] invalidate param found: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	if err := storage.DisableWorkflow(c.Param("wid"), d.Disable); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[disable workflow] error to disable workflow with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+//GetWorkflowRuns 工作流运行记录
+func GetWorkflowRuns(c echo.Context) error {
+	var query storage.WorkflowRunQuery
+
+	resp := param.BuildResp()
+
+	if _, err := authorizeWorkflow(c, c.Param("wid"), storage.ActionRead); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[get workflow runs] error to query the workflow with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	if err := c.Bind(&query); err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[get workflow runs] error to get the query param with: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+	query.Wid = c.Param("wid")
+
+	runs, err := storage.GetWorkflowRuns(&query)
+	if err != nil {
+		resp.Code = param.Failed
+		resp.Msg = fmt.Sprintf("[get workflow runs] error to get the runs: %v", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	resp.Data = param.ListResponse{
+		Items:     runs,
+		PageQuery: query,
+	}
+	return c.JSON(http.StatusOK, resp)
+}
@@ -45,12 +45,29 @@ func main() {
 
 	// 设置配置文件和静态变量
 	config.SetConfig(filePath)
+	if gitHash != "" {
+		storage.Version = gitHash
+	}
 
 	if err := storage.SetDb(); err != nil {
 		log.Fatalf("[main] set up error: %v", err)
 	}
 
 	defer storage.RevokeDb()
+
+	if storage.AuthEnabled() && config.Config.GetString("auth.root_secret") == "" {
+		log.Warn("[main] auth.root_secret 为空 root 认证已禁用 需要配置之后才能管理 app")
+	}
+
+	// 单机模式 master 进程内同时运行调度器，配合 cache.driver: memory 使用
+	if config.Config.GetBool("server.standalone") {
+		if err := storage.InitScheduler(); err != nil {
+			log.Fatalf("[main] 创建调度器发生错误: %v", err)
+		}
+		go storage.SubCronJob(make(chan os.Signal, 1))
+		defer storage.StopScheduler()
+	}
+
 	address := config.Config.GetString("server.host")
 	if address == "" {
 		log.Fatal("can not find any server host config")
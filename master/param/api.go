@@ -12,6 +12,13 @@ type (
 		Index int `query:"index" json:"index"`
 		Total int `json:"total"`
 	}
+
+	// 预览 cron 表达式接下来的执行时间
+	CronPreview struct {
+		Expression string `query:"expression"`
+		Timezone   string `query:"timezone"`
+		N          int    `query:"n"` // 默认 5 最多 100
+	}
 )
 
 // 返回
@@ -32,6 +39,22 @@ type (
 		Disable bool `json:"disable"`
 	}
 
+	// 新增 app 其他字段由服务端维护
+	NewApp struct {
+		AppName string `json:"app_name"`
+		Role    string `json:"role"` // admin operator viewer 默认 operator
+	}
+
+	// 修改 app 名称
+	AppName struct {
+		AppName string `json:"app_name"`
+	}
+
+	// 修改 app 角色
+	AppRole struct {
+		Role string `json:"role"` // admin operator viewer
+	}
+
 	//新的修改任务 api
 	NewSpecTask struct {
 		Expression string `json:"expression"`
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"clock/v3/storage"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+//workerCollector 每次抓取的时候从 cache 中读取 worker 的注册信息
+type workerCollector struct {
+	workers *prometheus.Desc
+	up      *prometheus.Desc
+	running *prometheus.Desc
+}
+
+func newWorkerCollector() *workerCollector {
+	return &workerCollector{
+		workers: prometheus.NewDesc("clock_workers", "number of registered workers by status", []string{"status"}, nil),
+		up:      prometheus.NewDesc("clock_worker_up", "whether the worker is alive (1) or dead (0)", []string{"worker"}, nil),
+		running: prometheus.NewDesc("clock_worker_running_tasks", "number of tasks running on the worker", []string{"worker"}, nil),
+	}
+}
+
+func (c *workerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.workers
+	ch <- c.up
+	ch <- c.running
+}
+
+func (c *workerCollector) Collect(ch chan<- prometheus.Metric) {
+	workers, err := storage.ListWorkers()
+	if err != nil {
+		log.Errorf("[metrics] list workers err: %v", err)
+		return
+	}
+	counts := map[string]int{storage.WorkerAlive: 0, storage.WorkerDead: 0}
+	for _, w := range workers {
+		counts[w.Status]++
+		up := 0.0
+		if w.Status == storage.WorkerAlive {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, up, w.Id)
+		ch <- prometheus.MustNewConstMetric(c.running, prometheus.GaugeValue, float64(len(w.Running)), w.Id)
+	}
+	for status, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.workers, prometheus.GaugeValue, float64(n), status)
+	}
+}
@@ -1,6 +1,12 @@
 package server
 
 import (
+	"bytes"
+	"clock/v3/master/controller"
+	"clock/v3/master/param"
+	"clock/v3/storage"
+	"io/ioutil"
+	"net/http"
 	"strconv"
 	"time"
 
@@ -36,3 +42,43 @@ func LogrusLogger(next echo.HandlerFunc) echo.HandlerFunc {
 		return err
 	}
 }
+
+//Auth 认证 /v1 下的接口 认证成功之后把调用方写入 context
+//HMAC 签名需要计算请求体的 hash 读取之后重新放回请求中
+func Auth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !storage.AuthEnabled() {
+			return next(c)
+		}
+		req := c.Request()
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		app, err := storage.Authenticate(req.Context(), req, body)
+		if err != nil {
+			logrus.Warnf("[auth] %s %s %s: %v", c.RealIP(), req.Method, req.RequestURI, err)
+			resp := param.BuildResp()
+			resp.Code = param.Failed
+			resp.Msg = storage.AuthFailedErr.Error()
+			return c.JSON(http.StatusUnauthorized, resp)
+		}
+		c.Set(controller.AppContextKey, app)
+		return next(c)
+	}
+}
+
+//RootOnly 只允许 root 调用 例如管理 app 以及时区
+func RootOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !controller.CurrentApp(c).IsRoot() {
+			resp := param.BuildResp()
+			resp.Code = param.Failed
+			resp.Msg = "permission denied"
+			return c.JSON(http.StatusForbidden, resp)
+		}
+		return next(c)
+	}
+}
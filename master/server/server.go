@@ -1,10 +1,15 @@
 package server
 
 import (
+	"clock/v3/config"
 	"clock/v3/master/controller"
+	"fmt"
+	"net"
 
 	echoprometheus "github.com/globocom/echo-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -22,32 +27,64 @@ func addApi(e *echo.Echo) {
 
 	v1 := e.Group("/v1")
 	{
-		//v1.Use(middleware.JWTWithConfig(createJWTConfig())) 暂时取消登录中间件
+		// api key 或者 HMAC 签名认证 app 只能管理自己的任务以及工作流
+		v1.Use(Auth)
 		t := v1.Group("/task")
 		{
 			t.GET("", controller.GetTasks)
 			t.GET("/:tid", controller.GetTask)
 			t.POST("", controller.PostTask)
 			t.POST("/run/:tid", controller.RunTask)
+			t.PUT("/:tid", controller.UpdateTask)
 			t.DELETE("/:tid", controller.DeleteTask)
 			t.PUT("/:tid/disable", controller.DisableTask)
 			t.PUT("/:tid/new_spec", controller.NewSpecTask)
+			t.GET("/:tid/runs/:rid/stream", controller.StreamTaskRun)
 		}
 
+		w := v1.Group("/workflow")
+		{
+			w.GET("", controller.GetWorkflows)
+			w.GET("/:wid", controller.GetWorkflow)
+			w.GET("/:wid/runs", controller.GetWorkflowRuns)
+			w.POST("", controller.PostWorkflow)
+			w.POST("/run/:wid", controller.RunWorkflow)
+			w.DELETE("/:wid", controller.DeleteWorkflow)
+			w.PUT("/:wid/disable", controller.DisableWorkflow)
+		}
+
+		v1.GET("/cron/preview", controller.PreviewCron)
+
 		tz := v1.Group("/timezone")
 		{
 			tz.GET("", controller.GetAllSupportTimezone)
 			tz.GET("/:tid", controller.GetOneSupportTimezone)
-			tz.POST("", controller.CreateSupportTimezone)
-			tz.DELETE("/:tid", controller.DeleteSupportTimezone)
+			tz.POST("", controller.CreateSupportTimezone, RootOnly)
+			tz.DELETE("/:tid", controller.DeleteSupportTimezone, RootOnly)
 		}
 
+		a := v1.Group("/app", RootOnly)
+		{
+			a.GET("", controller.GetApps)
+			a.POST("", controller.PostApp)
+			a.PUT("/:aid", controller.ModifyAppName)
+			a.PUT("/:aid/role", controller.ModifyAppRole)
+			a.PUT("/:aid/keys", controller.ResetAppKeys)
+			a.DELETE("/:aid", controller.DeleteApp)
+		}
+
+		// worker 的注册信息 只有 root 可以查看
+		v1.GET("/worker", controller.GetWorkers, RootOnly)
+
 		l := v1.Group("/log")
 		{
 			l.GET("", controller.GetLogs)
 			l.DELETE("", controller.DeleteLogs)
 		}
 
+		// 任务变更的审计日志 只能查询
+		v1.GET("/audit", controller.GetAudits)
+
 	}
 
 }
@@ -61,12 +98,39 @@ func addHealthCheck(e *echo.Echo) {
 func addMetrics(e *echo.Echo) {
 	// 这里 subsystem 要下划线 _, 不能是 - 否则 metrics 会看不到对应的
 	e.Use(echoprometheus.MetricsMiddleware())
+	// worker 的存活状态以及正在执行的任务数
+	if err := prometheus.Register(newWorkerCollector()); err != nil {
+		log.Errorf("[metrics] register worker collector err: %v", err)
+	}
 	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
 }
 
+//ipExtractor 客户端 ip 用于审计日志以及请求日志 默认使用连接的地址
+//只有配置了 server.trusted_proxies 之后才从这些代理的 X-Forwarded-For 中获取
+func ipExtractor() (echo.IPExtractor, error) {
+	proxies := config.Config.GetStringSlice("server.trusted_proxies")
+	if len(proxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range proxies {
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server.trusted_proxies %s: %w", proxy, err)
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
 //CreateEngine echo 实例
 func CreateEngine() (*echo.Echo, error) {
 	e := echo.New()
+	extractor, err := ipExtractor()
+	if err != nil {
+		return nil, err
+	}
+	e.IPExtractor = extractor
 
 	addMiddleware(e)
 	addMetrics(e)
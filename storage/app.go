@@ -6,78 +6,57 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-//GetAllApp 获取所有应用
+//GetAllApp 获取所有应用 不返回 appKey 以及 secretKey
 func GetAllApp(ctx context.Context) ([]App, error) {
-	var err error
-
-	apps := make([]App, 0)
-	opts := options.Find()
-	opts.SetSort(bson.D{{"create_at", DESC}})
-	// filter appKey and secretKey
-	opts.SetProjection(bson.D{
-		{"app_key", 0},
-		{"secret_key", 0},
-	})
-	cursor, err := AppCol.Find(ctx, bson.D{}, opts)
+	apps, err := DB.App().FindAll(ctx)
 	if err != nil {
 		return apps, errors.Wrap(err, "get app err")
 	}
-	if err = cursor.All(ctx, &apps); err != nil {
-		return apps, err
-	}
-
 	return apps, nil
-
 }
 
-//GetAppKeyAndSecretKey
+//GetAppKeyAndSecretKey 已经删除的 app 返回 AppUnavailableErr
 func GetAppKeyAndSecretKey(ctx context.Context, aid string) (appKey, secretKey string, err error) {
 	app, err := GetOneApp(ctx, aid)
 	if err != nil {
 		return "", "", err
 	}
+	if app.IsDeleted != 0 {
+		return "", "", AppUnavailableErr
+	}
 	return app.AppKey, app.SecretKey, nil
 }
 
-//CreateApp 创建一个应用
+//CreateApp 创建一个应用 没有指定角色的时候为 operator
 func CreateApp(ctx context.Context, a *App) error {
+	role, err := validRole(a.Role)
+	if err != nil {
+		return err
+	}
+	a.Role = role
 	a.CreateAt = time.Now().Unix()
 	a.UpdateAt = a.CreateAt
 	a.Id = primitive.NewObjectID()
 	a.Aid = a.Id.Hex()
-	// TODO: 生成 appKey, secretKey
-	a.AppKey = ""
-	a.SecretKey = ""
-	res, err := AppCol.InsertOne(ctx, &a)
+	appKey, secretKey, err := genAppKeys()
 	if err != nil {
-		return errors.Wrap(err, "新增 app 失败")
+		return errors.Wrap(err, "生成 appKey, secretKey 失败")
 	}
-	log.Debugf("[model] insert id is: %v", res.InsertedID)
-
-	return nil
+	a.AppKey = appKey
+	a.SecretKey = secretKey
+	return DB.App().Insert(ctx, a)
 }
 
 //GetOneApp 获取一个应用
 func GetOneApp(ctx context.Context, aid string) (App, error) {
-	var a App
-
-	oid, err := primitive.ObjectIDFromHex(aid)
+	a, err := DB.App().FindOne(ctx, aid)
 	if err != nil {
-		return a, errors.Wrap(err, fmt.Sprintf("获取 app %s 失败", oid))
+		return a, errors.Wrap(err, fmt.Sprintf("获取 app %s 失败", aid))
 	}
-
-	if err = AppCol.FindOne(ctx, bson.D{{"_id", oid}}).Decode(&a); err != nil {
-		return a, errors.Wrap(err, fmt.Sprintf("decode app err: %v", err))
-	}
-
 	return a, nil
-
 }
 
 //ModifyAppName
@@ -87,21 +66,66 @@ func ModifyAppName(ctx context.Context, aid string, name string) error {
 		return err
 	}
 
-	res, err := AppCol.UpdateOne(ctx, bson.D{{"name", app.AppName}}, bson.M{
-		"$set": bson.M{
-			"update_at": time.Now().Unix(),
-			"name":      name,
-		},
-	})
-	if err != nil {
+	if err = DB.App().Update(ctx, app.Aid, map[string]interface{}{
+		"update_at": time.Now().Unix(),
+		"app_name":  name,
+	}); err != nil {
 		return errors.Wrap(err, "更新 AppName 失败")
 	}
 
-	log.Debugf("[model] match count: %v, modify count: %v", res.MatchedCount, res.ModifiedCount)
+	return nil
+}
+
+//ModifyAppRole 修改应用的角色
+func ModifyAppRole(ctx context.Context, aid string, role string) error {
+	if role == "" {
+		return errors.New("role is required")
+	}
+	if _, err := validRole(role); err != nil {
+		return err
+	}
+	app, err := GetOneApp(ctx, aid)
+	if err != nil {
+		return err
+	}
+
+	if err = DB.App().Update(ctx, app.Aid, map[string]interface{}{
+		"update_at": time.Now().Unix(),
+		"role":      role,
+	}); err != nil {
+		return errors.Wrap(err, "更新 role 失败")
+	}
 
 	return nil
 }
 
+//ResetAppKeys 重新生成应用的 appKey 以及 secretKey 之前的密钥马上失效
+//用于密钥泄露或者之前的版本创建的没有 secretKey 的应用
+func ResetAppKeys(ctx context.Context, aid string) (App, error) {
+	app, err := GetOneApp(ctx, aid)
+	if err != nil {
+		return app, err
+	}
+	if app.IsDeleted != 0 {
+		return app, AppUnavailableErr
+	}
+	appKey, secretKey, err := genAppKeys()
+	if err != nil {
+		return app, errors.Wrap(err, "生成 appKey, secretKey 失败")
+	}
+	app.UpdateAt = time.Now().Unix()
+	if err = DB.App().Update(ctx, app.Aid, map[string]interface{}{
+		"update_at":  app.UpdateAt,
+		"app_key":    appKey,
+		"secret_key": secretKey,
+	}); err != nil {
+		return app, errors.Wrap(err, "更新密钥失败")
+	}
+	app.AppKey = appKey
+	app.SecretKey = secretKey
+	return app, nil
+}
+
 //DeleteApp 删除一个应用
 func DeleteApp(ctx context.Context, aid string) error {
 	app, err := GetOneApp(ctx, aid)
@@ -110,17 +134,12 @@ func DeleteApp(ctx context.Context, aid string) error {
 	}
 
 	now := time.Now().Unix()
-	res, err := AppCol.UpdateOne(ctx, bson.D{{"_id", app.Id}}, bson.M{
-		"$set": bson.M{
-			"update_at":  now,
-			"is_deleted": now,
-		},
-	})
-	if err != nil {
+	if err = DB.App().Update(ctx, app.Aid, map[string]interface{}{
+		"update_at":  now,
+		"is_deleted": now,
+	}); err != nil {
 		return errors.Wrap(err, fmt.Sprintf("删除 app %s 失败", aid))
 	}
 
-	log.Debugf("[model] delete count: %d", res.ModifiedCount)
-
 	return nil
 }
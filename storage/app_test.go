@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp(t *testing.T) {
+	for name, newDB := range testDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			setTestStorage(t, newDB())
+			defer RevokeDb()
+			ctx := context.Background()
+
+			a := App{AppName: "clock"}
+			assert.Nil(t, CreateApp(ctx, &a))
+			assert.NotEmpty(t, a.Aid)
+			assert.Len(t, a.AppKey, 32)
+			assert.Len(t, a.SecretKey, 64)
+			appKey, secretKey, err := GetAppKeyAndSecretKey(ctx, a.Aid)
+			assert.Nil(t, err)
+			assert.Equal(t, a.AppKey, appKey)
+			assert.Equal(t, a.SecretKey, secretKey)
+
+			assert.Nil(t, ModifyAppName(ctx, a.Aid, "clock2"))
+			got, err := GetOneApp(ctx, a.Aid)
+			assert.Nil(t, err)
+			assert.Equal(t, "clock2", got.AppName)
+
+			assert.Nil(t, DeleteApp(ctx, a.Aid))
+			apps, err := GetAllApp(ctx)
+			assert.Nil(t, err)
+			assert.Len(t, apps, 1)
+			assert.NotZero(t, apps[0].IsDeleted)
+			assert.Empty(t, apps[0].SecretKey)
+			_, _, err = GetAppKeyAndSecretKey(ctx, a.Aid)
+			assert.Equal(t, AppUnavailableErr, err)
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//auditIgnoreFields 每次保存或者执行都会变化的字段 不记录在 diff 中
+var auditIgnoreFields = []string{"update_at", "next_run_at", "last_fire_at"}
+
+//auditRedacted 凭证在审计日志中的占位 auditRedactedChanged 表示修改过
+const (
+	auditRedacted        = "[redacted]"
+	auditRedactedChanged = "[redacted:changed]"
+)
+
+//auditSecretHeaders payload.headers 中需要脱敏的请求头
+var auditSecretHeaders = map[string]bool{"Authorization": true, "Proxy-Authorization": true, "Cookie": true}
+
+//auditWhereDb 按照 tid aid actor action 以及时间范围 (left_ts, right_ts) 查询
+//时间范围放在同一个 create_at 条件中 mongo 以及 sql 都可以正确处理
+func auditWhereDb(query *AuditQuery) bson.D {
+	queryDB := GetWhereDb(query, []string{"lid"})
+	rng := bson.D{}
+	if query.LeftTs > 0 {
+		rng = append(rng, bson.E{Key: "$gt", Value: query.LeftTs})
+	}
+	if query.RightTs > 0 {
+		rng = append(rng, bson.E{Key: "$lt", Value: query.RightTs})
+	}
+	if len(rng) > 0 {
+		queryDB = append(queryDB, bson.E{Key: "create_at", Value: rng})
+	}
+	return queryDB
+}
+
+//taskDocument 以 json tag 为 key 的任务字段 和 api 返回的格式一致
+func taskDocument(t *Task) map[string]interface{} {
+	doc := make(map[string]interface{})
+	if t == nil {
+		return doc
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return doc
+	}
+	_ = json.Unmarshal(b, &doc)
+	for _, key := range auditIgnoreFields {
+		delete(doc, key)
+	}
+	return doc
+}
+
+//redactSecrets payload 中的凭证不写入审计日志 轮换之后旧的凭证也不会留在审计日志中 只记录是否修改
+//查询参数以及请求体中也可能带有凭证 查询参数只保留 key 请求体整个脱敏
+func redactSecrets(before, after map[string]interface{}) {
+	bp, _ := before["payload"].(map[string]interface{})
+	ap, _ := after["payload"].(map[string]interface{})
+	for _, key := range []string{"body", "data"} {
+		redactField(bp, ap, key)
+	}
+	bQuery, _ := bp["query"].(map[string]interface{})
+	aQuery, _ := ap["query"].(map[string]interface{})
+	for _, query := range []map[string]interface{}{bQuery, aQuery} {
+		for name := range query {
+			redactField(bQuery, aQuery, name)
+		}
+	}
+	bAuth, _ := bp["auth"].(map[string]interface{})
+	aAuth, _ := ap["auth"].(map[string]interface{})
+	for _, key := range []string{"password", "token"} {
+		redactField(bAuth, aAuth, key)
+	}
+	bHeaders, _ := bp["headers"].(map[string]interface{})
+	aHeaders, _ := ap["headers"].(map[string]interface{})
+	for _, headers := range []map[string]interface{}{bHeaders, aHeaders} {
+		for name := range headers {
+			if auditSecretHeaders[http.CanonicalHeaderKey(name)] {
+				redactField(bHeaders, aHeaders, name)
+			}
+		}
+	}
+}
+
+//redactField 替换为占位 修改前后不同的时候修改之后的为 auditRedactedChanged
+func redactField(before, after map[string]interface{}, key string) {
+	b, bok := before[key]
+	a, aok := after[key]
+	if bok {
+		before[key] = auditRedacted
+	}
+	if aok {
+		after[key] = auditRedacted
+		if bok && !reflect.DeepEqual(a, b) {
+			after[key] = auditRedactedChanged
+		}
+	}
+}
+
+//diffTask 对比任务变更前后的字段 只保留发生变化的字段 payload 中的凭证已经脱敏
+func diffTask(before, after *Task) map[string]AuditChange {
+	b, a := taskDocument(before), taskDocument(after)
+	redactSecrets(b, a)
+	diff := make(map[string]AuditChange)
+	for key, value := range b {
+		if after, ok := a[key]; !ok || !reflect.DeepEqual(value, after) {
+			diff[key] = AuditChange{Before: rawJSON(value, true), After: rawJSON(after, ok)}
+		}
+	}
+	for key, value := range a {
+		if _, ok := b[key]; !ok {
+			diff[key] = AuditChange{Before: rawJSON(nil, false), After: rawJSON(value, true)}
+		}
+	}
+	return diff
+}
+
+//rawJSON 字段不存在的时候为 null
+func rawJSON(v interface{}, exists bool) json.RawMessage {
+	if !exists {
+		return json.RawMessage("null")
+	}
+	b, _ := json.Marshal(v)
+	return b
+}
+
+//RecordAudit 记录一次任务变更 新增时 before 为空 删除时 after 为空 手动执行没有 diff
+func RecordAudit(actor, ip, action string, before, after *Task) error {
+	t := after
+	if t == nil {
+		t = before
+	}
+	if t == nil {
+		return NotFoundErr
+	}
+
+	id := primitive.NewObjectID()
+	audit := AuditLog{
+		Id:       id,
+		Lid:      id.Hex(),
+		Tid:      t.Tid,
+		Aid:      t.Aid,
+		Actor:    actor,
+		Action:   action,
+		Diff:     map[string]AuditChange{},
+		Ip:       ip,
+		CreateAt: time.Now().Unix(),
+	}
+	if action != AuditRun {
+		audit.Diff = diffTask(before, after)
+	}
+	// 只修改了 disable 的记录为启用或者禁用 不论通过哪个接口修改
+	if _, ok := audit.Diff["disable"]; ok && len(audit.Diff) == 1 && after != nil {
+		audit.Action = AuditDisable
+		if !after.Disable {
+			audit.Action = AuditEnable
+		}
+	}
+
+	if err := DB.Audit().Insert(context.Background(), &audit); err != nil {
+		log.Errorf("[audit] insert audit of task %s err: %v", t.Tid, err)
+		return err
+	}
+	return nil
+}
+
+//GetAudits 查询审计日志 默认前 10
+func GetAudits(query *AuditQuery) ([]AuditLog, error) {
+	if query.Count < 1 {
+		query.Count = 10
+	}
+
+	if query.Index < 1 {
+		query.Index = 1
+	}
+
+	audits, err := DB.Audit().Find(context.Background(), query)
+	if err != nil {
+		log.Errorf("[audit] get audits err: %v", err)
+		return audits, err
+	}
+	return audits, nil
+}
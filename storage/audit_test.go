@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAudit(t *testing.T) {
+	for name, newDB := range testDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			setTestStorage(t, newDB())
+			defer RevokeDb()
+
+			app := App{AppName: "app1"}
+			assert.Nil(t, CreateApp(context.Background(), &app))
+			task := Task{Name: "a", Expression: "0 0 * * *", Type: BashTask, Aid: app.Aid,
+				Payload: map[string]interface{}{"command": "date"}}
+			assert.Nil(t, PostTask(&task))
+			assert.Nil(t, RecordAudit("root", "127.0.0.1", AuditCreate, nil, &task))
+
+			before, err := GetTask(task.Tid)
+			assert.Nil(t, err)
+			after, err := UpdateTask(task.Tid, map[string]interface{}{"name": "b"})
+			assert.Nil(t, err)
+			assert.Nil(t, RecordAudit("app1", "10.0.0.1", AuditModify, &before, &after))
+			assert.Nil(t, RecordAudit("app1", "10.0.0.1", AuditRun, &after, nil))
+
+			other := newBashTask(t, "date", false)
+			assert.Nil(t, RecordAudit("root", "127.0.0.1", AuditDelete, &other, nil))
+
+			query := AuditQuery{}
+			query.Tid = task.Tid
+			audits, err := GetAudits(&query)
+			assert.Nil(t, err)
+			assert.Equal(t, 3, query.Total)
+
+			byAction := make(map[string]AuditLog)
+			for _, a := range audits {
+				assert.Equal(t, app.Aid, a.Aid)
+				byAction[a.Action] = a
+			}
+			// 只记录发生变化的字段 update_at 不记录
+			modify := byAction[AuditModify]
+			assert.Equal(t, "10.0.0.1", modify.Ip)
+			assert.Len(t, modify.Diff, 1)
+			assert.JSONEq(t, `"a"`, string(modify.Diff["name"].Before))
+			assert.JSONEq(t, `"b"`, string(modify.Diff["name"].After))
+			assert.JSONEq(t, `null`, string(byAction[AuditCreate].Diff["name"].Before))
+			assert.JSONEq(t, `"a"`, string(byAction[AuditCreate].Diff["name"].After))
+			assert.Len(t, byAction[AuditRun].Diff, 0)
+
+			// 时间范围
+			query = AuditQuery{}
+			query.RightTs = time.Now().Add(-time.Hour).Unix()
+			audits, err = GetAudits(&query)
+			assert.Nil(t, err)
+			assert.Len(t, audits, 0)
+
+			query = AuditQuery{}
+			query.LeftTs = time.Now().Add(-time.Hour).Unix()
+			query.RightTs = time.Now().Add(time.Hour).Unix()
+			query.Action = AuditDelete
+			audits, err = GetAudits(&query)
+			assert.Nil(t, err)
+			assert.Len(t, audits, 1)
+			assert.Equal(t, other.Tid, audits[0].Tid)
+			assert.JSONEq(t, `{"command": "date"}`, string(audits[0].Diff["payload"].Before))
+			assert.JSONEq(t, `null`, string(audits[0].Diff["payload"].After))
+		})
+	}
+}
+
+func TestAuditRedactSecrets(t *testing.T) {
+	payload := func(password, cookie, token string) map[string]interface{} {
+		return map[string]interface{}{
+			"endpoint": "http://127.0.0.1/hook",
+			"auth":     map[string]interface{}{"type": "basic", "username": "u", "password": password},
+			"headers":  map[string]interface{}{"cookie": cookie, "X-Env": "prod"},
+			"query":    map[string]interface{}{"access_token": token},
+			"body":     `{"secret": "` + token + `"}`,
+		}
+	}
+	before := Task{Name: "a", Type: HTTPTask, Payload: payload("old", "c", "t1")}
+	after := Task{Name: "a", Type: HTTPTask, Payload: payload("new", "c", "t1")}
+
+	// 凭证不写入审计日志 只记录是否修改
+	diff := diffTask(&before, &after)
+	assert.JSONEq(t, `{"endpoint": "http://127.0.0.1/hook", "auth": {"type": "basic", "username": "u", "password": "[redacted]"},
+		"headers": {"cookie": "[redacted]", "X-Env": "prod"}, "query": {"access_token": "[redacted]"}, "body": "[redacted]"}`,
+		string(diff["payload"].Before))
+	assert.JSONEq(t, `{"endpoint": "http://127.0.0.1/hook", "auth": {"type": "basic", "username": "u", "password": "[redacted:changed]"},
+		"headers": {"cookie": "[redacted]", "X-Env": "prod"}, "query": {"access_token": "[redacted]"}, "body": "[redacted]"}`,
+		string(diff["payload"].After))
+
+	// 查询参数和请求体同样只记录是否修改
+	rotated := Task{Name: "a", Type: HTTPTask, Payload: payload("new", "c", "t2")}
+	diff = diffTask(&after, &rotated)
+	assert.NotContains(t, string(diff["payload"].Before), "t1")
+	assert.NotContains(t, string(diff["payload"].After), "t2")
+	assert.Contains(t, string(diff["payload"].After), `"access_token":"[redacted:changed]"`)
+	assert.Contains(t, string(diff["payload"].After), `"body":"[redacted:changed]"`)
+
+	diff = diffTask(nil, &after)
+	assert.NotContains(t, string(diff["payload"].After), "new")
+	assert.NotContains(t, string(diff["payload"].After), "t1")
+	assert.Len(t, diffTask(&after, &after), 0)
+	// 原来的任务不受影响
+	assert.Equal(t, "new", after.Payload["auth"].(map[string]interface{})["password"])
+}
+
+func TestAuditDisableToggle(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+
+	task := newBashTask(t, "date", false)
+	disabled := task
+	disabled.Disable = true
+	renamed := disabled
+	renamed.Name = "renamed"
+
+	// 通过修改接口只切换 disable 也记录为启用或者禁用
+	assert.Nil(t, RecordAudit("root", "127.0.0.1", AuditModify, &task, &disabled))
+	assert.Nil(t, RecordAudit("root", "127.0.0.1", AuditModify, &disabled, &task))
+	assert.Nil(t, RecordAudit("root", "127.0.0.1", AuditModify, &task, &renamed))
+
+	for action, total := range map[string]int{AuditDisable: 1, AuditEnable: 1, AuditModify: 1} {
+		query := AuditQuery{}
+		query.Tid = task.Tid
+		query.Action = action
+		_, err := GetAudits(&query)
+		assert.Nil(t, err)
+		assert.Equal(t, total, query.Total, action)
+	}
+}
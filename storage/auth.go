@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"clock/v3/config"
+	"context"
+	"crypto/hmac"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+//HeaderSecretKey api key 方式认证时携带 secret_key 的请求头
+const HeaderSecretKey = "X-Clock-Secret-Key"
+
+//RootApp 使用 auth.root_key 认证的调用方 可以管理所有 app 以及所有任务
+var RootApp = App{AppName: "root"}
+
+//IsRoot 是否是 root 调用方 root 没有 aid
+func (a App) IsRoot() bool {
+	return a.Aid == ""
+}
+
+//AuthEnabled 是否开启 api 认证 没有配置 auth.enable 的时候默认开启
+func AuthEnabled() bool {
+	return !config.Config.IsSet("auth.enable") || config.Config.GetBool("auth.enable")
+}
+
+//authSkew HMAC 认证时间戳允许的误差 默认 300s
+func authSkew() time.Duration {
+	skew := config.Config.GetDuration("auth.skew") * time.Second
+	if skew <= 0 {
+		skew = 300 * time.Second
+	}
+	return skew
+}
+
+//Authenticate 根据请求头认证调用方
+//请求头带有 X-Clock-Signature 时使用 HMAC 签名(用途为 CLOCK-API) 否则比较 X-Clock-Secret-Key
+//认证成功之后更新 app 的 last_used 以及 counter
+func Authenticate(ctx context.Context, req *http.Request, body []byte) (App, error) {
+	header := req.Header
+	appKey := header.Get(HeaderAppKey)
+	if appKey == "" {
+		return App{}, errors.Wrap(AuthFailedErr, "missing "+HeaderAppKey)
+	}
+
+	var app App
+	var secretKey string
+	if rootKey := config.Config.GetString("auth.root_key"); rootKey != "" && appKey == rootKey {
+		// 没有配置 auth.root_secret 的时候禁用 root
+		if secretKey = config.Config.GetString("auth.root_secret"); secretKey == "" {
+			return App{}, errors.Wrap(AuthFailedErr, "root is disabled")
+		}
+		app = RootApp
+	} else {
+		a, err := DB.App().FindByAppKey(ctx, appKey)
+		if err != nil || a.IsDeleted != 0 {
+			return App{}, errors.Wrap(AuthFailedErr, "unknown app key")
+		}
+		app, secretKey = a, a.SecretKey
+	}
+	if secretKey == "" {
+		return App{}, errors.Wrap(AuthFailedErr, "app has no secret key")
+	}
+
+	if header.Get(HeaderSignature) != "" {
+		if err := verifyRequest(appKey, secretKey, req, body); err != nil {
+			return App{}, errors.Wrap(AuthFailedErr, err.Error())
+		}
+	} else if !hmac.Equal([]byte(header.Get(HeaderSecretKey)), []byte(secretKey)) {
+		return App{}, errors.Wrap(AuthFailedErr, "invalid secret key")
+	}
+
+	if !app.IsRoot() {
+		if err := DB.App().Touch(ctx, app.Aid, time.Now().Unix()); err != nil {
+			log.Errorf("[auth] touch app %s err: %v", app.Aid, err)
+		}
+	}
+	return app, nil
+}
+
+//verifyRequest 校验签名 时间戳需要在 auth.skew 之内 并且 nonce 在有效期内不能重复使用
+func verifyRequest(appKey, secretKey string, req *http.Request, body []byte) error {
+	header := req.Header
+	ts, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	skew := authSkew()
+	if d := time.Since(time.Unix(ts, 0)); d > skew || d < -skew {
+		return errors.New("timestamp expired")
+	}
+	// api 使用单独的签名用途 http 任务请求的签名不能用于调用 api
+	if !verifySignature(SignScopeAPI, secretKey, req, body) {
+		return errors.New("invalid signature")
+	}
+	nonce := header.Get(HeaderNonce)
+	if nonce == "" {
+		return errors.New("missing nonce")
+	}
+	key := fmt.Sprintf("%s:nonce:%s:%s", config.Config.GetString("lease.prefix"), appKey, nonce)
+	ok, err := RCache.SetNX(key, 2*skew)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("nonce has been used")
+	}
+	return nil
+}
+
+//AuthorizeSigner 非 root 调用方的任务只能使用任务所属 app 的密钥对 http 请求签名
+//否则可以让 worker 使用其他 app(例如 admin) 的密钥签名 把请求发送到自己的服务之后冒用其身份
+func (a App) AuthorizeSigner(t Task) error {
+	if a.IsRoot() {
+		return nil
+	}
+	if aid, _ := t.Payload["aid"].(string); aid != "" && aid != t.Aid {
+		return fmt.Errorf("%w: payload.aid must be empty or the app of the task", PermissionDeniedErr)
+	}
+	return nil
+}
+
+//TaskOwnedBy 获取 app 拥有的任务 不属于该 app 的任务视为不存在 root 可以获取所有任务
+func TaskOwnedBy(app App, tid string) (Task, error) {
+	t, err := GetTask(tid)
+	if err != nil {
+		return t, err
+	}
+	if !app.IsRoot() && t.Aid != app.Aid {
+		return Task{}, NotFoundErr
+	}
+	return t, nil
+}
+
+//WorkflowOwnedBy 获取 app 拥有的工作流 不属于该 app 的工作流视为不存在
+func WorkflowOwnedBy(app App, wid string) (Workflow, error) {
+	w, err := GetWorkflow(wid)
+	if err != nil {
+		return w, err
+	}
+	if !app.IsRoot() && w.Aid != app.Aid {
+		return Workflow{}, NotFoundErr
+	}
+	return w, nil
+}
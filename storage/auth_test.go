@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate(t *testing.T) {
+	for name, newDB := range testDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			setTestStorage(t, newDB())
+			defer RevokeDb()
+			ctx := context.Background()
+			setTestConfig(t, "auth.root_key", "root")
+			setTestConfig(t, "auth.root_secret", "s3cret")
+
+			app := App{AppName: "billing"}
+			assert.Nil(t, CreateApp(ctx, &app))
+
+			// api key
+			req, _ := http.NewRequest("GET", "http://master/v1/task", nil)
+			req.Header.Set(HeaderAppKey, app.AppKey)
+			req.Header.Set(HeaderSecretKey, app.SecretKey)
+			got, err := Authenticate(ctx, req, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, app.Aid, got.Aid)
+			assert.False(t, got.IsRoot())
+
+			req.Header.Set(HeaderSecretKey, "wrong")
+			_, err = Authenticate(ctx, req, nil)
+			assert.EqualError(t, err, "invalid secret key: "+AuthFailedErr.Error())
+
+			// HMAC 签名 同一个 nonce 只能使用一次
+			req, _ = http.NewRequest("POST", "http://master/v1/task", nil)
+			assert.Nil(t, signRequest(req, SignScopeAPI, app.AppKey, app.SecretKey, []byte(`{"name": "a"}`)))
+			_, err = Authenticate(ctx, req, []byte(`{"name": "b"}`))
+			assert.EqualError(t, err, "invalid signature: "+AuthFailedErr.Error())
+
+			// 签名绑定 method 以及 url
+			other, _ := http.NewRequest("DELETE", "http://master/v1/task", nil)
+			other.Header = req.Header.Clone()
+			_, err = Authenticate(ctx, other, []byte(`{"name": "a"}`))
+			assert.EqualError(t, err, "invalid signature: "+AuthFailedErr.Error())
+			other, _ = http.NewRequest("POST", "http://billing/v1/task", nil)
+			other.Header = req.Header.Clone()
+			_, err = Authenticate(ctx, other, []byte(`{"name": "a"}`))
+			assert.EqualError(t, err, "invalid signature: "+AuthFailedErr.Error())
+
+			// http 任务请求的签名不能用于调用 api
+			task, _ := http.NewRequest("POST", "http://master/v1/task", nil)
+			assert.Nil(t, signRequest(task, SignScopeTask, app.AppKey, app.SecretKey, []byte(`{"name": "a"}`)))
+			_, err = Authenticate(ctx, task, []byte(`{"name": "a"}`))
+			assert.EqualError(t, err, "invalid signature: "+AuthFailedErr.Error())
+
+			_, err = Authenticate(ctx, req, []byte(`{"name": "a"}`))
+			assert.Nil(t, err)
+			_, err = Authenticate(ctx, req, []byte(`{"name": "a"}`))
+			assert.EqualError(t, err, "nonce has been used: "+AuthFailedErr.Error())
+
+			stale, _ := http.NewRequest("POST", "http://master/v1/task", nil)
+			stale.Header = req.Header.Clone()
+			stale.Header.Set(HeaderTimestamp, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+			stale.Header.Set(HeaderSignature, Sign(app.SecretKey, requestStringToSign(SignScopeAPI, stale, stale.Header.Get(HeaderContentSHA256))))
+			_, err = Authenticate(ctx, stale, []byte(`{"name": "a"}`))
+			assert.EqualError(t, err, "timestamp expired: "+AuthFailedErr.Error())
+
+			// 成功的请求记录调用次数
+			got, err = GetOneApp(ctx, app.Aid)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(2), got.Counter)
+			assert.NotZero(t, got.LastUsed)
+
+			// root
+			req, _ = http.NewRequest("GET", "http://master/v1/task", nil)
+			req.Header.Set(HeaderAppKey, "root")
+			req.Header.Set(HeaderSecretKey, "s3cret")
+			got, err = Authenticate(ctx, req, nil)
+			assert.Nil(t, err)
+			assert.True(t, got.IsRoot())
+
+			// 删除之后不能再认证
+			assert.Nil(t, DeleteApp(ctx, app.Aid))
+			req.Header.Set(HeaderAppKey, app.AppKey)
+			req.Header.Set(HeaderSecretKey, app.SecretKey)
+			_, err = Authenticate(ctx, req, nil)
+			assert.EqualError(t, err, "unknown app key: "+AuthFailedErr.Error())
+		})
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	setTestStorage(t, NewMemoryDatabase())
+	ctx := context.Background()
+	a, b := App{AppName: "a"}, App{AppName: "b"}
+	assert.Nil(t, CreateApp(ctx, &a))
+	assert.Nil(t, CreateApp(ctx, &b))
+
+	task := newBashTask(t, "date", false)
+	assert.Nil(t, DB.Task().Update(ctx, task.Tid, map[string]interface{}{"aid": a.Aid}))
+
+	_, err := TaskOwnedBy(a, task.Tid)
+	assert.Nil(t, err)
+	_, err = TaskOwnedBy(RootApp, task.Tid)
+	assert.Nil(t, err)
+	_, err = TaskOwnedBy(b, task.Tid)
+	assert.Equal(t, NotFoundErr, err)
+
+	query := TaskQuery{}
+	query.Aid = b.Aid
+	tasks, err := GetTasks(&query)
+	assert.Nil(t, err)
+	assert.Len(t, tasks, 0)
+
+	// 工作流的节点只能是同一个 app 的任务
+	w := Workflow{Name: "w", Expression: "0 0 * * *", Nodes: []string{task.Tid}, Aid: b.Aid}
+	assert.EqualError(t, PostWorkflow(&w), "工作流节点 "+task.Tid+" 不属于 app "+b.Aid)
+	w.Aid = a.Aid
+	assert.Nil(t, PostWorkflow(&w))
+	_, err = WorkflowOwnedBy(b, w.Wid)
+	assert.Equal(t, NotFoundErr, err)
+
+	// 只能使用任务所属 app 的密钥签名 root 不限制
+	signed := Task{Aid: a.Aid, Payload: map[string]interface{}{"aid": b.Aid}}
+	assert.True(t, errors.Is(a.AuthorizeSigner(signed), PermissionDeniedErr))
+	assert.Nil(t, RootApp.AuthorizeSigner(signed))
+	signed.Payload["aid"] = a.Aid
+	assert.Nil(t, a.AuthorizeSigner(signed))
+
+	// 任务不能属于已经删除的 app
+	assert.Nil(t, DeleteApp(ctx, b.Aid))
+	_, err = UpdateTask(task.Tid, map[string]interface{}{"aid": b.Aid})
+	assert.Equal(t, AppUnavailableErr, err)
+}
@@ -0,0 +1,413 @@
+package storage
+
+import (
+	"clock/v3/config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//ringReplicas 每个 worker 在哈希环上的虚拟节点数 让任务分布更均匀
+const ringReplicas = 128
+
+//Version 当前构建的版本 由 main 使用编译时注入的 gitHash 设置 注册 worker 的时候上报
+var Version = "dev"
+
+//hashRing 一致性哈希环 worker 加入或者离开的时候只有少部分任务需要重新分配
+type hashRing struct {
+	hashes []uint32
+	owners map[uint32]string
+}
+
+func newHashRing(members []string) *hashRing {
+	r := &hashRing{owners: make(map[uint32]string)}
+	for _, m := range members {
+		for i := 0; i < ringReplicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(m + "#" + strconv.Itoa(i)))
+			r.hashes = append(r.hashes, h)
+			r.owners[h] = m
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+//owner 顺时针找到第一个虚拟节点 环为空的时候返回空字符串
+func (r *hashRing) owner(key string) string {
+	return r.ownerIn(key, func(string) bool { return true })
+}
+
+//ownerIn 顺时针找到第一个 accept 的 worker 用于只在满足 selector 的 worker 中分配
+func (r *hashRing) ownerIn(key string, accept func(string) bool) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	for n := 0; n < len(r.hashes); n++ {
+		if owner := r.owners[r.hashes[(start+n)%len(r.hashes)]]; accept(owner) {
+			return owner
+		}
+	}
+	return ""
+}
+
+//ShardEnabled 是否按照一致性哈希将任务分配给 worker 没有配置 cluster.shard 的时候默认关闭 保持所有 worker 调度并抢锁
+func ShardEnabled() bool {
+	return config.Config.GetBool("cluster.shard")
+}
+
+//clusterHeartbeat worker 心跳间隔 默认 5s
+func clusterHeartbeat() time.Duration {
+	d := config.Config.GetDuration("cluster.heartbeat") * time.Second
+	if d <= 0 {
+		d = 5 * time.Second
+	}
+	return d
+}
+
+//clusterTTL 超过多久没有心跳视为下线 默认 15s 至少为两个心跳间隔
+func clusterTTL() time.Duration {
+	d := config.Config.GetDuration("cluster.ttl") * time.Second
+	if d < 2*clusterHeartbeat() {
+		d = 3 * clusterHeartbeat()
+	}
+	return d
+}
+
+//clusterRetain 下线的 worker 在 cache 中保留多久 默认 600s 期间会以 dead 状态展示
+func clusterRetain() time.Duration {
+	d := config.Config.GetDuration("cluster.retain") * time.Second
+	if d < clusterTTL() {
+		d = 600 * time.Second
+		if d < clusterTTL() {
+			d = clusterTTL()
+		}
+	}
+	return d
+}
+
+//workerKeyPrefix worker 在 cache 中注册的 key 前缀
+func workerKeyPrefix() string {
+	return fmt.Sprintf("%s:worker:", config.Config.GetString("lease.prefix"))
+}
+
+//ListWorkers 所有注册过并且还在保留期内的 worker 按照 id 排序 根据心跳计算状态
+func ListWorkers() ([]Worker, error) {
+	vals, err := RCache.GetPrefix(workerKeyPrefix())
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(-clusterTTL()).Unix()
+	workers := make([]Worker, 0, len(vals))
+	for key, val := range vals {
+		var w Worker
+		if err := json.Unmarshal(val, &w); err != nil {
+			log.Warnf("[cluster] invalid worker %s: %v", key, err)
+			continue
+		}
+		w.Status = WorkerAlive
+		if w.Heartbeat < deadline {
+			w.Status = WorkerDead
+		}
+		workers = append(workers, w)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].Id < workers[j].Id })
+	return workers, nil
+}
+
+//LiveWorkers 存活的 worker
+func LiveWorkers() ([]Worker, error) {
+	workers, err := ListWorkers()
+	if err != nil {
+		return nil, err
+	}
+	alive := make([]Worker, 0, len(workers))
+	for _, w := range workers {
+		if w.Status == WorkerAlive {
+			alive = append(alive, w)
+		}
+	}
+	return alive, nil
+}
+
+// 当前 worker 正在执行的任务 同一个任务可能被手动执行以及调度同时执行
+var running = struct {
+	sync.Mutex
+	tids map[string]int
+}{tids: make(map[string]int)}
+
+//markRunning 标记任务开始执行 返回执行结束时调用的函数
+func markRunning(tid string) func() {
+	running.Lock()
+	running.tids[tid]++
+	running.Unlock()
+	return func() {
+		running.Lock()
+		defer running.Unlock()
+		if running.tids[tid]--; running.tids[tid] <= 0 {
+			delete(running.tids, tid)
+		}
+	}
+}
+
+//runningTasks 正在执行的任务 tid 排序
+func runningTasks() []string {
+	running.Lock()
+	defer running.Unlock()
+	tids := make([]string, 0, len(running.tids))
+	for tid := range running.tids {
+		tids = append(tids, tid)
+	}
+	sort.Strings(tids)
+	return tids
+}
+
+//membership 当前 worker 的注册信息以及看到的所有存活 worker 开启分片的时候用于分配任务
+type membership struct {
+	mu      sync.RWMutex
+	self    Worker
+	members []string
+	workers []Worker // 存活的 worker 包括自己 用于根据标签选择
+	ring    *hashRing
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// 当前进程的 membership 调度器启动之后注册
+var workerMembership *membership
+
+func newMembership(id string) *membership {
+	hostname, _ := os.Hostname()
+	self := Worker{
+		Id:       id,
+		Hostname: hostname,
+		Version:  Version,
+		Labels:   config.Config.GetStringMapString("worker.labels"),
+		Capacity: config.Config.GetInt("worker.capacity"),
+		StartAt:  time.Now().Unix(),
+	}
+	return &membership{
+		self:    self,
+		workers: []Worker{self},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (m *membership) labels() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.self.Labels
+}
+
+//heartbeat 刷新自己在 cache 中的注册信息以及正在执行的任务
+func (m *membership) heartbeat() error {
+	m.mu.Lock()
+	m.self.Heartbeat = time.Now().Unix()
+	m.self.Labels = config.Config.GetStringMapString("worker.labels") // 配置文件修改之后生效
+	m.self.Running = runningTasks()
+	m.self.Queued = queuedTasks()
+	b, err := json.Marshal(m.self)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return RCache.Set(workerKeyPrefix()+m.self.Id, b, clusterRetain())
+}
+
+//refresh 发送心跳并重新获取存活的 worker 返回成员或者 worker 的 labels 是否发生变化
+func (m *membership) refresh() (bool, error) {
+	if err := m.heartbeat(); err != nil {
+		return false, err
+	}
+	workers, err := LiveWorkers()
+	if err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := []string{m.self.Id} // 自己一定存活
+	alive := []Worker{m.self}
+	for _, w := range workers {
+		if w.Id != m.self.Id {
+			members = append(members, w.Id)
+			alive = append(alive, w)
+		}
+	}
+	sort.Strings(members)
+
+	if m.ring != nil && equalStrings(m.members, members) {
+		// 成员没有变化 worker 的 labels 可能已经修改
+		if !equalWorkerLabels(m.workers, alive) {
+			log.Infof("[cluster] worker labels 变化")
+			m.workers = alive
+			return true, nil
+		}
+		return false, nil
+	}
+	log.Infof("[cluster] worker 成员变化 %v -> %v", m.members, members)
+	m.members = members
+	m.workers = alive
+	m.ring = newHashRing(members)
+	return true, nil
+}
+
+//owns 任务或者工作流是否由当前 worker 调度 只在满足 selector 的 worker 中选择
+//开启分片的时候在哈希环上选出一个 否则满足的 worker 都调度 通过分布式锁保证只执行一次
+func (m *membership) owns(id string, sel TaskSelector) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	accept := func(string) bool { return true }
+	if !sel.IsEmpty() {
+		candidates := sel.candidates(m.workers)
+		if !candidates[m.self.Id] {
+			return false
+		}
+		accept = func(w string) bool { return candidates[w] }
+	}
+	if m.ring == nil || !ShardEnabled() {
+		return true
+	}
+	return m.ring.ownerIn(id, accept) == m.self.Id
+}
+
+//run 定时心跳 成员变化之后重新分配任务
+func (m *membership) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(clusterHeartbeat())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			changed, err := m.refresh()
+			if err != nil {
+				log.Errorf("[cluster] heartbeat err: %v", err)
+				continue
+			}
+			if changed {
+				rebalance()
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+//leave 停止心跳并删除注册信息 其他 worker 在下一次心跳的时候接管任务
+func (m *membership) leave() {
+	close(m.stop)
+	<-m.done
+	if err := RCache.Del(workerKeyPrefix() + m.self.Id); err != nil {
+		log.Errorf("[cluster] remove worker %s err: %v", m.self.Id, err)
+	}
+}
+
+//ownedByMe 没有开启分片的时候所有满足 selector 的 worker 都调度 通过分布式锁保证只执行一次
+func ownedByMe(id string, sel TaskSelector) bool {
+	if workerMembership == nil {
+		return sel.matches(localLabels())
+	}
+	return workerMembership.owns(id, sel)
+}
+
+//rebalance 根据最新的成员重新分配任务 添加新分配给自己的 移除分配给其他 worker 的
+func rebalance() {
+	ctx := context.Background()
+	tasks, err := DB.Task().FindAll(ctx)
+	if err != nil {
+		log.Errorf("[cluster] get all tasks err: %v", err)
+		return
+	}
+	added, removed := 0, 0
+	for i := range tasks {
+		t := tasks[i]
+		if t.Disable {
+			continue
+		}
+		owned, scheduled := ownedByMe(t.Tid, t.Selector), cronScheduler.GetTaskEntryId(t.Tid) != 0
+		switch {
+		case owned && !scheduled:
+			if err := cronScheduler.AddTask(&t); err != nil {
+				log.Errorf("[cluster] 添加任务 %s 失败 %v", t.Tid, err)
+				continue
+			}
+			added++
+		case !owned && scheduled:
+			cronScheduler.RemoveTask(&t)
+			removed++
+		}
+	}
+
+	workflows, err := DB.Workflow().FindAll(ctx)
+	if err != nil {
+		log.Errorf("[cluster] get all workflows err: %v", err)
+		return
+	}
+	for i := range workflows {
+		w := workflows[i]
+		if w.Disable {
+			continue
+		}
+		owned, scheduled := ownedByMe(w.Wid, TaskSelector{}), cronScheduler.GetTaskEntryId(w.Wid) != 0
+		switch {
+		case owned && !scheduled:
+			if err := cronScheduler.AddWorkflow(&w); err != nil {
+				log.Errorf("[cluster] 添加工作流 %s 失败 %v", w.Wid, err)
+				continue
+			}
+			added++
+		case !owned && scheduled:
+			cronScheduler.RemoveTaskByTid(w.Wid)
+			removed++
+		}
+	}
+	log.Infof("[cluster] 重新分配完成 新增 %d 移除 %d", added, removed)
+}
+
+//equalWorkerLabels 相同 id 的 worker labels 是否都相同
+func equalWorkerLabels(a, b []Worker) bool {
+	labels := make(map[string]map[string]string, len(a))
+	for _, w := range a {
+		labels[w.Id] = w.Labels
+	}
+	for _, w := range b {
+		if !equalLabels(labels[w.Id], w.Labels) {
+			return false
+		}
+	}
+	return true
+}
+
+//equalLabels nil 和空的 map 视为相同
+func equalLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashRing(t *testing.T) {
+	three := newHashRing([]string{"a", "b", "c"})
+	two := newHashRing([]string{"a", "b"})
+
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("task-%d", i)
+		owner := three.owner(key)
+		counts[owner]++
+		// c 离开之后只有 c 的任务需要重新分配
+		if owner != "c" {
+			assert.Equal(t, owner, two.owner(key))
+		}
+	}
+	for _, m := range []string{"a", "b", "c"} {
+		assert.InDelta(t, 1000, counts[m], 400, m)
+	}
+	assert.Equal(t, "", newHashRing(nil).owner("x"))
+}
+
+func TestRebalance(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+	setTestConfig(t, "cluster.shard", true)
+
+	tasks := make([]Task, 0, 20)
+	for i := 0; i < 20; i++ {
+		tasks = append(tasks, newBashTask(t, "date", false))
+	}
+
+	assert.Nil(t, InitScheduler())
+	for _, task := range tasks {
+		assert.NotZero(t, cronScheduler.GetTaskEntryId(task.Tid))
+	}
+	workers, err := LiveWorkers()
+	assert.Nil(t, err)
+	assert.Len(t, workers, 1)
+	assert.Equal(t, WorkerId(), workers[0].Id)
+
+	// 新的 worker 加入之后只调度分配给自己的任务
+	other, _ := json.Marshal(Worker{Id: "other", Heartbeat: time.Now().Unix()})
+	assert.Nil(t, RCache.Set(workerKeyPrefix()+"other", other, time.Minute))
+	changed, err := workerMembership.refresh()
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	rebalance()
+	moved := 0
+	for _, task := range tasks {
+		if ownedByMe(task.Tid, task.Selector) {
+			assert.NotZero(t, cronScheduler.GetTaskEntryId(task.Tid))
+		} else {
+			assert.Zero(t, cronScheduler.GetTaskEntryId(task.Tid))
+			moved++
+		}
+	}
+	assert.NotZero(t, moved)
+
+	// worker 下线之后重新接管
+	assert.Nil(t, RCache.Del(workerKeyPrefix()+"other"))
+	changed, err = workerMembership.refresh()
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	rebalance()
+	for _, task := range tasks {
+		assert.NotZero(t, cronScheduler.GetTaskEntryId(task.Tid))
+	}
+
+	// 停止之后删除注册信息
+	StopScheduler()
+	workers, err = LiveWorkers()
+	assert.Nil(t, err)
+	assert.Len(t, workers, 0)
+}
+
+func TestListWorkers(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+	setTestConfig(t, "worker.labels", map[string]string{"zone": "sh"})
+	setTestConfig(t, "worker.capacity", 4)
+
+	assert.Nil(t, InitScheduler())
+	defer StopScheduler()
+
+	done := markRunning("t1")
+	_, err := workerMembership.refresh()
+	assert.Nil(t, err)
+
+	// 超过 cluster.ttl 没有心跳的 worker 为 dead
+	dead, _ := json.Marshal(Worker{Id: "dead", Heartbeat: time.Now().Add(-time.Hour).Unix()})
+	assert.Nil(t, RCache.Set(workerKeyPrefix()+"dead", dead, time.Minute))
+
+	workers, err := ListWorkers()
+	assert.Nil(t, err)
+	assert.Len(t, workers, 2)
+	byId := make(map[string]Worker)
+	for _, w := range workers {
+		byId[w.Id] = w
+	}
+	assert.Equal(t, WorkerDead, byId["dead"].Status)
+	self := byId[WorkerId()]
+	assert.Equal(t, WorkerAlive, self.Status)
+	assert.Equal(t, []string{"t1"}, self.Running)
+	assert.Equal(t, "sh", self.Labels["zone"])
+	assert.Equal(t, 4, self.Capacity)
+
+	alive, err := LiveWorkers()
+	assert.Nil(t, err)
+	assert.Len(t, alive, 1)
+
+	done()
+	_, err = workerMembership.refresh()
+	assert.Nil(t, err)
+	workers, _ = LiveWorkers()
+	assert.Len(t, workers[0].Running, 0)
+}
+
+func TestTaskSelector(t *testing.T) {
+	workers := []Worker{
+		{Id: "a", Labels: map[string]string{"zone": "sh"}},
+		{Id: "b", Labels: map[string]string{"zone": "sh", "gpu": "true"}},
+		{Id: "c", Labels: map[string]string{"zone": "bj", "gpu": "true"}},
+	}
+	sel := TaskSelector{Required: map[string]string{"zone": "sh"}}
+	assert.Equal(t, map[string]bool{"a": true, "b": true}, sel.candidates(workers))
+	sel.Preferred = map[string]string{"gpu": "true"}
+	assert.Equal(t, map[string]bool{"b": true}, sel.candidates(workers))
+	// 没有匹配 preferred 的时候不限制
+	sel.Preferred = map[string]string{"disk": "ssd"}
+	assert.Equal(t, map[string]bool{"a": true, "b": true}, sel.candidates(workers))
+
+	// 哈希环上只在满足的 worker 中选择
+	ring := newHashRing([]string{"a", "b", "c"})
+	for i := 0; i < 100; i++ {
+		owner := ring.ownerIn(fmt.Sprintf("task-%d", i), func(w string) bool { return w != "c" })
+		assert.NotEqual(t, "c", owner)
+	}
+}
+
+func TestSelectorPlacement(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+	setTestConfig(t, "worker.labels", map[string]string{"zone": "sh"})
+
+	// 没有存活的 worker 满足 required
+	task := Task{Name: "s", Expression: "0 0 * * *", Type: BashTask,
+		Payload:  map[string]interface{}{"command": "date"},
+		Selector: TaskSelector{Required: map[string]string{"zone": "sh"}}}
+	assert.NotNil(t, PostTask(&task))
+
+	assert.Nil(t, InitScheduler())
+	defer StopScheduler()
+	assert.Nil(t, PostTask(&task))
+	got, err := GetTask(task.Tid)
+	assert.Nil(t, err)
+	assert.Equal(t, "sh", got.Selector.Required["zone"])
+	assert.True(t, ownedByMe(task.Tid, task.Selector))
+
+	other := Task{Name: "o", Expression: "0 0 * * *", Type: BashTask,
+		Payload:  map[string]interface{}{"command": "date"},
+		Selector: TaskSelector{Required: map[string]string{"zone": "bj"}}}
+	assert.EqualError(t, PostTask(&other), "no live worker matches selector map[zone:bj]")
+
+	// 其他 worker 满足的任务当前 worker 不调度也不执行
+	bj, _ := json.Marshal(Worker{Id: "bj", Labels: map[string]string{"zone": "bj"}, Heartbeat: time.Now().Unix()})
+	assert.Nil(t, RCache.Set(workerKeyPrefix()+"bj", bj, time.Minute))
+	_, err = workerMembership.refresh()
+	assert.Nil(t, err)
+	assert.Nil(t, PostTask(&other))
+	assert.False(t, ownedByMe(other.Tid, other.Selector))
+	assert.Zero(t, cronScheduler.GetTaskEntryId(other.Tid))
+	assert.Equal(t, SelectorMismatchErr, RunTask(other.Tid))
+
+	// 运行中的 worker 修改 labels 之后重新分配
+	bj, _ = json.Marshal(Worker{Id: "bj", Labels: map[string]string{"zone": "sh"}, Heartbeat: time.Now().Unix()})
+	assert.Nil(t, RCache.Set(workerKeyPrefix()+"bj", bj, time.Minute))
+	changed, err := workerMembership.refresh()
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	changed, err = workerMembership.refresh()
+	assert.Nil(t, err)
+	assert.False(t, changed)
+
+	// 没有满足的 worker 的时候 selector 没有变化也可以修改以及禁用任务
+	assert.Nil(t, RCache.Del(workerKeyPrefix()+"bj"))
+	_, err = UpdateTask(other.Tid, map[string]interface{}{"disable": true})
+	assert.Nil(t, err)
+	_, err = UpdateTask(other.Tid, map[string]interface{}{"selector": map[string]interface{}{
+		"required": map[string]interface{}{"zone": "gz"}}})
+	assert.EqualError(t, err, "no live worker matches selector map[zone:gz]")
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"clock/v3/config"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//replacePoll 并发策略为 replace 时等待正在运行的实例退出的检查间隔
+const replacePoll = 500 * time.Millisecond
+
+//catchUpPoll 补跑的时候等待任务锁的检查间隔
+const catchUpPoll = 200 * time.Millisecond
+
+//validate allow 需要指定 max
+func (p ConcurrencyPolicy) validate() error {
+	switch p.Policy {
+	case "", ConcurrencyForbid, ConcurrencyReplace:
+		return nil
+	case ConcurrencyAllow:
+		if p.Max < 1 {
+			return errors.New("concurrency.max must be at least 1 when policy is allow")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported concurrency policy %s, must be forbid, allow or replace", p.Policy)
+	}
+}
+
+//cancelChannel 取消正在运行的实例的频道
+func cancelChannel(tid string) string {
+	return fmt.Sprintf("%s:cancel:%s", config.Config.GetString("pubsub.channel"), tid)
+}
+
+//replaceWait 等待被取消的实例退出并释放锁的时间 bash 进程组需要 kill_grace 才会被强制结束
+func replaceWait() time.Duration {
+	return (config.Config.GetDuration("bash.kill_grace") + 5) * time.Second
+}
+
+//acquireRun 根据任务的并发策略获取分布式锁 返回释放锁的函数以及被取消时关闭的通道
+//fireAt 为调度器触发的时间 同一次触发只有一个 worker 可以执行 手动执行为零值
+//补跑(catchUp)的时候先等待任务锁再占用触发时间 占用之后一定会执行 不会因为上一次补跑还没有结束而丢失
+func acquireRun(t Task, fireAt time.Time, catchUp bool) (func(), <-chan struct{}, error) {
+	if !fireAt.IsZero() && !catchUp {
+		if err := claimFire(t, fireAt); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var jobDone chan int
+	var err error
+	switch {
+	case catchUp:
+		jobDone, err = waitLock(t)
+	case t.Concurrency.Policy == ConcurrencyAllow:
+		jobDone, err = RCache.TryLockN(t, t.Concurrency.Max)
+	case t.Concurrency.Policy == ConcurrencyReplace:
+		jobDone, err = replaceRunning(t)
+	default:
+		jobDone, err = RCache.TryLock(t)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !fireAt.IsZero() && catchUp {
+		if err = claimFire(t, fireAt); err != nil {
+			jobDone <- 1
+			return nil, nil, err
+		}
+	}
+
+	release := func() {
+		jobDone <- 1 // 完成 job
+	}
+	if t.Concurrency.Policy != ConcurrencyReplace {
+		return release, nil, nil
+	}
+
+	// 订阅取消消息 之后的实例会取消当前实例
+	msg, unsubscribe, err := RCache.SubscribeTo(cancelChannel(t.Tid))
+	if err != nil {
+		log.Errorf("[concurrency] subscribe cancel of task %s err: %v", t.Tid, err)
+		return release, nil, nil
+	}
+	cancel := make(chan struct{})
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-msg:
+			log.Infof("[concurrency] task %s 被新的实例取消", t.Tid)
+			close(cancel)
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+		unsubscribe()
+		release()
+	}, cancel, nil
+}
+
+//claimFire 占用一次调度触发 已经被其他 worker 占用返回 FireTakenErr
+func claimFire(t Task, fireAt time.Time) error {
+	key := fmt.Sprintf("%s:%s:fire:%d", config.Config.GetString("lease.prefix"), t.Tid, fireAt.Unix())
+	ok, err := RCache.SetNX(key, config.Config.GetDuration("lease.expire")*time.Second)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return FireTakenErr
+	}
+	return nil
+}
+
+//waitLock 补跑的时候一直等待到获取任务锁 不取消正在运行的实例
+func waitLock(t Task) (chan int, error) {
+	for {
+		var jobDone chan int
+		var err error
+		if t.Concurrency.Policy == ConcurrencyAllow {
+			jobDone, err = RCache.TryLockN(t, t.Concurrency.Max)
+		} else {
+			jobDone, err = RCache.TryLock(t)
+		}
+		if err != WaitForNextScheduleErr {
+			return jobDone, err
+		}
+		time.Sleep(catchUpPoll)
+	}
+}
+
+//replaceRunning 抢锁失败的时候通知正在运行的实例取消 并等待其释放锁
+func replaceRunning(t Task) (chan int, error) {
+	jobDone, err := RCache.TryLock(t)
+	if err != WaitForNextScheduleErr {
+		return jobDone, err
+	}
+	if err = RCache.PublishTo(cancelChannel(t.Tid), []byte(t.Tid)); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(replaceWait())
+	for time.Now().Before(deadline) {
+		time.Sleep(replacePoll)
+		jobDone, err = RCache.TryLock(t)
+		if err != WaitForNextScheduleErr {
+			return jobDone, err
+		}
+	}
+	return nil, WaitForNextScheduleErr
+}
+
+// 当前 worker 最多同时执行 worker.capacity 个任务 超过之后排队
+var slots = struct {
+	sync.Mutex
+	ch      chan struct{}
+	waiting int
+}{}
+
+//acquireSlot 获取 worker 的执行名额 capacity 为 0 不限制 排队的任务超过 worker.queue 返回 WorkerBusyErr
+func acquireSlot() (func(), error) {
+	capacity := config.Config.GetInt("worker.capacity")
+	if capacity <= 0 {
+		return func() {}, nil
+	}
+	slots.Lock()
+	if slots.ch == nil || cap(slots.ch) != capacity {
+		slots.ch = make(chan struct{}, capacity)
+	}
+	ch := slots.ch
+	select {
+	case ch <- struct{}{}:
+		slots.Unlock()
+		return func() { <-ch }, nil
+	default:
+	}
+	if queue := config.Config.GetInt("worker.queue"); queue > 0 && slots.waiting >= queue {
+		slots.Unlock()
+		return nil, WorkerBusyErr
+	}
+	slots.waiting++
+	slots.Unlock()
+
+	ch <- struct{}{}
+	slots.Lock()
+	slots.waiting--
+	slots.Unlock()
+	return func() { <-ch }, nil
+}
+
+//queuedTasks 正在等待执行名额的任务数
+func queuedTasks() int {
+	slots.Lock()
+	defer slots.Unlock()
+	return slots.waiting
+}
+
+//isCancelled 运行是否被新的实例取消
+func (l *TaskLog) isCancelled() bool {
+	if l.cancel == nil {
+		return false
+	}
+	select {
+	case <-l.cancel:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyPolicy(t *testing.T) {
+	setMemoryStorage(t)
+
+	cases := []struct {
+		policy ConcurrencyPolicy
+		valid  bool
+	}{
+		{ConcurrencyPolicy{}, true},
+		{ConcurrencyPolicy{Policy: ConcurrencyForbid}, true},
+		{ConcurrencyPolicy{Policy: ConcurrencyReplace}, true},
+		{ConcurrencyPolicy{Policy: ConcurrencyAllow, Max: 2}, true},
+		{ConcurrencyPolicy{Policy: ConcurrencyAllow}, false},
+		{ConcurrencyPolicy{Policy: "queue"}, false},
+	}
+	for _, c := range cases {
+		task := Task{Name: "c", Expression: "0 0 * * *", Type: BashTask,
+			Payload: map[string]interface{}{"command": "date"}, Concurrency: c.policy}
+		err := PostTask(&task)
+		if c.valid {
+			assert.Nil(t, err, c.policy.Policy)
+		} else {
+			assert.NotNil(t, err, c.policy.Policy)
+		}
+	}
+}
+
+func TestAcquireRun(t *testing.T) {
+	setMemoryStorage(t)
+
+	// allow 最多 max 个实例同时执行
+	task := Task{Tid: "allow", Concurrency: ConcurrencyPolicy{Policy: ConcurrencyAllow, Max: 2}}
+	r1, _, err := acquireRun(task, time.Time{}, false)
+	assert.Nil(t, err)
+	r2, _, err := acquireRun(task, time.Time{}, false)
+	assert.Nil(t, err)
+	_, _, err = acquireRun(task, time.Time{}, false)
+	assert.Equal(t, WaitForNextScheduleErr, err)
+	r1()
+	r2()
+
+	// 同一次触发只有一个 worker 执行
+	fireAt := time.Now().Truncate(time.Second)
+	other := Task{Tid: "fire", Concurrency: ConcurrencyPolicy{Policy: ConcurrencyAllow, Max: 2}}
+	release, _, err := acquireRun(other, fireAt, false)
+	assert.Nil(t, err)
+	_, _, err = acquireRun(other, fireAt, false)
+	assert.Equal(t, FireTakenErr, err)
+	release()
+	release, _, err = acquireRun(other, fireAt.Add(time.Second), false)
+	assert.Nil(t, err)
+	release()
+}
+
+func TestAcquireSlot(t *testing.T) {
+	setMemoryStorage(t)
+	setTestConfig(t, "worker.capacity", 1)
+	setTestConfig(t, "worker.queue", 1)
+
+	done, err := acquireSlot()
+	assert.Nil(t, err)
+
+	acquired := make(chan func())
+	go func() {
+		d, e := acquireSlot()
+		assert.Nil(t, e)
+		acquired <- d
+	}()
+	assert.Eventually(t, func() bool { return queuedTasks() == 1 }, time.Second, 10*time.Millisecond)
+
+	// 队列满了之后直接跳过
+	_, err = acquireSlot()
+	assert.Equal(t, WorkerBusyErr, err)
+
+	done()
+	select {
+	case d := <-acquired:
+		assert.Equal(t, 0, queuedTasks())
+		d()
+	case <-time.After(time.Second):
+		t.Fatal("queued run did not acquire the slot")
+	}
+}
+
+func TestReplaceRunning(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+	setTestConfig(t, "bash.kill_grace", 1)
+
+	task := newBashTask(t, "sleep 30", true)
+	assert.Nil(t, DB.Task().Update(context.Background(), task.Tid, map[string]interface{}{
+		"concurrency": ConcurrencyPolicy{Policy: ConcurrencyReplace},
+	}))
+
+	first := make(chan error, 1)
+	go func() {
+		first <- RunTask(task.Tid)
+	}()
+	assert.Eventually(t, func() bool { return len(runningTasks()) == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	// 新的实例取消正在运行的实例之后执行
+	assert.Nil(t, DB.Task().Update(context.Background(), task.Tid, map[string]interface{}{
+		"payload": map[string]interface{}{"command": "echo replaced"},
+	}))
+	start := time.Now()
+	assert.Nil(t, RunTask(task.Tid))
+	assert.Less(t, int64(time.Since(start)), int64(10*time.Second))
+	assert.NotNil(t, <-first)
+
+	query := LogQuery{}
+	query.Tid = task.Tid
+	logs := waitLogs(t, query, 2)
+	statuses := make(map[string]int)
+	for _, l := range logs {
+		statuses[l.Status]++
+	}
+	assert.Equal(t, map[string]int{RunCancelled: 1, RunSuccess: 1}, statuses)
+}
+
+func TestFireTakenWithoutSkippedLog(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+	task := newBashTask(t, "date", true)
+	fireAt := time.Now().Truncate(time.Second)
+
+	// 同一次触发已经被其他 worker 执行 不记录 skipped
+	assert.Nil(t, runTask(task.Tid, fireAt, false))
+	assert.Equal(t, FireTakenErr, runTask(task.Tid, fireAt, false))
+	// 上一次运行还持有锁的时候才是跳过
+	assert.Equal(t, WaitForNextScheduleErr, runTask(task.Tid, fireAt.Add(time.Second), false))
+	pendingLogs.Wait()
+
+	query := LogQuery{}
+	query.Tid = task.Tid
+	logs, err := GetLogs(&query)
+	assert.Nil(t, err)
+	statuses := make(map[string]int)
+	for _, l := range logs {
+		statuses[l.Status]++
+	}
+	assert.Equal(t, map[string]int{RunSuccess: 1, RunSkipped: 1}, statuses)
+
+	w := Workflow{Name: "w", Expression: "0 0 * * *", Nodes: []string{task.Tid}}
+	assert.Nil(t, PostWorkflow(&w))
+	assert.Nil(t, runWorkflow(w.Wid, fireAt))
+	assert.Equal(t, FireTakenErr, runWorkflow(w.Wid, fireAt))
+	runQuery := WorkflowRunQuery{}
+	runQuery.Wid = w.Wid
+	runs, err := GetWorkflowRuns(&runQuery)
+	assert.Nil(t, err)
+	assert.Len(t, runs, 1)
+}
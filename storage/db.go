@@ -3,79 +3,68 @@ package storage
 import (
 	"clock/v3/config"
 	"context"
-	"time"
+	"fmt"
 
 	"github.com/go-redis/redis/v8"
 	log "github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
-	Mdb    *mdb
+	DB     Database
 	RCache Cache
 )
 
-// mongo 对应集合
-var (
-	TaskCol     *mongo.Collection // 任务集合
-	TaskLogCol  *mongo.Collection // 任务日志集合
-	TimezoneCol *mongo.Collection // 支持时区集合
-	AppCol      *mongo.Collection // 应用集合
-)
-
-type mdb struct {
-	Client *mongo.Client
-	Cron   *mongo.Database
-}
-
-// initMongo
-func initMongo() error {
-	// 连接 mongo db
-	opts := options.Client().ApplyURI(config.Config.GetString("database.mongo.conn"))
-	client, err := mongo.Connect(context.Background(), opts)
-	if err != nil {
-		log.Errorf("[db] mongo connect err: %v", err)
-		return err
+// initDatabase 根据 database.driver 选择 mongo(默认)、sqlite3、postgres 或者 memory
+func initDatabase() error {
+	switch driver := config.Config.GetString("database.driver"); driver {
+	case "", "mongo":
+		mdb, err := NewMongoDatabase(
+			config.Config.GetString("database.mongo.conn"),
+			config.Config.GetString("database.mongo.db"),
+		)
+		if err != nil {
+			return err
+		}
+		DB = mdb
+	case SQLite, Postgres:
+		sdb, err := NewSQLDatabase(driver, config.Config.GetString("database.sql.dsn"))
+		if err != nil {
+			return err
+		}
+		DB = sdb
+	case "memory":
+		// 进程内存储 只适用于单机模式以及测试
+		DB = NewMemoryDatabase()
+	default:
+		return fmt.Errorf("不支持的 database driver: %s", driver)
 	}
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-	if err := client.Ping(ctx, nil); err != nil {
-		log.Errorf("[db] mongo ping err: %v", err)
-		return err
-	}
-	log.Debugf("[db] mongo ping success")
-	// insert for ping
-	cron := client.Database(config.Config.GetString("database.mongo.db"))
-
-	Mdb = &mdb{
-		Client: client,
-		Cron:   cron,
-	}
-
-	// init col
-	TaskCol = Mdb.Cron.Collection("task")
-	TaskLogCol = Mdb.Cron.Collection("task_log")
-	TimezoneCol = Mdb.Cron.Collection("timezone")
-	AppCol = Mdb.Cron.Collection("app")
 
 	return nil
 }
 
-// initRedis
-func initRedis() error {
-	// 连接 redis
-	RCache = NewRedisCache(
-		redis.NewClient(&redis.Options{
-			Addr:     config.Config.GetString("cache.redis.addr"),
-			Password: config.Config.GetString("cache.redis.auth"),
-			DB:       config.Config.GetInt("cache.redis.db"),
-		}))
+// initCache 根据 cache.driver 选择 redis(默认) 或者 memory
+func initCache() error {
+	switch driver := config.Config.GetString("cache.driver"); driver {
+	case "", "redis":
+		// 连接 redis
+		RCache = NewRedisCache(
+			redis.NewClient(&redis.Options{
+				Addr:     config.Config.GetString("cache.redis.addr"),
+				Password: config.Config.GetString("cache.redis.auth"),
+				DB:       config.Config.GetInt("cache.redis.db"),
+			}))
+	case "memory":
+		// 进程内 cache 只适用于 master 和 worker 运行在同一个进程
+		RCache = NewMemoryCache()
+	default:
+		return fmt.Errorf("不支持的 cache driver: %s", driver)
+	}
 	pong, err := RCache.Ping()
 	if err != nil {
-		log.Errorf("[db] redis ping err: %v", err)
+		log.Errorf("[db] cache ping err: %v", err)
 		return err
 	}
-	log.Debugf("[db] rdb ping res is %s", pong)
+	log.Debugf("[db] cache ping res is %s", pong)
 
 	return nil
 }
@@ -83,13 +72,13 @@ func initRedis() error {
 // SetDb 初始化
 func SetDb() error {
 
-	if err := initMongo(); err != nil {
-		log.Errorf("[db] init mongo err: %v", err)
+	if err := initDatabase(); err != nil {
+		log.Errorf("[db] init database err: %v", err)
 		return err
 	}
 
-	if err := initRedis(); err != nil {
-		log.Errorf("[db] init redis err: %v", err)
+	if err := initCache(); err != nil {
+		log.Errorf("[db] init cache err: %v", err)
 		return err
 	}
 
@@ -99,11 +88,15 @@ func SetDb() error {
 //RevokeDb 释放连接
 func RevokeDb() {
 	var err error
-	if err = Mdb.Client.Disconnect(context.Background()); err != nil {
-		log.Errorf("[db] 释放连接 Mongo db 错误, %v", err)
+	if DB != nil {
+		if err = DB.Close(context.Background()); err != nil {
+			log.Errorf("[db] 释放连接 database 错误, %v", err)
+		}
 	}
-	if err = RCache.Close(); err != nil {
-		log.Errorf("[db] 释放连接 Redis db 错误, %v", err)
+	if RCache != nil {
+		if err = RCache.Close(); err != nil {
+			log.Errorf("[db] 释放连接 cache 错误, %v", err)
+		}
 	}
 
 }
@@ -1,152 +1,201 @@
 package storage
 
 import (
-	"bytes"
+	"clock/v3/config"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os/exec"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-
-	"go.mongodb.org/mongo-driver/bson"
 )
 
-func RunBashTask(t Task) error {
-	log.Debugf("[ostool] execute job name: %s, tid: %s", t.Name, t.Tid)
-	// DONE: 分布式锁
-	jobDone, err := RCache.TryLock(t)
-	if err != nil {
-		log.Errorf("[ostool] 加锁失败: %v", err)
-		return err // 在 defer 之前 return，并不会执行 defer 的内容
-	}
-
-	var stdOutBuf bytes.Buffer
-	var stdErrBuf bytes.Buffer
-	start := time.Now().Unix()
-
+//RunBashTask 执行 bash 任务 运行结果记录到 l 中
+func RunBashTask(t Task, l *TaskLog) error {
+	stdOutBuf := newOutputCapture(outputLimit(t))
+	stdErrBuf := newOutputCapture(outputLimit(t))
 	defer func() {
-		jobDone <- 1 // 完成 job
-		end := time.Now().Unix()
-		t.UpdateAt = time.Now().Unix()
-		log.Debugf("[%v] - now finish task [%s]", t.Tid, t.Name)
-		res, err := TaskCol.UpdateOne(context.Background(), bson.D{{"_id", t.Id}}, bson.D{
-			{
-				"$set",
-				&t,
-			},
-		})
-		if err != nil {
-			log.Errorf("[ostool] update task %s err: %v", t.Tid, err)
-			return
-		}
-		log.Debugf("[ostool] update task %s, match: %v, modify: %v",
-			t.Tid, res.MatchedCount, res.ModifiedCount)
-		go saveLog(t, &stdOutBuf, &stdErrBuf, start, end)
+		l.StdOut = stdOutBuf.String()
+		l.StdErr = stdErrBuf.String()
 	}()
 
-	command := t.Payload["command"].(string)
-
-	if command == "" {
-		return errors.New("please do not input the empty command")
+	p, err := t.BashPayload()
+	if err != nil {
+		l.finish(RunFailed, -1)
+		stdErrBuf.WriteString(err.Error())
+		return err
 	}
+	command := p.Command
 
 	log.Debugf("[%v] - now will run the task [%s]", t.Tid, t.Name)
 
 	c := exec.Command("/bin/bash", "-c", command)
-	c.Stdout = &stdOutBuf
-	c.Stderr = &stdErrBuf
+	c.Stdout = stdOutBuf
+	c.Stderr = stdErrBuf
+	if liveOut, liveErr := newLiveWriters(t, l); liveOut != nil {
+		c.Stdout = io.MultiWriter(stdOutBuf, liveOut)
+		c.Stderr = io.MultiWriter(stdErrBuf, liveErr)
+		defer liveOut.flush()
+		defer liveErr.flush()
+	}
+	// 新建进程组 超时的时候 kill 整个进程组 防止子进程变成孤儿进程
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := c.Start(); err != nil {
+		return finishBashLog(l, stdErrBuf, err)
+	}
+	if l.streamed {
+		stop := streamOutput(l.Lid, stdOutBuf, stdErrBuf)
+		defer stop()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Wait()
+	}()
 
+	// 没有设置超时的时候 timeout 为 nil 永远不会触发
+	var timeout <-chan time.Time
 	if t.TimeOut > 0 {
-		timeout := time.After(time.Duration(t.TimeOut) * time.Second)
-		done := make(chan error, 1)
+		timeout = time.After(time.Duration(t.TimeOut) * time.Second)
+	}
 
-		go func() {
-			done <- c.Run()
-		}()
+	select {
+	case e := <-done:
+		return finishBashLog(l, stdErrBuf, e)
+	case <-l.cancel:
+		// 并发策略为 replace 的任务被新的实例取消
+		killProcessGroup(c.Process.Pid, done)
+		err := fmt.Errorf("cmd %s cancelled by a newer run", command)
+		log.Warn(err.Error())
+		stdErrBuf.WriteString(err.Error())
+		l.finish(RunCancelled, -1)
+		return err
+	case <-timeout:
+		killProcessGroup(c.Process.Pid, done)
+		err := errors.New(fmt.Sprintf("cmd %s reach to timeout limit", command))
+		log.Error(err.Error())
+		stdErrBuf.WriteString(err.Error())
+		l.finish(RunTimeout, -1)
+		return err
+	}
+}
 
-		select {
-		case <-timeout:
-			// TODO: 新建进程组 kill
-			_ = c.Process.Kill()
-			err := errors.New(fmt.Sprintf("cmd %s reach to timeout limit", command))
-			log.Error(err.Error())
-			stdErrBuf.WriteString(err.Error())
-			return err
-		case <-done:
-			return nil
-		}
+//killProcessGroup 先发送 SIGTERM 给整个进程组 等待 bash.kill_grace 秒之后再发送 SIGKILL
+//bash 提前退出也会发送 SIGKILL 保证进程组中的子进程都被清理
+func killProcessGroup(pgid int, done <-chan error) {
+	grace := config.Config.GetDuration("bash.kill_grace") * time.Second
+	if grace <= 0 {
+		grace = 5 * time.Second
 	}
 
-	e := c.Run()
-	if e != nil {
-		log.Errorf("[ostool] run task err: %v", e)
-		// 写入错误信息
-		stdErrBuf.WriteString(e.Error())
-		return e
+	exited := false
+	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+		log.Errorf("[executor] SIGTERM process group %d err: %v", pgid, err)
+	}
+	select {
+	case <-done:
+		exited = true
+	case <-time.After(grace):
 	}
 
-	return nil
+	if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		log.Errorf("[executor] SIGKILL process group %d err: %v", pgid, err)
+	}
+	if exited {
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(grace):
+		// 脱离进程组的子进程仍然持有输出管道
+		log.Warnf("[executor] process group %d 被 kill 之后仍然没有退出", pgid)
+	}
+}
+
+//finishBashLog 根据 bash 执行结果记录状态以及退出码
+func finishBashLog(l *TaskLog, stdErr *outputCapture, e error) error {
+	if e == nil {
+		l.finish(RunSuccess, 0)
+		return nil
+	}
 
+	log.Errorf("[ostool] run task err: %v", e)
+	// 写入错误信息
+	stdErr.WriteString(e.Error())
+	code := -1 // 没有成功启动
+	if exitErr, ok := e.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	}
+	l.finish(RunFailed, code)
+	return e
 }
 
-//RunHTTPTask 请求 http 执行任务
-func RunHTTPTask(t Task, now string) error {
-	log.Debugf("[ostool] execute job name: %s, tid: %s", t.Name, t.Tid)
-	jobDone, err := RCache.TryLock(t)
+//RunHTTPTask 请求 http 执行任务 运行结果记录到 l 中 不满足成功条件记录为失败
+//设置了 aid 的任务使用对应 app 的密钥对请求签名
+func RunHTTPTask(t Task, now string, l *TaskLog) error {
+	p, err := t.HTTPPayload()
 	if err != nil {
+		l.StdErr = err.Error()
+		l.finish(RunFailed, 0)
 		return err
 	}
-	defer func() {
-		jobDone <- 1
-	}()
-
-	// 拼接 url
-	endpoint := t.Payload["endpoint"].(string)
-	prefix := t.Payload["prefix"].(string)
-	url := endpoint + prefix
-	log.Debugf("[executor] url is %s", url)
-	data, ok := t.Payload["data"].(map[string]interface{})
-	if !ok {
-		data = make(map[string]interface{})
-		//return errors.New("[executor] data 不是 map[string]interface{} 类型")
-	}
-	log.Debugf("[executor] data is %v", data)
-	// 加入 delay 时间到 data 中
-	data["delay"] = now
-	b, err := json.Marshal(data)
+	req, body, err := p.newRequest(httpBodyContext{Tid: t.Tid, Name: t.Name, Delay: now, Attempt: l.Attempt})
 	if err != nil {
-		log.Errorf("[executor] data 序列化失败 %v", err)
+		log.Errorf("[executor] build request err: %v", err)
+		l.StdErr = err.Error()
+		l.finish(RunFailed, 0)
 		return err
 	}
-	method := t.Payload["method"].(string)
-	start := time.Now().Unix()
-	var resp ResponseWrapper
-	switch method {
-	case "GET":
-		resp = Get(url, t.TimeOut)
-	case "POST":
-		resp = PostJson(url, string(b), t.TimeOut)
-	}
-
-	end := time.Now().Unix()
-	t.UpdateAt = time.Now().Unix()
-	log.Debugf("[%v] - now finish task [%s]", t.Tid, t.Name)
-	res, err := TaskCol.UpdateOne(context.Background(), bson.D{{"_id", t.Id}}, bson.D{
-		{
-			"$set",
-			&t,
-		},
-	})
+	if p.Aid != "" {
+		if err := signHTTPRequest(req, p.Aid, body); err != nil {
+			log.Errorf("[executor] sign request with app %s err: %v", p.Aid, err)
+			l.StdErr = err.Error()
+			l.finish(RunFailed, 0)
+			return err
+		}
+	}
+	log.Debugf("[executor] %s %s", req.Method, req.URL)
+	transport, err := p.TLS.transport()
 	if err != nil {
-		log.Errorf("[ostool] update task %s err: %v", t.Tid, err)
+		l.StdErr = err.Error()
+		l.finish(RunFailed, 0)
 		return err
 	}
-	log.Debugf("[ostool] update task %s, match: %v, modify: %v",
-		t.Tid, res.MatchedCount, res.ModifiedCount)
-	go saveLog(t, bytes.NewBuffer([]byte(resp.Body)), bytes.NewBuffer([]byte("")), start, end)
+	if l.cancel != nil {
+		// 并发策略为 replace 的任务被新的实例取消的时候中断请求
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-l.cancel:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		req = req.WithContext(ctx)
+	}
+	resp := requestWithTransport(req, t.TimeOut, transport, outputLimit(t))
+	if l.isCancelled() {
+		l.StdErr = fmt.Sprintf("http task %s cancelled by a newer run", t.Tid)
+		l.finish(RunCancelled, 0)
+		return errors.New(l.StdErr)
+	}
 
+	// 状态码为 0 说明请求没有发送成功
+	if resp.StatusCode == 0 {
+		l.StdErr = capOutput(resp.Body, outputLimit(t))
+		l.finish(RunFailed, 0)
+		return fmt.Errorf("http task %s failed: %s", t.Tid, resp.Body)
+	}
+	if err := p.Success.check(resp); err != nil {
+		l.StdErr = capOutput(err.Error()+"\n"+resp.Body, outputLimit(t))
+		l.finish(RunFailed, resp.StatusCode)
+		return fmt.Errorf("http task %s failed: %v", t.Tid, err)
+	}
+	l.StdOut = resp.Body // 读取的时候已经截断
+	l.finish(RunSuccess, resp.StatusCode)
 	return nil
 }
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"clock/v3/config"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// processAlive 进程不存在或者已经是僵尸进程都视为退出
+func processAlive(pid int) bool {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(b[strings.LastIndex(string(b), ")")+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func tempPidFile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "clock")
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return filepath.Join(dir, "pids")
+}
+
+func readPids(t *testing.T, file string) []int {
+	b, err := ioutil.ReadFile(file)
+	assert.Nil(t, err)
+	pids := make([]int, 0)
+	for _, s := range strings.Fields(string(b)) {
+		pid, err := strconv.Atoi(s)
+		assert.Nil(t, err)
+		pids = append(pids, pid)
+	}
+	return pids
+}
+
+// 修改当前的配置 不替换全局的 config.Config 测试结束之后恢复
+func setKillGrace(t *testing.T, grace int) {
+	if config.Config == nil {
+		config.Config = viper.New()
+	}
+	setTestConfig(t, "bash.kill_grace", grace)
+}
+
+func runBash(command string, timeout int) (TaskLog, time.Duration, error) {
+	task := Task{Tid: "bash", TimeOut: timeout, Payload: map[string]interface{}{"command": command}}
+	l := newTaskLog(task, time.Now())
+	start := time.Now()
+	err := RunBashTask(task, &l)
+	return l, time.Since(start), err
+}
+
+func TestBashTimeoutKillProcessGroup(t *testing.T) {
+	setKillGrace(t, 1)
+	pidFile := tempPidFile(t)
+
+	// 子进程以及孙子进程都在同一个进程组中 并且继承了输出管道
+	command := fmt.Sprintf(`sleep 60 & echo $! >> %[1]s
+bash -c 'sleep 60 & echo $! >> %[1]s; sleep 60' &
+echo $! >> %[1]s
+sleep 60`, pidFile)
+	l, cost, err := runBash(command, 1)
+	assert.NotNil(t, err)
+	assert.Equal(t, RunTimeout, l.Status)
+	assert.Less(t, int64(cost), int64(4*time.Second))
+
+	pids := readPids(t, pidFile)
+	assert.Len(t, pids, 3)
+	time.Sleep(100 * time.Millisecond)
+	for _, pid := range pids {
+		assert.False(t, processAlive(pid), "pid %d still alive", pid)
+	}
+}
+
+func TestBashTimeoutIgnoreSIGTERM(t *testing.T) {
+	setKillGrace(t, 1)
+	pidFile := tempPidFile(t)
+
+	// 忽略 SIGTERM 的进程在 grace 之后被 SIGKILL
+	command := fmt.Sprintf(`trap '' TERM
+bash -c "trap '' TERM; sleep 60" &
+echo $! >> %s
+sleep 60 || sleep 60`, pidFile)
+	l, cost, err := runBash(command, 1)
+	assert.NotNil(t, err)
+	assert.Equal(t, RunTimeout, l.Status)
+	assert.GreaterOrEqual(t, int64(cost), int64(2*time.Second))
+	assert.Less(t, int64(cost), int64(5*time.Second))
+
+	time.Sleep(100 * time.Millisecond)
+	for _, pid := range readPids(t, pidFile) {
+		assert.False(t, processAlive(pid), "pid %d still alive", pid)
+	}
+}
+
+func TestBashBackgroundChildHoldsPipe(t *testing.T) {
+	setKillGrace(t, 1)
+
+	// bash 已经退出 但是后台子进程仍然持有输出管道
+	l, cost, err := runBash("echo started; sleep 60 &", 1)
+	assert.NotNil(t, err)
+	assert.Equal(t, RunTimeout, l.Status)
+	assert.Contains(t, l.StdOut, "started")
+	assert.Less(t, int64(cost), int64(4*time.Second))
+}
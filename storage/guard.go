@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"clock/v3/config"
+	"fmt"
+	"regexp"
+)
+
+//commandRule 高危命令规则 命中之后拒绝新增以及修改 bash 任务
+type commandRule struct {
+	name    string
+	pattern string
+}
+
+//defaultDenyRules 内置的高危命令 可以通过 guard.deny 增加
+var defaultDenyRules = []commandRule{
+	{"rm on root or home directory", `(?m)\brm\s+(-[-\w]+\s+)*(/\*?|~/?|\$HOME/?)(\s|;|&|\||$)`},
+	{"rm --no-preserve-root", `\brm\b.*--no-preserve-root`},
+	{"mkfs", `\bmkfs(\.\w+)?\b`},
+	{"dd to block device", `\bdd\b.*\bof=/dev/(sd|hd|vd|xvd|nvme|mmcblk)`},
+	{"write to block device", `>\s*/dev/(sd|hd|vd|xvd|nvme|mmcblk)`},
+	{"fork bomb", `:\s*\(\)\s*\{\s*:\s*\|\s*:\s*&\s*\}`},
+	{"pipe download to shell", `\b(curl|wget)\b[^|;&]*\|\s*(sudo\s+)?(ba|z|da|k)?sh\b`},
+	{"recursive chmod or chown on root", `(?m)\bch(mod|own)\s+(\S+\s+)*-\w*R\w*\s+(\S+\s+)*/(\s|;|&|$)`},
+	{"shutdown or reboot", `\b(shutdown|reboot|halt|poweroff)\b|\binit\s+[06]\b`},
+}
+
+//GuardEnabled 是否开启高危命令检测 没有配置 guard.enable 的时候默认开启
+func GuardEnabled() bool {
+	return !config.Config.IsSet("guard.enable") || config.Config.GetBool("guard.enable")
+}
+
+//denyRules 内置规则加上 guard.deny 中配置的正则
+func denyRules() []commandRule {
+	rules := append([]commandRule{}, defaultDenyRules...)
+	for _, pattern := range config.Config.GetStringSlice("guard.deny") {
+		rules = append(rules, commandRule{name: pattern, pattern: pattern})
+	}
+	return rules
+}
+
+//CheckCommand 检测 bash 命令 完整匹配 guard.allow 中任意一个正则的命令直接放行
+//否则命中任意一条拒绝规则返回拒绝原因 配置的正则不合法时同样拒绝
+func CheckCommand(command string) error {
+	if !GuardEnabled() {
+		return nil
+	}
+	for _, pattern := range config.Config.GetStringSlice("guard.allow") {
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return fmt.Errorf("invalid guard.allow pattern %q: %v", pattern, err)
+		}
+		if re.MatchString(command) {
+			return nil
+		}
+	}
+	for _, rule := range denyRules() {
+		re, err := regexp.Compile(rule.pattern)
+		if err != nil {
+			return fmt.Errorf("invalid guard.deny pattern %q: %v", rule.pattern, err)
+		}
+		if m := re.FindString(command); m != "" {
+			return fmt.Errorf("dangerous command rejected by rule %q: %q", rule.name, m)
+		}
+	}
+	return nil
+}
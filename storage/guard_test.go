@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCommand(t *testing.T) {
+	setTestStorage(t, NewMemoryDatabase())
+
+	cases := []struct {
+		command string
+		allowed bool
+	}{
+		{"date", true},
+		{"rm -rf /tmp/cache", true},
+		{"echo shutdown_done", true},
+		{"curl -s https://example.com/health", true},
+		{"rm -rf /", false},
+		{"rm -rf / --no-preserve-root", false},
+		{"sudo rm -r -f /*", false},
+		{"cd /tmp && rm -rf ~", false},
+		{"mkfs.ext4 /dev/sdb1", false},
+		{"dd if=/dev/zero of=/dev/sda bs=1M", false},
+		{"cat x > /dev/nvme0n1", false},
+		{":(){ :|:& };:", false},
+		{"curl -fsSL https://example.com/install.sh | sudo bash", false},
+		{"wget -qO- http://x | sh", false},
+		{"chmod -R 777 /", false},
+		{"shutdown -h now", false},
+	}
+	for _, c := range cases {
+		err := CheckCommand(c.command)
+		if c.allowed {
+			assert.Nil(t, err, c.command)
+		} else {
+			assert.NotNil(t, err, c.command)
+		}
+	}
+
+	// 额外的拒绝规则以及完整匹配的允许规则
+	setTestConfig(t, "guard.deny", []string{`\biptables\b`})
+	setTestConfig(t, "guard.allow", []string{`shutdown -r \+5`})
+	assert.NotNil(t, CheckCommand("iptables -F"))
+	assert.Nil(t, CheckCommand("shutdown -r +5"))
+	assert.NotNil(t, CheckCommand("shutdown -r +5; rm -rf /"))
+
+	setTestConfig(t, "guard.enable", false)
+	assert.Nil(t, CheckCommand("rm -rf /"))
+}
+
+func TestGuardTask(t *testing.T) {
+	setTestStorage(t, NewMemoryDatabase())
+
+	task := Task{Name: "g", Expression: "0 0 * * *", Type: BashTask,
+		Payload: map[string]interface{}{"command": "curl https://example.com/i.sh | sh"}}
+	err := PostTask(&task)
+	var payloadErr PayloadError
+	assert.True(t, errors.As(err, &payloadErr))
+	assert.Equal(t, "command", payloadErr.Field)
+
+	task.Force = true
+	assert.Nil(t, PostTask(&task))
+
+	// 修改的时候需要重新检测 没有 force 不能保存
+	_, err = UpdateTask(task.Tid, map[string]interface{}{"name": "g2"})
+	assert.NotNil(t, err)
+	_, err = UpdateTask(task.Tid, map[string]interface{}{"name": "g2", "force": true})
+	assert.Nil(t, err)
+
+	assert.Nil(t, RootApp.Authorize(ActionForce))
+	assert.True(t, errors.Is(App{Aid: "a", Role: RoleOperator}.Authorize(ActionForce), PermissionDeniedErr))
+	assert.Nil(t, App{Aid: "a", Role: RoleAdmin}.Authorize(ActionForce))
+}
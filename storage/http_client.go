@@ -5,6 +5,7 @@ package storage
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -48,8 +49,14 @@ func PostJson(url string, body string, timeout int) ResponseWrapper {
 }
 
 func request(req *http.Request, timeout int) ResponseWrapper {
+	return requestWithTransport(req, timeout, nil, 0)
+}
+
+//requestWithTransport transport 为 nil 的时候使用默认的 Transport
+//limit 大于 0 的时候返回值最多保留 limit 字节的头尾 超过的部分读取之后丢弃 不会全部读入内存
+func requestWithTransport(req *http.Request, timeout int, transport http.RoundTripper, limit int) ResponseWrapper {
 	wrapper := ResponseWrapper{StatusCode: 0, Body: "", Header: make(http.Header)}
-	client := &http.Client{}
+	client := &http.Client{Transport: transport}
 	if timeout > 0 {
 		client.Timeout = time.Duration(timeout) * time.Second
 	}
@@ -60,20 +67,35 @@ func request(req *http.Request, timeout int) ResponseWrapper {
 		return wrapper
 	}
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body) // 这里需要进行读取，否则无法重用 TCP 连接
+	body, err := readBody(resp.Body, limit) // 这里需要进行读取，否则无法重用 TCP 连接
 	if err != nil {
 		wrapper.Body = fmt.Sprintf("读取HTTP请求返回值失败-%s", err.Error())
 		return wrapper
 	}
 	wrapper.StatusCode = resp.StatusCode
-	wrapper.Body = string(body)
+	wrapper.Body = body
 	wrapper.Header = resp.Header
 
 	return wrapper
 }
 
+//readBody 和 bash 的输出一样 超过 limit 之后保留头尾
+func readBody(r io.Reader, limit int) (string, error) {
+	if limit <= 0 {
+		body, err := ioutil.ReadAll(r)
+		return string(body), err
+	}
+	o := newOutputCapture(limit)
+	if _, err := io.Copy(o, r); err != nil {
+		return "", err
+	}
+	return o.String(), nil
+}
+
 func setRequestHeader(req *http.Request) {
-	req.Header.Set("User-Agent", "golang/clock")
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "golang/clock")
+	}
 }
 
 func createRequestError(err error) ResponseWrapper {
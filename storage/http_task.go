@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"text/template"
+)
+
+//httpBodyContext body 模板中可以使用的变量 例如 {{.Tid}} {{.Delay}}
+type httpBodyContext struct {
+	Tid     string
+	Name    string
+	Delay   string // 任务所在时区的当前时间 RFC3339
+	Attempt int
+}
+
+//renderHTTPBody 渲染请求体模板
+func renderHTTPBody(body string, ctx httpBodyContext) (string, error) {
+	tpl, err := template.New("body").Option("missingkey=error").Parse(body)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, ctx); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+//hasBody GET 以及 HEAD 请求不发送请求体
+func (p HTTPTaskPayload) hasBody() bool {
+	return p.Method != "GET" && p.Method != "HEAD"
+}
+
+//newRequest 根据 payload 构造请求 data 中会加入 delay 字段 同时返回请求体用于签名
+func (p HTTPTaskPayload) newRequest(ctx httpBodyContext) (*http.Request, []byte, error) {
+	u, err := url.Parse(p.EndPoint + p.Prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(p.Query) > 0 {
+		q := u.Query()
+		for k, v := range p.Query {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	var b []byte
+	var body io.Reader
+	if p.hasBody() {
+		if p.Body != "" {
+			s, err := renderHTTPBody(p.Body, ctx)
+			if err != nil {
+				return nil, nil, fmt.Errorf("render body err: %v", err)
+			}
+			b = []byte(s)
+		} else {
+			data, ok := p.Data.(map[string]interface{})
+			if !ok {
+				data = make(map[string]interface{})
+			}
+			// 加入 delay 时间到 data 中
+			data["delay"] = ctx.Delay
+			if b, err = json.Marshal(data); err != nil {
+				return nil, nil, fmt.Errorf("data 序列化失败 %v", err)
+			}
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(p.Method, u.String(), body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+	if a := p.Auth; a != nil {
+		switch a.Type {
+		case "basic":
+			req.SetBasicAuth(a.Username, a.Password)
+		case "bearer":
+			req.Header.Set("Authorization", "Bearer "+a.Token)
+		}
+	}
+	return req, b, nil
+}
+
+//config 根据 TLS 配置生成 tls.Config 没有配置返回 nil 使用默认配置
+func (c *HTTPTLS) config() (*tls.Config, error) {
+	if c == nil || (c.CA == "" && !c.SkipVerify) {
+		return nil, nil
+	}
+	conf := &tls.Config{InsecureSkipVerify: c.SkipVerify}
+	if c.CA != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(c.CA)) {
+			return nil, errors.New("no valid PEM certificate found")
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
+}
+
+//tlsTransports 相同 TLS 配置的任务共用一个 Transport 复用连接 空闲连接按照默认的 IdleConnTimeout 关闭
+var tlsTransports = struct {
+	sync.Mutex
+	m map[HTTPTLS]*http.Transport
+}{m: make(map[HTTPTLS]*http.Transport)}
+
+//transport 没有配置 TLS 返回 nil 使用默认的 Transport
+func (c *HTTPTLS) transport() (http.RoundTripper, error) {
+	conf, err := c.config()
+	if err != nil || conf == nil {
+		return nil, err
+	}
+	tlsTransports.Lock()
+	defer tlsTransports.Unlock()
+	if tr, ok := tlsTransports.m[*c]; ok {
+		return tr, nil
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = conf
+	tlsTransports.m[*c] = tr
+	return tr, nil
+}
+
+//check 校验响应是否满足成功条件 不满足返回原因
+func (c *HTTPSuccess) check(resp ResponseWrapper) error {
+	if c == nil || len(c.StatusCodes) == 0 {
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+	} else if !inStatusCodes(c.StatusCodes, resp.StatusCode) {
+		return fmt.Errorf("unexpected status code %d, expected one of %v", resp.StatusCode, c.StatusCodes)
+	}
+	if c == nil {
+		return nil
+	}
+	if c.BodyRegex != "" {
+		re, err := regexp.Compile(c.BodyRegex)
+		if err != nil {
+			return err
+		}
+		if !re.MatchString(resp.Body) {
+			return fmt.Errorf("response body does not match %s", c.BodyRegex)
+		}
+	}
+	if c.JSONPath != "" {
+		v, ok, err := lookupJSONPath(resp.Body, c.JSONPath)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("json path %s not found in response body", c.JSONPath)
+		}
+		if c.JSONValue != "" && jsonValueString(v) != c.JSONValue {
+			return fmt.Errorf("json path %s is %s, expected %s", c.JSONPath, jsonValueString(v), c.JSONValue)
+		}
+	}
+	return nil
+}
+
+func inStatusCodes(codes []int, code int) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
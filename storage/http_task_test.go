@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runHTTPTask(t *testing.T, payload map[string]interface{}) (TaskLog, error) {
+	task := Task{Tid: "http", Name: "http", Type: HTTPTask, Payload: payload}
+	l := newTaskLog(task, time.Now())
+	err := RunHTTPTask(task, "2021-01-01T00:00:00+08:00", &l)
+	return l, err
+}
+
+func TestHTTPTaskRequest(t *testing.T) {
+	setTestStorage(t, NewMemoryDatabase())
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got, body = r, string(b)
+		_, _ = w.Write([]byte(`{"data": {"items": [{"status": "ok"}]}}`))
+	}))
+	defer srv.Close()
+
+	l, err := runHTTPTask(t, map[string]interface{}{
+		"endpoint": srv.URL,
+		"prefix":   "/hook",
+		"method":   "PUT",
+		"headers":  map[string]interface{}{"X-Env": "prod"},
+		"query":    map[string]interface{}{"q": "a b"},
+		"auth":     map[string]interface{}{"type": "basic", "username": "u", "password": "p"},
+		"body":     `{"tid": "{{.Tid}}", "delay": "{{.Delay}}"}`,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, RunSuccess, l.Status)
+	assert.Equal(t, http.MethodPut, got.Method)
+	assert.Equal(t, "/hook", got.URL.Path)
+	assert.Equal(t, "a b", got.URL.Query().Get("q"))
+	assert.Equal(t, "prod", got.Header.Get("X-Env"))
+	user, pass, ok := got.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "u", user)
+	assert.Equal(t, "p", pass)
+	assert.Equal(t, `{"tid": "http", "delay": "2021-01-01T00:00:00+08:00"}`, body)
+
+	// 没有 body 模板的时候发送 data 并加入 delay
+	_, err = runHTTPTask(t, map[string]interface{}{
+		"endpoint": srv.URL,
+		"method":   "PATCH",
+		"data":     map[string]interface{}{"a": 1},
+		"auth":     map[string]interface{}{"type": "bearer", "token": "t"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer t", got.Header.Get("Authorization"))
+	data := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal([]byte(body), &data))
+	assert.Equal(t, map[string]interface{}{"a": float64(1), "delay": "2021-01-01T00:00:00+08:00"}, data)
+
+	_, err = runHTTPTask(t, map[string]interface{}{"endpoint": srv.URL, "method": "HEAD"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.MethodHead, got.Method)
+}
+
+func TestHTTPTaskSuccess(t *testing.T) {
+	setTestStorage(t, NewMemoryDatabase())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/accepted" {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		_, _ = w.Write([]byte(`{"data": {"items": [{"status": "ok", "count": 2}]}}`))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name    string
+		prefix  string
+		success map[string]interface{}
+		status  string
+		code    int
+		stdErr  string
+	}{
+		{"default ok", "/", nil, RunSuccess, 200, ""},
+		{"default 404", "/missing", nil, RunFailed, 404, "unexpected status code 404"},
+		{"status codes", "/accepted", map[string]interface{}{"status_codes": []int{200}}, RunFailed, 202, "unexpected status code 202, expected one of [200]"},
+		{"allow 404", "/missing", map[string]interface{}{"status_codes": []int{404}}, RunSuccess, 404, ""},
+		{"regex", "/", map[string]interface{}{"body_regex": `"status":\s*"failed"`}, RunFailed, 200, "response body does not match"},
+		{"json path", "/", map[string]interface{}{"json_path": "$.data.items[0].status", "json_value": "ok"}, RunSuccess, 200, ""},
+		{"json number", "/", map[string]interface{}{"json_path": "$.data.items[0].count", "json_value": "3"}, RunFailed, 200, "json path $.data.items[0].count is 2, expected 3"},
+		{"json missing", "/", map[string]interface{}{"json_path": "$.data.items[1]"}, RunFailed, 200, "json path $.data.items[1] not found"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			payload := map[string]interface{}{"endpoint": srv.URL, "prefix": c.prefix, "method": "GET"}
+			if c.success != nil {
+				payload["success"] = c.success
+			}
+			l, err := runHTTPTask(t, payload)
+			assert.Equal(t, c.status, l.Status)
+			assert.Equal(t, c.code, l.Code)
+			if c.stdErr == "" {
+				assert.Nil(t, err)
+				return
+			}
+			assert.NotNil(t, err)
+			assert.Contains(t, l.StdErr, c.stdErr)
+		})
+	}
+}
+
+func TestHTTPTaskTLS(t *testing.T) {
+	setTestStorage(t, NewMemoryDatabase())
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	// 自签名证书默认校验失败
+	l, err := runHTTPTask(t, map[string]interface{}{"endpoint": srv.URL, "method": "GET"})
+	assert.NotNil(t, err)
+	assert.Equal(t, RunFailed, l.Status)
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	l, err = runHTTPTask(t, map[string]interface{}{"endpoint": srv.URL, "method": "GET", "tls": map[string]interface{}{"ca": string(ca)}})
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", l.StdOut)
+
+	l, err = runHTTPTask(t, map[string]interface{}{"endpoint": srv.URL, "method": "GET", "tls": map[string]interface{}{"skip_verify": true}})
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", l.StdOut)
+	// 相同的 TLS 配置共用 Transport 不会每次运行都新建连接池
+	first, err := (&HTTPTLS{SkipVerify: true}).transport()
+	assert.Nil(t, err)
+	second, err := (&HTTPTLS{SkipVerify: true}).transport()
+	assert.Nil(t, err)
+	assert.Same(t, first, second)
+	none, err := (&HTTPTLS{}).transport()
+	assert.Nil(t, err)
+	assert.Nil(t, none)
+}
+
+func TestHTTPTaskSign(t *testing.T) {
+	setTestStorage(t, NewMemoryDatabase())
+	ctx := context.Background()
+	app := App{AppName: "billing"}
+	assert.Nil(t, CreateApp(ctx, &app))
+
+	var verified bool
+	var appKey string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		appKey = r.Header.Get(HeaderAppKey)
+		verified = VerifySignature(app.SecretKey, r, b)
+	}))
+	defer srv.Close()
+
+	l, err := runHTTPTask(t, map[string]interface{}{"aid": app.Aid, "endpoint": srv.URL, "method": "POST", "data": map[string]interface{}{"a": 1}})
+	assert.Nil(t, err)
+	assert.Equal(t, RunSuccess, l.Status)
+	assert.True(t, verified)
+	assert.Equal(t, app.AppKey, appKey)
+
+	_, err = runHTTPTask(t, map[string]interface{}{"aid": app.Aid, "endpoint": srv.URL, "method": "GET"})
+	assert.Nil(t, err)
+	assert.True(t, verified)
+
+	// 篡改请求体之后校验失败
+	req, _ := http.NewRequest("POST", srv.URL, nil)
+	assert.Nil(t, signRequest(req, SignScopeTask, app.AppKey, app.SecretKey, []byte("a")))
+	assert.True(t, VerifySignature(app.SecretKey, req, []byte("a")))
+	assert.False(t, VerifySignature(app.SecretKey, req, []byte("b")))
+	assert.False(t, VerifySignature("other", req, []byte("a")))
+	// 转发到其他服务或者接口之后校验失败
+	other, _ := http.NewRequest("POST", "http://other.example.com/", nil)
+	other.Header = req.Header
+	assert.False(t, VerifySignature(app.SecretKey, other, []byte("a")))
+
+	// 删除 app 之后不能再创建或者执行引用它的任务
+	task := Task{Name: "sign", Expression: "0 0 * * *", Type: HTTPTask, Payload: map[string]interface{}{"aid": app.Aid, "endpoint": srv.URL, "method": "GET"}}
+	assert.Nil(t, PostTask(&task))
+	assert.Nil(t, DeleteApp(ctx, app.Aid))
+	assert.EqualError(t, PostTask(&task), "payload.aid: "+AppUnavailableErr.Error())
+	l, err = runHTTPTask(t, map[string]interface{}{"aid": app.Aid, "endpoint": srv.URL, "method": "GET"})
+	assert.Equal(t, AppUnavailableErr, err)
+	assert.Equal(t, RunFailed, l.Status)
+}
+
+func TestHTTPTaskOutputLimit(t *testing.T) {
+	setTestStorage(t, NewMemoryDatabase())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ab" + strings.Repeat("-", 1<<20) + "yz"))
+	}))
+	defer srv.Close()
+
+	// 读取的时候就截断 不会把整个返回值读入内存
+	task := Task{Tid: "http", Name: "http", Type: HTTPTask, OutputLimit: 4,
+		Payload: map[string]interface{}{"endpoint": srv.URL, "method": "GET"}}
+	l := newTaskLog(task, time.Now())
+	assert.Nil(t, RunHTTPTask(task, "", &l))
+	assert.Equal(t, fmt.Sprintf("ab\n... [truncated %d bytes] ...\nyz", 1<<20), l.StdOut)
+}
+
+func TestHTTPTaskSignWithoutSecret(t *testing.T) {
+	setTestStorage(t, NewMemoryDatabase())
+	ctx := context.Background()
+	// 之前的版本创建的 app 没有密钥
+	app := App{AppName: "legacy"}
+	assert.Nil(t, CreateApp(ctx, &app))
+	assert.Nil(t, DB.App().Update(ctx, app.Aid, map[string]interface{}{"app_key": "", "secret_key": ""}))
+
+	payload := map[string]interface{}{"aid": app.Aid, "endpoint": "http://127.0.0.1/hook", "method": "GET"}
+	task := Task{Name: "legacy", Expression: "0 0 * * *", Type: HTTPTask, Payload: payload}
+	assert.EqualError(t, PostTask(&task), "payload.aid: "+AppNoSecretErr.Error())
+	req, _ := http.NewRequest("GET", "http://127.0.0.1/hook", nil)
+	assert.Equal(t, AppNoSecretErr, signHTTPRequest(req, app.Aid, nil))
+	assert.Empty(t, req.Header.Get(HeaderSignature))
+
+	// 重新生成密钥之后可以使用
+	got, err := ResetAppKeys(ctx, app.Aid)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, got.AppKey)
+	assert.NotEmpty(t, got.SecretKey)
+	assert.Nil(t, PostTask(&task))
+	assert.Nil(t, signHTTPRequest(req, app.Aid, nil))
+	assert.True(t, VerifySignature(got.SecretKey, req, nil))
+}
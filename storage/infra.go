@@ -1,20 +1,146 @@
 package storage
 
-import "os"
+import (
+	"context"
+	"os"
+	"time"
+)
 
 type Cache interface {
 	// 获取单例 类型断言
 
 	// 分布式锁
 	TryLock(Task) (chan int, error)
+	// 分布式锁 最多 n 个实例同时持有 用于并发策略 allow
+	TryLockN(Task, int) (chan int, error)
 	// 续租
 	LeaseJob(chan int, string, string)
 	// 发布
 	Publish([]byte) error
 	// 订阅
 	Subscribe(chan os.Signal)
+	// 发布到指定频道 例如实时日志
+	PublishTo(string, []byte) error
+	// 订阅指定频道 返回消息通道以及取消订阅的函数
+	SubscribeTo(string) (<-chan []byte, func(), error)
+	// 指定频道的订阅者数量 例如没有人查看实时日志的时候不发布
+	NumSub(string) (int64, error)
+	// key 不存在的时候写入并在 ttl 之后过期 返回是否写入成功 例如签名的 nonce 防重放
+	SetNX(string, time.Duration) (bool, error)
+	// 写入 key 并在 ttl 之后过期 例如 worker 心跳
+	Set(string, []byte, time.Duration) error
+	// 获取所有以 prefix 开头并且没有过期的 key
+	GetPrefix(string) (map[string][]byte, error)
+	// 删除 key
+	Del(string) error
 	// ping
 	Ping() (string, error)
 	// close conn
 	Close() error
 }
+
+// 持久化存储 由 database.driver 决定具体实现
+type Database interface {
+	// 任务
+	Task() TaskRepository
+	// 任务日志
+	TaskLog() TaskLogRepository
+	// 支持的时区
+	Timezone() TimezoneRepository
+	// 应用
+	App() AppRepository
+	// 工作流
+	Workflow() WorkflowRepository
+	// 工作流运行记录
+	WorkflowRun() WorkflowRunRepository
+	// 审计日志
+	Audit() AuditRepository
+	// ping
+	Ping(context.Context) error
+	// close conn
+	Close(context.Context) error
+}
+
+type TaskRepository interface {
+	// 分页查询 会回写 query.Total
+	Find(context.Context, *TaskQuery) ([]Task, error)
+	// 所有任务 用于调度器初始化
+	FindAll(context.Context) ([]Task, error)
+	// 根据 tid 查询
+	FindOne(context.Context, string) (Task, error)
+	Insert(context.Context, *Task) error
+	// 根据 tid 更新部分字段 key 为 bson tag
+	Update(context.Context, string, map[string]interface{}) error
+	Delete(context.Context, string) error
+}
+
+type TaskLogRepository interface {
+	// 分页查询 按照 create_at 倒序 会回写 query.Total
+	Find(context.Context, *LogQuery) ([]TaskLog, error)
+	// 根据 lid 查询
+	FindOne(context.Context, string) (TaskLog, error)
+	Insert(context.Context, *TaskLog) error
+	// 根据 lid 更新部分字段 key 为 bson tag
+	Update(context.Context, string, map[string]interface{}) error
+	// 根据查询条件删除 返回删除的数量
+	Delete(context.Context, *LogQuery) (int64, error)
+}
+
+type TimezoneRepository interface {
+	// 所有时区 按照 label 倒序
+	FindAll(context.Context) ([]Timezone, error)
+	FindOne(context.Context, string) (Timezone, error)
+	Insert(context.Context, *Timezone) error
+	Delete(context.Context, string) error
+}
+
+type AppRepository interface {
+	// 所有应用 按照 create_at 倒序
+	FindAll(context.Context) ([]App, error)
+	FindOne(context.Context, string) (App, error)
+	// 根据 app_key 查询 用于 api 认证
+	FindByAppKey(context.Context, string) (App, error)
+	Insert(context.Context, *App) error
+	// 根据 aid 更新部分字段 key 为 bson tag
+	Update(context.Context, string, map[string]interface{}) error
+	// 记录一次调用 更新 last_used 并且 counter 原子加一
+	Touch(context.Context, string, int64) error
+}
+
+type WorkflowRepository interface {
+	// 分页查询 按照 create_at 倒序 会回写 query.Total
+	Find(context.Context, *WorkflowQuery) ([]Workflow, error)
+	// 所有工作流 用于调度器初始化
+	FindAll(context.Context) ([]Workflow, error)
+	// 根据 wid 查询
+	FindOne(context.Context, string) (Workflow, error)
+	Insert(context.Context, *Workflow) error
+	// 根据 wid 更新部分字段 key 为 bson tag
+	Update(context.Context, string, map[string]interface{}) error
+	Delete(context.Context, string) error
+}
+
+type WorkflowRunRepository interface {
+	// 分页查询 按照 create_at 倒序 会回写 query.Total
+	Find(context.Context, *WorkflowRunQuery) ([]WorkflowRun, error)
+	Insert(context.Context, *WorkflowRun) error
+	// 根据 rid 更新部分字段 key 为 bson tag
+	Update(context.Context, string, map[string]interface{}) error
+}
+
+//AuditRepository 审计日志只追加 不提供修改以及删除
+type AuditRepository interface {
+	// 分页查询 按照 create_at 倒序 会回写 query.Total
+	Find(context.Context, *AuditQuery) ([]AuditLog, error)
+	Insert(context.Context, *AuditLog) error
+}
+
+//Executor 作业执行器 每一种作业类型对应一个 通过 RegisterExecutor 注册
+type Executor interface {
+	// 校验 payload 创建和更新任务的时候调用 返回字段级别的错误
+	Validate(Task) error
+	// 执行一次任务 运行结果以及输出记录到 TaskLog 中 失败时返回 error
+	Run(Task, *TaskLog) error
+	// 是否支持在运行过程中把输出写入日志 stream_output
+	Streamable() bool
+}
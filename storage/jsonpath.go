@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//jsonPathStep json path 中的一步 key 为空时表示数组下标
+type jsonPathStep struct {
+	key   string
+	index int
+}
+
+//parseJSONPath 解析简单的 json path 支持 $.a.b[0].c 形式 不支持通配符以及过滤表达式
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	p := strings.TrimSpace(path)
+	if !strings.HasPrefix(p, "$") {
+		return nil, fmt.Errorf("json path %q must start with $", path)
+	}
+	p = p[1:]
+	var steps []jsonPathStep
+	for p != "" {
+		switch p[0] {
+		case '.':
+			end := strings.IndexAny(p[1:], ".[")
+			if end < 0 {
+				end = len(p) - 1
+			}
+			key := p[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("json path %q has an empty key", path)
+			}
+			steps = append(steps, jsonPathStep{key: key})
+			p = p[end+1:]
+		case '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("json path %q has an unclosed [", path)
+			}
+			i, err := strconv.Atoi(p[1:end])
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("json path %q has an invalid index %s", path, p[1:end])
+			}
+			steps = append(steps, jsonPathStep{index: i})
+			p = p[end+1:]
+		default:
+			return nil, fmt.Errorf("json path %q is invalid near %s", path, p)
+		}
+	}
+	return steps, nil
+}
+
+//lookupJSONPath 在 json 文本中查找 path 对应的值 没有找到返回 false
+func lookupJSONPath(body string, path string) (interface{}, bool, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		return nil, false, fmt.Errorf("response body is not json: %v", err)
+	}
+	for _, step := range steps {
+		if step.key != "" {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false, nil
+			}
+			if v, ok = m[step.key]; !ok {
+				return nil, false, nil
+			}
+			continue
+		}
+		a, ok := v.([]interface{})
+		if !ok || step.index >= len(a) {
+			return nil, false, nil
+		}
+		v = a[step.index]
+	}
+	return v, true, nil
+}
+
+//jsonValueString 把 json 中的值转换为字符串用于比较 字符串不带引号
+func jsonValueString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"bytes"
+	"clock/v3/config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// 跟随任务接下来的一次运行
+const LatestRun = "latest"
+
+//liveEnabled 是否开启实时日志 需要 output.live 以及 pubsub.open
+func liveEnabled() bool {
+	return config.Config.GetBool("output.live") && config.Config.GetBool("pubsub.open")
+}
+
+//logChannel 任务实时日志的频道
+func logChannel(tid string) string {
+	return fmt.Sprintf("%s:log:%s", config.Config.GetString("pubsub.channel"), tid)
+}
+
+//publishLogLine 发布失败只记录日志 不影响任务运行
+func publishLogLine(tid string, line LogLine) {
+	msg, err := msgpack.Marshal(line)
+	if err != nil {
+		log.Errorf("[live] 序列化错误 %v", err)
+		return
+	}
+	if err = RCache.PublishTo(logChannel(tid), msg); err != nil {
+		log.Errorf("[live] 发布实时日志失败 %v", err)
+	}
+}
+
+//liveCheckInterval 重新检查是否有人查看实时日志的间隔
+const liveCheckInterval = time.Second
+
+//liveWriter 按行发布输出 没有换行的部分超过输出上限之后直接作为一行发布
+type liveWriter struct {
+	mu      sync.Mutex
+	tid     string
+	lid     string
+	stream  string
+	limit   int    // 一行最长的字节数 和输出上限相同
+	partial []byte // 还没有换行的部分
+
+	tailing bool      // 是否有人正在查看实时日志
+	checked time.Time // 上一次检查订阅者的时间
+}
+
+//newLiveWriters 没有开启实时日志的时候返回 nil
+func newLiveWriters(t Task, l *TaskLog) (*liveWriter, *liveWriter) {
+	if !liveEnabled() {
+		return nil, nil
+	}
+	limit := outputLimit(t)
+	return &liveWriter{tid: t.Tid, lid: l.Lid, stream: "stdout", limit: limit},
+		&liveWriter{tid: t.Tid, lid: l.Lid, stream: "stderr", limit: limit}
+}
+
+//hasTail 每隔 liveCheckInterval 检查一次频道的订阅者 检查失败的时候视为有人查看
+func (w *liveWriter) hasTail() bool {
+	if now := time.Now(); now.Sub(w.checked) >= liveCheckInterval {
+		n, err := RCache.NumSub(logChannel(w.tid))
+		if err != nil {
+			log.Errorf("[live] 获取实时日志订阅者失败 %v", err)
+		}
+		w.tailing = err != nil || n > 0
+		w.checked = now
+	}
+	return w.tailing
+}
+
+//Write 总是返回成功 避免影响 io.MultiWriter 中的其他 writer 没有人查看的时候直接丢弃
+func (w *liveWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.hasTail() {
+		w.partial = nil
+		return len(p), nil
+	}
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		publishLogLine(w.tid, LogLine{Lid: w.lid, Stream: w.stream, Line: string(w.partial[:i])})
+		w.partial = w.partial[i+1:]
+	}
+	// 例如二进制输出或者进度条 没有换行的时候不能无限增长
+	for w.limit > 0 && len(w.partial) >= w.limit {
+		publishLogLine(w.tid, LogLine{Lid: w.lid, Stream: w.stream, Line: string(w.partial[:w.limit])})
+		w.partial = w.partial[w.limit:]
+	}
+	if len(w.partial) == 0 {
+		w.partial = nil
+	}
+	return len(p), nil
+}
+
+//flush 发布最后没有换行的部分
+func (w *liveWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		publishLogLine(w.tid, LogLine{Lid: w.lid, Stream: w.stream, Line: string(w.partial)})
+		w.partial = nil
+	}
+}
+
+//publishRunStatus 每一次执行结束之后发布最终状态
+func publishRunStatus(t Task, l TaskLog) {
+	if !liveEnabled() {
+		return
+	}
+	publishLogLine(t.Tid, LogLine{Lid: l.Lid, Status: l.Status, Code: l.Code})
+}
+
+//TailRun 订阅任务某一次运行的实时日志 rid 为 latest 时跟随接下来的一次运行
+//Message.Channel 中为 json 格式的 LogLine 收到最终状态之后关闭
+func TailRun(ctx context.Context, tid, rid string, size int) (*Message, error) {
+	if _, err := GetTask(tid); err != nil {
+		return nil, err
+	}
+	// 先订阅再查询 防止查询之后订阅之前运行结束
+	sub, cancel, err := RCache.SubscribeTo(logChannel(tid))
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &Message{Size: size, Channel: make(chan string, size)}
+	if rid != LatestRun {
+		l, err := DB.TaskLog().FindOne(ctx, rid)
+		if err == nil && l.Tid != tid {
+			cancel()
+			return nil, NotFoundErr
+		}
+		if err == nil && l.Status != RunRunning {
+			// 已经运行结束 直接返回最终状态
+			cancel()
+			b, _ := json.Marshal(LogLine{Lid: l.Lid, Status: l.Status, Code: l.Code})
+			msg.Channel <- string(b)
+			close(msg.Channel)
+			return msg, nil
+		}
+	}
+
+	go func() {
+		defer close(msg.Channel)
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case payload := <-sub:
+				line := LogLine{}
+				if err := msgpack.Unmarshal(payload, &line); err != nil {
+					log.Errorf("[live] 解析实时日志错误 %v", err)
+					continue
+				}
+				if rid == LatestRun {
+					rid = line.Lid
+				}
+				if line.Lid != rid {
+					continue
+				}
+				b, _ := json.Marshal(line)
+				select {
+				case msg.Channel <- string(b):
+				case <-ctx.Done():
+					return
+				}
+				if line.Status != "" {
+					return
+				}
+			}
+		}
+	}()
+	return msg, nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func collectLines(t *testing.T, msg *Message) []LogLine {
+	lines := make([]LogLine, 0)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case s, ok := <-msg.Channel:
+			if !ok {
+				return lines
+			}
+			line := LogLine{}
+			assert.Nil(t, json.Unmarshal([]byte(s), &line))
+			lines = append(lines, line)
+		case <-timeout:
+			t.Fatal("tail run timeout")
+			return lines
+		}
+	}
+}
+
+func TestTailRun(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+	setTestConfig(t, "output.live", true)
+
+	task := newBashTask(t, "echo first; sleep 0.2; echo oops >&2; sleep 0.2; printf last; exit 2", true)
+
+	msg, err := TailRun(context.Background(), task.Tid, LatestRun, 16)
+	assert.Nil(t, err)
+	go func() {
+		_ = RunTask(task.Tid)
+	}()
+
+	lines := collectLines(t, msg)
+	assert.Equal(t, []LogLine{
+		{Lid: lines[0].Lid, Stream: "stdout", Line: "first"},
+		{Lid: lines[0].Lid, Stream: "stderr", Line: "oops"},
+		{Lid: lines[0].Lid, Stream: "stdout", Line: "last"},
+		{Lid: lines[0].Lid, Status: RunFailed, Code: 2},
+	}, lines)
+
+	// 已经结束的运行直接返回最终状态
+	query := LogQuery{}
+	query.Tid = task.Tid
+	logs := waitLogs(t, query, 1)
+	assert.Len(t, logs, 1)
+	msg, err = TailRun(context.Background(), task.Tid, logs[0].Lid, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, []LogLine{{Lid: logs[0].Lid, Status: RunFailed, Code: 2}}, collectLines(t, msg))
+
+	// 取消之后结束
+	ctx, cancel := context.WithCancel(context.Background())
+	msg, err = TailRun(ctx, task.Tid, LatestRun, 16)
+	assert.Nil(t, err)
+	cancel()
+	assert.Len(t, collectLines(t, msg), 0)
+}
+
+func TestLiveWriter(t *testing.T) {
+	setMemoryStorage(t)
+	setTestConfig(t, "output.live", true)
+
+	// 没有人查看的时候不发布也不缓存
+	w := &liveWriter{tid: "t1", lid: "l1", stream: "stdout", limit: 4}
+	_, _ = w.Write([]byte("abcdefghij"))
+	assert.Nil(t, w.partial)
+
+	sub, cancel, err := RCache.SubscribeTo(logChannel("t1"))
+	assert.Nil(t, err)
+	defer cancel()
+	w = &liveWriter{tid: "t1", lid: "l1", stream: "stdout", limit: 4}
+	// 没有换行的部分超过上限之后按照上限拆分
+	_, _ = w.Write([]byte("abcdefghij"))
+	assert.Equal(t, "ij", string(w.partial))
+	_, _ = w.Write([]byte("k\nl"))
+	w.flush()
+
+	var got []string
+	for len(got) < 4 {
+		select {
+		case payload := <-sub:
+			line := LogLine{}
+			assert.Nil(t, msgpack.Unmarshal(payload, &line))
+			got = append(got, line.Line)
+		case <-time.After(time.Second):
+			t.Fatal("live writer timeout")
+		}
+	}
+	assert.Equal(t, []string{"abcd", "efgh", "ijk", "l"}, got)
+}
@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"clock/v3/config"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 进程内的锁 到期时间之后视为不存在
+type memoryLock struct {
+	val      string
+	expireAt time.Time
+}
+
+//MemoryCache 进程内的 Cache 实现，用于单机模式以及测试
+//锁和续租语义与 RedisCache 保持一致，发布订阅只在当前进程内生效
+type MemoryCache struct {
+	mu     sync.Mutex
+	locks  map[string]memoryLock
+	subs   map[chan []byte]struct{}
+	chans  map[string]map[chan []byte]struct{} // 指定频道的订阅者
+	closed bool
+	done   chan struct{}
+
+	// 创建的时候读取 续租的 goroutine 不再读取配置
+	leasePer    time.Duration
+	leaseExpire time.Duration
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		locks:       make(map[string]memoryLock),
+		subs:        make(map[chan []byte]struct{}),
+		chans:       make(map[string]map[chan []byte]struct{}),
+		done:        make(chan struct{}),
+		leasePer:    config.Config.GetDuration("lease.per") * time.Second,
+		leaseExpire: config.Config.GetDuration("lease.expire") * time.Second,
+	}
+}
+
+//SetNX key 不存在或者已经过期的时候写入 复用锁的过期逻辑
+func (m *MemoryCache) SetNX(key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return false, MemoryCacheClosedErr
+	}
+	now := time.Now()
+	if l, ok := m.locks[key]; ok && now.Before(l.expireAt) {
+		return false, nil
+	}
+	m.locks[key] = memoryLock{val: "1", expireAt: now.Add(ttl)}
+	return true, nil
+}
+
+//Set 和锁共用同一个 map 到期之后视为不存在
+func (m *MemoryCache) Set(key string, val []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return MemoryCacheClosedErr
+	}
+	m.locks[key] = memoryLock{val: string(val), expireAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *MemoryCache) GetPrefix(prefix string) (map[string][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, MemoryCacheClosedErr
+	}
+	now := time.Now()
+	res := make(map[string][]byte)
+	for key, l := range m.locks {
+		if strings.HasPrefix(key, prefix) && now.Before(l.expireAt) {
+			res[key] = []byte(l.val)
+		}
+	}
+	return res, nil
+}
+
+func (m *MemoryCache) Del(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return MemoryCacheClosedErr
+	}
+	delete(m.locks, key)
+	return nil
+}
+
+//TryLock 进程内分布式锁 单进程不需要随机睡眠抢锁
+func (m *MemoryCache) TryLock(t Task) (chan int, error) {
+	return m.lock(fmt.Sprintf("%s:%s", config.Config.GetString("lease.prefix"), t.Tid), t)
+}
+
+//TryLockN 依次尝试 n 个锁 <lease.prefix>:<tid>:<i> 抢到任意一个即可
+func (m *MemoryCache) TryLockN(t Task, n int) (chan int, error) {
+	for i := 0; i < n; i++ {
+		jobDone, err := m.lock(fmt.Sprintf("%s:%s:%d", config.Config.GetString("lease.prefix"), t.Tid, i), t)
+		if err != WaitForNextScheduleErr {
+			return jobDone, err
+		}
+	}
+	return nil, WaitForNextScheduleErr
+}
+
+func (m *MemoryCache) lock(key string, t Task) (chan int, error) {
+	rid, _ := GenGuid(8)
+	val := fmt.Sprintf("%s:%s:%s", t.Tid, t.Name, rid)
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, MemoryCacheClosedErr
+	}
+	now := time.Now()
+	if l, ok := m.locks[key]; ok && now.Before(l.expireAt) {
+		m.mu.Unlock()
+		log.Debugf("[mcache] key %s 存在，等待下次调度", key)
+		return nil, WaitForNextScheduleErr
+	}
+	m.locks[key] = memoryLock{
+		val:      val,
+		expireAt: now.Add(m.leaseExpire),
+	}
+	m.mu.Unlock()
+
+	jobDone := make(chan int, 1) // 任务完成的时候 done <- 1
+	go m.LeaseJob(jobDone, key, val)
+	return jobDone, nil
+}
+
+//LeaseJob 续租 只有 value 相同才进行续租以及释放 cache 关闭之后停止续租
+func (m *MemoryCache) LeaseJob(done chan int, key string, val string) {
+	timer := time.NewTimer(m.leasePer)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			time.Sleep(time.Second) // 与 redis 保持一致 延迟一秒释放锁
+			m.mu.Lock()
+			if l, ok := m.locks[key]; ok && l.val == val {
+				delete(m.locks, key)
+				log.Debugf("[mcache] 删除 key %s 成功", key)
+			}
+			m.mu.Unlock()
+			return
+		case <-timer.C:
+			m.mu.Lock()
+			if l, ok := m.locks[key]; ok && l.val == val {
+				l.expireAt = time.Now().Add(m.leaseExpire)
+				m.locks[key] = l
+				log.Debugf("[mcache] 续租 %s", key)
+			}
+			m.mu.Unlock()
+			timer.Reset(m.leasePer)
+		case <-m.done:
+			return
+		}
+	}
+}
+
+//Publish 发布到当前进程内所有订阅者
+func (m *MemoryCache) Publish(event []byte) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return MemoryCacheClosedErr
+	}
+	subs := make([]chan []byte, 0, len(m.subs))
+	for ch := range m.subs {
+		subs = append(subs, ch)
+	}
+	m.mu.Unlock()
+
+	for _, ch := range subs {
+		select {
+		case ch <- event:
+		case <-m.done:
+			return MemoryCacheClosedErr
+		}
+	}
+	return nil
+}
+
+//Subscribe 订阅任务事件 直到 cache 被关闭
+func (m *MemoryCache) Subscribe(c chan os.Signal) {
+	ch := make(chan []byte, 64)
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		c <- os.Interrupt
+		log.Errorf("[scheduler] 消息订阅发生错误: %v", MemoryCacheClosedErr)
+		return
+	}
+	m.subs[ch] = struct{}{}
+	m.mu.Unlock()
+	log.Infof("[scheduler] 成功订阅 memory cache")
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.subs, ch)
+		m.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case msg := <-ch:
+			handleTaskEvent(msg)
+		case <-m.done:
+			log.Infof("[scheduler] memory cache 关闭，停止订阅")
+			return
+		}
+	}
+}
+
+//PublishTo 订阅者处理不过来的时候丢弃消息 不阻塞发布者
+func (m *MemoryCache) PublishTo(channel string, msg []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return MemoryCacheClosedErr
+	}
+	for ch := range m.chans[channel] {
+		select {
+		case ch <- msg:
+		default:
+			log.Warnf("[mcache] channel %s 订阅者处理不过来 丢弃消息", channel)
+		}
+	}
+	return nil
+}
+
+//SubscribeTo 取消订阅之后不会再收到消息 通道不会被关闭
+func (m *MemoryCache) SubscribeTo(channel string) (<-chan []byte, func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, nil, MemoryCacheClosedErr
+	}
+	ch := make(chan []byte, 256)
+	if m.chans[channel] == nil {
+		m.chans[channel] = make(map[chan []byte]struct{})
+	}
+	m.chans[channel][ch] = struct{}{}
+
+	cancel := func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.chans[channel], ch)
+		if len(m.chans[channel]) == 0 {
+			delete(m.chans, channel)
+		}
+	}
+	return ch, cancel, nil
+}
+
+func (m *MemoryCache) NumSub(channel string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return 0, MemoryCacheClosedErr
+	}
+	return int64(len(m.chans[channel])), nil
+}
+
+func (m *MemoryCache) Ping() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return "", MemoryCacheClosedErr
+	}
+	return "PONG", nil
+}
+
+func (m *MemoryCache) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.done)
+	}
+	return nil
+}
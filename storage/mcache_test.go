@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"clock/v3/config"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func setMemoryCacheConfig(per, expire int) {
+	config.Config = viper.New()
+	config.Config.Set("lease.prefix", "test:job")
+	config.Config.Set("lease.per", per)
+	config.Config.Set("lease.expire", expire)
+}
+
+func TestMemoryCacheTryLock(t *testing.T) {
+	setMemoryCacheConfig(20, 120)
+	m := NewMemoryCache()
+	defer m.Close()
+
+	task := Task{Tid: "t1", Name: "lock"}
+	done, err := m.TryLock(task)
+	assert.Nil(t, err)
+
+	_, err = m.TryLock(task)
+	assert.Equal(t, WaitForNextScheduleErr, err)
+
+	// 其他任务不受影响
+	other, err := m.TryLock(Task{Tid: "t2", Name: "other"})
+	assert.Nil(t, err)
+	other <- 1
+
+	// 完成之后延迟一秒释放
+	done <- 1
+	time.Sleep(1500 * time.Millisecond)
+	done, err = m.TryLock(task)
+	assert.Nil(t, err)
+	done <- 1
+}
+
+func TestMemoryCacheLockExpire(t *testing.T) {
+	// 续租周期大于过期时间 锁会自然过期
+	setMemoryCacheConfig(10, 1)
+	m := NewMemoryCache()
+	defer m.Close()
+
+	task := Task{Tid: "t1", Name: "expire"}
+	_, err := m.TryLock(task)
+	assert.Nil(t, err)
+
+	time.Sleep(1200 * time.Millisecond)
+	_, err = m.TryLock(task)
+	assert.Nil(t, err)
+}
+
+func TestMemoryCacheLease(t *testing.T) {
+	setMemoryCacheConfig(1, 2)
+	m := NewMemoryCache()
+	defer m.Close()
+
+	task := Task{Tid: "t1", Name: "lease"}
+	done, err := m.TryLock(task)
+	assert.Nil(t, err)
+
+	// 超过过期时间 但是一直在续租
+	time.Sleep(3 * time.Second)
+	_, err = m.TryLock(task)
+	assert.Equal(t, WaitForNextScheduleErr, err)
+	done <- 1
+}
+
+func TestMemoryCacheClose(t *testing.T) {
+	setMemoryCacheConfig(20, 120)
+	m := NewMemoryCache()
+
+	c := make(chan os.Signal, 1)
+	exit := make(chan struct{})
+	go func() {
+		m.Subscribe(c)
+		close(exit)
+	}()
+
+	pong, err := m.Ping()
+	assert.Nil(t, err)
+	assert.Equal(t, "PONG", pong)
+
+	assert.Nil(t, m.Close())
+	select {
+	case <-exit:
+	case <-time.After(time.Second):
+		t.Fatal("subscribe not stopped after close")
+	}
+
+	assert.Equal(t, MemoryCacheClosedErr, m.Publish([]byte("event")))
+	_, err = m.TryLock(Task{Tid: "t1"})
+	assert.Equal(t, MemoryCacheClosedErr, err)
+	_, err = m.Ping()
+	assert.Equal(t, MemoryCacheClosedErr, err)
+}
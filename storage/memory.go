@@ -0,0 +1,655 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+//MemoryDatabase 进程内存储实现，用于单机模式以及测试，进程退出数据即丢失
+type MemoryDatabase struct {
+	task     *memoryTaskRepository
+	taskLog  *memoryTaskLogRepository
+	timezone *memoryTimezoneRepository
+	app      *memoryAppRepository
+	workflow *memoryWorkflowRepository
+	run      *memoryWorkflowRunRepository
+	audit    *memoryAuditRepository
+}
+
+func NewMemoryDatabase() *MemoryDatabase {
+	return &MemoryDatabase{
+		task:     &memoryTaskRepository{},
+		taskLog:  &memoryTaskLogRepository{},
+		timezone: &memoryTimezoneRepository{},
+		app:      &memoryAppRepository{},
+		workflow: &memoryWorkflowRepository{},
+		run:      &memoryWorkflowRunRepository{},
+		audit:    &memoryAuditRepository{},
+	}
+}
+
+func (m *MemoryDatabase) Task() TaskRepository {
+	return m.task
+}
+
+func (m *MemoryDatabase) TaskLog() TaskLogRepository {
+	return m.taskLog
+}
+
+func (m *MemoryDatabase) Timezone() TimezoneRepository {
+	return m.timezone
+}
+
+func (m *MemoryDatabase) App() AppRepository {
+	return m.app
+}
+
+func (m *MemoryDatabase) Workflow() WorkflowRepository {
+	return m.workflow
+}
+
+func (m *MemoryDatabase) WorkflowRun() WorkflowRunRepository {
+	return m.run
+}
+
+func (m *MemoryDatabase) Audit() AuditRepository {
+	return m.audit
+}
+
+func (m *MemoryDatabase) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryDatabase) Close(ctx context.Context) error {
+	return nil
+}
+
+//toDocument 通过 bson 序列化转为 document，字段名与 mongo 保持一致
+func toDocument(v interface{}) bson.M {
+	doc := bson.M{}
+	b, err := bson.Marshal(v)
+	if err != nil {
+		return doc
+	}
+	_ = bson.Unmarshal(b, &doc)
+	return doc
+}
+
+//cloneDocument 通过 bson 序列化深拷贝 src 到 dst，避免外部修改 map 等引用类型影响存储的数据
+func cloneDocument(src, dst interface{}) error {
+	b, err := bson.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(b, dst)
+}
+
+//applyFields 将部分字段更新到结构体中 key 为 bson tag
+func applyFields(v interface{}, fields map[string]interface{}) error {
+	doc := toDocument(v)
+	for key, value := range fields {
+		doc[key] = value
+	}
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(b, v)
+}
+
+//matchDocument 判断 document 是否满足 GetWhereDb 生成的条件
+//只支持相等以及 $gt $lt 两种比较
+func matchDocument(doc bson.M, filter bson.D) bool {
+	for _, e := range filter {
+		value := doc[e.Key]
+		switch cond := e.Value.(type) {
+		case bson.D:
+			for _, c := range cond {
+				switch c.Key {
+				case "$gt":
+					if compareValue(value, c.Value) <= 0 {
+						return false
+					}
+				case "$lt":
+					if compareValue(value, c.Value) >= 0 {
+						return false
+					}
+				}
+			}
+		default:
+			// GetWhereDb 中的值都是字符串形式
+			if fmt.Sprintf("%v", value) != fmt.Sprintf("%v", cond) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//compareValue 比较两个值 数字按照大小，其他按照字符串
+func compareValue(a, b interface{}) int {
+	fa, aok := toFloat(a)
+	fb, bok := toFloat(b)
+	if aok && bok {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		default:
+			return 0
+		}
+	}
+	sa, sb := fmt.Sprintf("%v", a), fmt.Sprintf("%v", b)
+	switch {
+	case sa < sb:
+		return -1
+	case sa > sb:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+//pageRange 分页后的下标范围
+func pageRange(total, index, count int) (int, int) {
+	start := (index - 1) * count
+	if start > total {
+		start = total
+	}
+	end := start + count
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+// 任务
+type memoryTaskRepository struct {
+	mu    sync.RWMutex
+	tasks []Task
+}
+
+func (r *memoryTaskRepository) Find(ctx context.Context, query *TaskQuery) ([]Task, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	filter := GetWhereDb(query, nil)
+	tasks := make([]Task, 0)
+	docs := make([]bson.M, 0)
+	for _, t := range r.tasks {
+		doc := toDocument(t)
+		if matchDocument(doc, filter) {
+			var task Task
+			if err := cloneDocument(t, &task); err != nil {
+				return tasks, err
+			}
+			tasks = append(tasks, task)
+			docs = append(docs, doc)
+		}
+	}
+	query.Total = len(tasks)
+
+	if query.Order != "" {
+		sort.SliceStable(tasks, func(i, j int) bool {
+			return compareValue(docs[i][query.Order], docs[j][query.Order]) > 0
+		})
+	}
+	start, end := pageRange(len(tasks), query.Index, query.Count)
+	return tasks[start:end], nil
+}
+
+func (r *memoryTaskRepository) FindAll(ctx context.Context) ([]Task, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tasks := make([]Task, len(r.tasks))
+	for i := range r.tasks {
+		if err := cloneDocument(r.tasks[i], &tasks[i]); err != nil {
+			return tasks, err
+		}
+	}
+	return tasks, nil
+}
+
+func (r *memoryTaskRepository) FindOne(ctx context.Context, tid string) (Task, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var task Task
+	for _, t := range r.tasks {
+		if t.Tid == tid {
+			err := cloneDocument(t, &task)
+			return task, err
+		}
+	}
+	return task, NotFoundErr
+}
+
+func (r *memoryTaskRepository) Insert(ctx context.Context, t *Task) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var task Task
+	if err := cloneDocument(t, &task); err != nil {
+		return err
+	}
+	r.tasks = append(r.tasks, task)
+	return nil
+}
+
+func (r *memoryTaskRepository) Update(ctx context.Context, tid string, fields map[string]interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.tasks {
+		if r.tasks[i].Tid == tid {
+			return applyFields(&r.tasks[i], fields)
+		}
+	}
+	return NotFoundErr
+}
+
+func (r *memoryTaskRepository) Delete(ctx context.Context, tid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.tasks {
+		if r.tasks[i].Tid == tid {
+			r.tasks = append(r.tasks[:i], r.tasks[i+1:]...)
+			return nil
+		}
+	}
+	return NotFoundErr
+}
+
+// 任务日志
+type memoryTaskLogRepository struct {
+	mu   sync.RWMutex
+	logs []TaskLog
+}
+
+func (r *memoryTaskLogRepository) Find(ctx context.Context, query *LogQuery) ([]TaskLog, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	filter := logWhereDb(query)
+	logs := make([]TaskLog, 0)
+	for _, l := range r.logs {
+		if matchDocument(toDocument(l), filter) {
+			logs = append(logs, l)
+		}
+	}
+	query.Total = len(logs)
+
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].CreateAt > logs[j].CreateAt
+	})
+	start, end := pageRange(len(logs), query.Index, query.Count)
+	return logs[start:end], nil
+}
+
+func (r *memoryTaskLogRepository) FindOne(ctx context.Context, lid string) (TaskLog, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, l := range r.logs {
+		if l.Lid == lid {
+			return l, nil
+		}
+	}
+	return TaskLog{}, NotFoundErr
+}
+
+func (r *memoryTaskLogRepository) Insert(ctx context.Context, l *TaskLog) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var taskLog TaskLog
+	if err := cloneDocument(l, &taskLog); err != nil {
+		return err
+	}
+	r.logs = append(r.logs, taskLog)
+	return nil
+}
+
+func (r *memoryTaskLogRepository) Update(ctx context.Context, lid string, fields map[string]interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.logs {
+		if r.logs[i].Lid == lid {
+			return applyFields(&r.logs[i], fields)
+		}
+	}
+	return NotFoundErr
+}
+
+func (r *memoryTaskLogRepository) Delete(ctx context.Context, query *LogQuery) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	filter := logWhereDb(query)
+	logs := make([]TaskLog, 0, len(r.logs))
+	for _, l := range r.logs {
+		if !matchDocument(toDocument(l), filter) {
+			logs = append(logs, l)
+		}
+	}
+	count := int64(len(r.logs) - len(logs))
+	r.logs = logs
+	return count, nil
+}
+
+// 时区
+type memoryTimezoneRepository struct {
+	mu        sync.RWMutex
+	timezones []Timezone
+}
+
+func (r *memoryTimezoneRepository) FindAll(ctx context.Context) ([]Timezone, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	timezones := make([]Timezone, len(r.timezones))
+	copy(timezones, r.timezones)
+	sort.SliceStable(timezones, func(i, j int) bool {
+		return timezones[i].Label > timezones[j].Label
+	})
+	return timezones, nil
+}
+
+func (r *memoryTimezoneRepository) FindOne(ctx context.Context, tid string) (Timezone, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, t := range r.timezones {
+		if t.Tid == tid {
+			return t, nil
+		}
+	}
+	return Timezone{}, NotFoundErr
+}
+
+func (r *memoryTimezoneRepository) Insert(ctx context.Context, t *Timezone) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timezones = append(r.timezones, *t)
+	return nil
+}
+
+func (r *memoryTimezoneRepository) Delete(ctx context.Context, tid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.timezones {
+		if r.timezones[i].Tid == tid {
+			r.timezones = append(r.timezones[:i], r.timezones[i+1:]...)
+			return nil
+		}
+	}
+	return NotFoundErr
+}
+
+// 应用
+type memoryAppRepository struct {
+	mu   sync.RWMutex
+	apps []App
+}
+
+func (r *memoryAppRepository) FindAll(ctx context.Context) ([]App, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	apps := make([]App, len(r.apps))
+	copy(apps, r.apps)
+	for i := range apps {
+		apps[i].AppKey = ""
+		apps[i].SecretKey = ""
+	}
+	sort.SliceStable(apps, func(i, j int) bool {
+		return apps[i].CreateAt > apps[j].CreateAt
+	})
+	return apps, nil
+}
+
+func (r *memoryAppRepository) FindOne(ctx context.Context, aid string) (App, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, a := range r.apps {
+		if a.Aid == aid {
+			return a, nil
+		}
+	}
+	return App{}, NotFoundErr
+}
+
+func (r *memoryAppRepository) FindByAppKey(ctx context.Context, appKey string) (App, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, a := range r.apps {
+		if a.AppKey == appKey {
+			return a, nil
+		}
+	}
+	return App{}, NotFoundErr
+}
+
+func (r *memoryAppRepository) Insert(ctx context.Context, a *App) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.apps = append(r.apps, *a)
+	return nil
+}
+
+func (r *memoryAppRepository) Update(ctx context.Context, aid string, fields map[string]interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.apps {
+		if r.apps[i].Aid == aid {
+			return applyFields(&r.apps[i], fields)
+		}
+	}
+	return NotFoundErr
+}
+
+func (r *memoryAppRepository) Touch(ctx context.Context, aid string, lastUsed int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.apps {
+		if r.apps[i].Aid == aid {
+			r.apps[i].LastUsed = lastUsed
+			r.apps[i].Counter++
+			return nil
+		}
+	}
+	return NotFoundErr
+}
+
+// 工作流
+type memoryWorkflowRepository struct {
+	mu        sync.RWMutex
+	workflows []Workflow
+}
+
+func (r *memoryWorkflowRepository) Find(ctx context.Context, query *WorkflowQuery) ([]Workflow, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	filter := GetWhereDb(query, nil)
+	workflows := make([]Workflow, 0)
+	for _, w := range r.workflows {
+		if matchDocument(toDocument(w), filter) {
+			var workflow Workflow
+			if err := cloneDocument(w, &workflow); err != nil {
+				return workflows, err
+			}
+			workflows = append(workflows, workflow)
+		}
+	}
+	query.Total = len(workflows)
+
+	sort.SliceStable(workflows, func(i, j int) bool {
+		return workflows[i].CreateAt > workflows[j].CreateAt
+	})
+	start, end := pageRange(len(workflows), query.Index, query.Count)
+	return workflows[start:end], nil
+}
+
+func (r *memoryWorkflowRepository) FindAll(ctx context.Context) ([]Workflow, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	workflows := make([]Workflow, len(r.workflows))
+	for i := range r.workflows {
+		if err := cloneDocument(r.workflows[i], &workflows[i]); err != nil {
+			return workflows, err
+		}
+	}
+	return workflows, nil
+}
+
+func (r *memoryWorkflowRepository) FindOne(ctx context.Context, wid string) (Workflow, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var workflow Workflow
+	for _, w := range r.workflows {
+		if w.Wid == wid {
+			err := cloneDocument(w, &workflow)
+			return workflow, err
+		}
+	}
+	return workflow, NotFoundErr
+}
+
+func (r *memoryWorkflowRepository) Insert(ctx context.Context, w *Workflow) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var workflow Workflow
+	if err := cloneDocument(w, &workflow); err != nil {
+		return err
+	}
+	r.workflows = append(r.workflows, workflow)
+	return nil
+}
+
+func (r *memoryWorkflowRepository) Update(ctx context.Context, wid string, fields map[string]interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.workflows {
+		if r.workflows[i].Wid == wid {
+			return applyFields(&r.workflows[i], fields)
+		}
+	}
+	return NotFoundErr
+}
+
+func (r *memoryWorkflowRepository) Delete(ctx context.Context, wid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.workflows {
+		if r.workflows[i].Wid == wid {
+			r.workflows = append(r.workflows[:i], r.workflows[i+1:]...)
+			return nil
+		}
+	}
+	return NotFoundErr
+}
+
+// 工作流运行记录
+type memoryWorkflowRunRepository struct {
+	mu   sync.RWMutex
+	runs []WorkflowRun
+}
+
+func (r *memoryWorkflowRunRepository) Find(ctx context.Context, query *WorkflowRunQuery) ([]WorkflowRun, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	filter := GetWhereDb(query, []string{"rid"})
+	runs := make([]WorkflowRun, 0)
+	for _, run := range r.runs {
+		if matchDocument(toDocument(run), filter) {
+			var workflowRun WorkflowRun
+			if err := cloneDocument(run, &workflowRun); err != nil {
+				return runs, err
+			}
+			runs = append(runs, workflowRun)
+		}
+	}
+	query.Total = len(runs)
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].CreateAt > runs[j].CreateAt
+	})
+	start, end := pageRange(len(runs), query.Index, query.Count)
+	return runs[start:end], nil
+}
+
+func (r *memoryWorkflowRunRepository) Insert(ctx context.Context, run *WorkflowRun) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var workflowRun WorkflowRun
+	if err := cloneDocument(run, &workflowRun); err != nil {
+		return err
+	}
+	r.runs = append(r.runs, workflowRun)
+	return nil
+}
+
+func (r *memoryWorkflowRunRepository) Update(ctx context.Context, rid string, fields map[string]interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.runs {
+		if r.runs[i].Rid == rid {
+			return applyFields(&r.runs[i], fields)
+		}
+	}
+	return NotFoundErr
+}
+
+// 审计日志
+type memoryAuditRepository struct {
+	mu     sync.RWMutex
+	audits []AuditLog
+}
+
+func (r *memoryAuditRepository) Find(ctx context.Context, query *AuditQuery) ([]AuditLog, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	filter := auditWhereDb(query)
+	audits := make([]AuditLog, 0)
+	for _, a := range r.audits {
+		if matchDocument(toDocument(a), filter) {
+			var audit AuditLog
+			if err := cloneDocument(a, &audit); err != nil {
+				return audits, err
+			}
+			audits = append(audits, audit)
+		}
+	}
+	query.Total = len(audits)
+
+	sort.SliceStable(audits, func(i, j int) bool {
+		return audits[i].CreateAt > audits[j].CreateAt
+	})
+	start, end := pageRange(len(audits), query.Index, query.Count)
+	return audits[start:end], nil
+}
+
+func (r *memoryAuditRepository) Insert(ctx context.Context, a *AuditLog) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var audit AuditLog
+	if err := cloneDocument(a, &audit); err != nil {
+		return err
+	}
+	r.audits = append(r.audits, audit)
+	return nil
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+//misfireMaxRuns misfire 为 all 时最多补跑的次数
+const misfireMaxRuns = 1000
+
+//misfireMaxWalk 计算错过的调度时最多遍历的触发次数 防止调度器启动过慢
+const misfireMaxWalk = 100000
+
+//validate all 需要指定 max
+func (p MisfirePolicy) validate() error {
+	if p.Deadline < 0 {
+		return errors.New("misfire.deadline must not be negative")
+	}
+	switch p.Policy {
+	case "", MisfireSkip, MisfireOnce:
+		return nil
+	case MisfireAll:
+		if p.Max < 1 || p.Max > misfireMaxRuns {
+			return fmt.Errorf("misfire.max must be between 1 and %d when policy is all", misfireMaxRuns)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported misfire policy %s, must be skip, once or all", p.Policy)
+	}
+}
+
+//recordFire 开始执行的时候记录调度触发时间 用于计算错过的调度 只会往后更新
+func recordFire(t Task, fireAt time.Time) {
+	if fireAt.IsZero() || fireAt.Unix() <= t.LastFireAt {
+		return
+	}
+	if err := DB.Task().Update(context.Background(), t.Tid, map[string]interface{}{
+		"last_fire_at": fireAt.Unix(),
+	}); err != nil {
+		log.Errorf("[misfire] update task %s last_fire_at err: %v", t.Tid, err)
+	}
+}
+
+//missedFires 上一次触发(没有触发过为创建时间)到 now 之间错过的调度 按照时间顺序
+//只保留 misfire.deadline 之内的 once 保留最近的一次 all 保留最近的 max 次
+func missedFires(t Task, now time.Time) []time.Time {
+	if t.Disable || t.Delay {
+		return nil
+	}
+	limit := 1
+	switch t.Misfire.Policy {
+	case MisfireOnce:
+	case MisfireAll:
+		limit = t.Misfire.Max
+	default:
+		return nil
+	}
+
+	from := time.Unix(t.LastFireAt, 0)
+	if t.CreateAt > t.LastFireAt {
+		from = time.Unix(t.CreateAt, 0)
+	}
+	if t.Misfire.Deadline > 0 {
+		if d := now.Add(-time.Duration(t.Misfire.Deadline) * time.Second); d.After(from) {
+			from = d
+		}
+	}
+	schedule, err := cronParser.Parse(fmt.Sprintf("CRON_TZ=%s %s", t.Timezone, t.Expression))
+	if err != nil {
+		log.Errorf("[misfire] parse task %s expression err: %v", t.Tid, err)
+		return nil
+	}
+
+	// 从 now 往前成倍扩大窗口 直到窗口内的触发次数足够或者到达 from
+	// 只需要最近的 limit 次 不需要从很久之前开始逐个遍历 例如每秒执行并且很久没有触发过的任务
+	budget := misfireMaxWalk
+	for span := time.Second; ; span *= 2 {
+		start := now.Add(-span)
+		if !start.After(from) {
+			start = from
+		}
+		fires, n := lastFires(schedule, start, now, limit, budget)
+		if n >= budget {
+			log.Warnf("[misfire] 任务 %s 错过的调度过多 不补跑", t.Tid)
+			return nil
+		}
+		if n >= limit || start.Equal(from) {
+			return fires
+		}
+		budget -= n
+	}
+}
+
+//lastFires start 到 now 之间(不包括两端)最近的 limit 次触发 按照时间顺序 以及遍历的触发次数 最多遍历 budget 次
+func lastFires(schedule cron.Schedule, start, now time.Time, limit, budget int) ([]time.Time, int) {
+	ring := make([]time.Time, limit)
+	n := 0
+	for next := schedule.Next(start); !next.IsZero() && next.Before(now) && n < budget; next = schedule.Next(next) {
+		ring[n%limit] = next
+		n++
+	}
+	if n == 0 {
+		return nil, 0
+	}
+	if n <= limit {
+		return ring[:n], n
+	}
+	i := n % limit
+	return append(ring[i:], ring[:i]...), n
+}
+
+//catchUp 根据 misfire 策略在后台按照时间顺序补跑错过的调度
+//以错过的触发时间执行 多个 worker 同时补跑的时候同一次触发只执行一次
+func catchUp(t Task) {
+	fires := missedFires(t, time.Now())
+	if len(fires) == 0 {
+		return
+	}
+	log.Infof("[misfire] 任务 %s-%s 错过 %d 次调度 开始补跑", t.Tid, t.Name, len(fires))
+	catchingUp.Add(1)
+	go func() {
+		defer catchingUp.Done()
+		runMissed(t.Tid, fires)
+	}()
+}
+
+//catchingUp 正在后台补跑的任务 补跑会一直等待任务锁 所以停止调度器的时候不等待
+var catchingUp sync.WaitGroup
+
+//runMissed 按照时间顺序补跑 每一次都先等待任务锁再占用触发时间
+//没有分片的时候所有 worker 都会补跑 每一次触发只会被其中一个 worker 执行 不会丢失
+func runMissed(tid string, fires []time.Time) {
+	for _, fireAt := range fires {
+		if err := runTask(tid, fireAt, true); err != nil && err != FireTakenErr {
+			log.Warnf("[misfire] 补跑任务 %s 触发时间 %v 失败: %v", tid, fireAt, err)
+		}
+	}
+}
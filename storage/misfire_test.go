@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMissedFires(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	at := func(hour, min int) time.Time {
+		return time.Date(2020, 8, 17, hour, min, 0, 0, loc)
+	}
+	// 比较时间戳 解析器返回的时间使用自己加载的时区
+	unix := func(times []time.Time) []int64 {
+		var res []int64
+		for _, t := range times {
+			res = append(res, t.Unix())
+		}
+		return res
+	}
+	task := Task{Tid: "m", Expression: "0 * * * *", Timezone: "Asia/Shanghai",
+		CreateAt: at(8, 30).Unix(), LastFireAt: at(10, 0).Unix()}
+	now := at(15, 30)
+
+	assert.Nil(t, missedFires(task, now))
+	task.Misfire = MisfirePolicy{Policy: MisfireOnce}
+	assert.Equal(t, unix([]time.Time{at(15, 0)}), unix(missedFires(task, now)))
+	task.Misfire = MisfirePolicy{Policy: MisfireAll, Max: 3}
+	assert.Equal(t, unix([]time.Time{at(13, 0), at(14, 0), at(15, 0)}), unix(missedFires(task, now)))
+
+	// 只补跑 deadline 之内错过的
+	task.Misfire.Deadline = 7200
+	assert.Equal(t, unix([]time.Time{at(14, 0), at(15, 0)}), unix(missedFires(task, now)))
+
+	// 没有触发过的从创建时间开始计算
+	task.Misfire = MisfirePolicy{Policy: MisfireAll, Max: 10}
+	task.LastFireAt = 0
+	task.CreateAt = at(12, 30).Unix()
+	assert.Equal(t, unix([]time.Time{at(13, 0), at(14, 0), at(15, 0)}), unix(missedFires(task, now)))
+	assert.Nil(t, missedFires(task, at(12, 59)))
+
+	// 每秒执行并且很久没有触发过的任务只遍历最近的窗口
+	task.Expression = "* * * * * *"
+	task.CreateAt = at(15, 30).AddDate(-1, 0, 0).Unix()
+	task.Misfire = MisfirePolicy{Policy: MisfireAll, Max: 3}
+	start := time.Now()
+	assert.Equal(t, unix([]time.Time{now.Add(-3 * time.Second), now.Add(-2 * time.Second), now.Add(-time.Second)}),
+		unix(missedFires(task, now)))
+	assert.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+
+	task.Delay = true
+	assert.Nil(t, missedFires(task, now))
+
+	assert.Nil(t, MisfirePolicy{Policy: MisfireOnce}.validate())
+	assert.NotNil(t, MisfirePolicy{Policy: MisfireAll}.validate())
+	assert.NotNil(t, MisfirePolicy{Policy: MisfireAll, Max: misfireMaxRuns + 1}.validate())
+	assert.NotNil(t, MisfirePolicy{Policy: "latest"}.validate())
+	assert.NotNil(t, MisfirePolicy{Policy: MisfireOnce, Deadline: -1}.validate())
+}
+
+func TestCatchUp(t *testing.T) {
+	for name, newDb := range testDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			setTestStorage(t, newDb())
+			defer RevokeDb()
+			testCatchUp(t)
+		})
+	}
+}
+
+func testCatchUp(t *testing.T) {
+	ctx := context.Background()
+	task := Task{Name: "misfire", Expression: "0 * * * *", Type: BashTask, LogEnable: true,
+		Payload: map[string]interface{}{"command": "echo caught"},
+		Misfire: MisfirePolicy{Policy: MisfireOnce}}
+	assert.Nil(t, PostTask(&task))
+
+	// worker 下线期间错过了两次调度 启动之后补跑最近的一次
+	now := time.Now()
+	last := now.Truncate(time.Hour).Add(-2 * time.Hour)
+	assert.Nil(t, DB.Task().Update(ctx, task.Tid, map[string]interface{}{
+		"create_at":    now.Add(-5 * time.Hour).Unix(),
+		"last_fire_at": last.Unix(),
+	}))
+	assert.Nil(t, InitScheduler())
+	defer StopScheduler()
+
+	query := LogQuery{}
+	query.Tid = task.Tid
+	logs := waitLogs(t, query, 1)
+	assert.Len(t, logs, 1)
+	assert.Equal(t, RunSuccess, logs[0].Status)
+	assert.Eventually(t, func() bool {
+		got, err := GetTask(task.Tid)
+		return err == nil && got.LastFireAt == now.Truncate(time.Hour).Unix()
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// 修改任务不会补跑 也不能修改 last_fire_at
+	updated, err := UpdateTask(task.Tid, map[string]interface{}{"name": "misfire2", "last_fire_at": 0})
+	assert.Nil(t, err)
+	assert.Equal(t, now.Truncate(time.Hour).Unix(), updated.LastFireAt)
+	assert.Nil(t, NewSchedulerModifyTask(updated))
+
+	// 禁用期间错过的调度在重新启用的时候补跑 已经执行过的触发不会重复执行
+	assert.Nil(t, DB.Task().Update(ctx, task.Tid, map[string]interface{}{
+		"last_fire_at": last.Unix(),
+		"misfire":      MisfirePolicy{Policy: MisfireAll, Max: 2},
+	}))
+	updated.Disable = true
+	assert.Nil(t, NewSchedulerDisableTask(updated))
+	updated, err = GetTask(task.Tid)
+	assert.Nil(t, err)
+	assert.Nil(t, NewSchedulerDisableTask(updated))
+	logs = waitLogs(t, query, 2)
+	assert.Len(t, logs, 2)
+	for _, l := range logs {
+		assert.Equal(t, RunSuccess, l.Status)
+	}
+}
+
+func TestRunMissedOnTwoWorkers(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+	task := newBashTask(t, "date", true)
+	now := time.Now().Truncate(time.Second)
+	fires := []time.Time{now.Add(-3 * time.Second), now.Add(-2 * time.Second), now.Add(-time.Second)}
+
+	// 两个 worker 共享同一个 cache 同时补跑 每一次错过的调度都只执行一次
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runMissed(task.Tid, fires)
+		}()
+	}
+	wg.Wait()
+	pendingLogs.Wait()
+
+	query := LogQuery{}
+	query.Tid = task.Tid
+	logs, err := GetLogs(&query)
+	assert.Nil(t, err)
+	assert.Len(t, logs, len(fires))
+	for _, l := range logs {
+		assert.Equal(t, RunSuccess, l.Status)
+	}
+}
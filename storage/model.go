@@ -1,10 +1,12 @@
 package storage
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -33,38 +35,146 @@ const (
 	HTTPTask = "http"
 )
 
+// 运行状态
+const (
+	RunSuccess   = "success"
+	RunFailed    = "failed"    // 执行失败或者没有成功启动
+	RunTimeout   = "timeout"   // 超时被 kill
+	RunSkipped   = "skipped"   // 锁被其他 worker 持有 等待下次调度
+	RunCancelled = "cancelled" // 被取消
+	RunRunning   = "running"   // 正在运行 开启 stream_output 的任务以及工作流
+)
+
+// 工作流上下游的触发条件
+const (
+	TriggerOnSuccess = "on_success" // 上游成功 默认
+	TriggerOnFailure = "on_failure" // 上游失败或者超时
+	TriggerAlways    = "always"     // 不管上游的运行结果
+)
+
+// 任务的并发策略 在所有 worker 之间生效
+const (
+	ConcurrencyForbid  = "forbid"  // 上一次运行没有结束的时候跳过 默认
+	ConcurrencyAllow   = "allow"   // 最多 max 个实例同时运行
+	ConcurrencyReplace = "replace" // 取消正在运行的实例之后再运行
+)
+
+// 错过调度之后的补跑策略 在调度器启动以及任务重新启用的时候生效
+const (
+	MisfireSkip = "skip" // 不补跑 默认
+	MisfireOnce = "once" // 只补跑最近的一次
+	MisfireAll  = "all"  // 按照时间顺序补跑最近的 max 次
+)
+
+// worker 状态
+const (
+	WorkerAlive = "alive"
+	WorkerDead  = "dead" // 超过 cluster.ttl 没有心跳 保留到 cluster.retain 之后删除
+)
+
+// 事件对应的实体类型
+const (
+	TaskKind     = "" // 兼容之前没有 kind 的事件
+	WorkflowKind = "workflow"
+)
+
+// 重试的失败类型
+const (
+	RetryOnFailed  = "failed"  // 所有 failed 的运行
+	RetryOnTimeout = "timeout" // 超时
+	RetryOn5xx     = "5xx"     // http 任务返回 5xx 或者请求没有发送成功
+)
+
 var (
 	WaitForNextScheduleErr = errors.New("key 存在或者 value 设置不成功，等待下次调度")
+	FireTakenErr           = errors.New("本次调度已经由其他 worker 执行")
 	RunTaskNotFoundTaskErr = errors.New("没有在数据库中找到对应 task")
+	NotFoundErr            = errors.New("没有找到对应的数据")
 	TimezoneNotFoundErr    = func(name string) error {
 		return errors.New(fmt.Sprintf(" %s 此时区不在支持的时区范围内", name))
 	}
-	TimezoneIsExistsErr = errors.New("此时区已存在数据库中")
+	TimezoneIsExistsErr   = errors.New("此时区已存在数据库中")
+	MemoryCacheClosedErr  = errors.New("memory cache 已经关闭")
+	DelayTaskCompletedErr = errors.New("延迟作业已经执行完成")
+	WorkflowNotFoundErr   = errors.New("没有在数据库中找到对应 workflow")
+	WorkflowNoNodeErr     = errors.New("工作流至少需要一个节点")
+	WorkflowCycleErr      = errors.New("工作流的依赖关系存在环")
+	WorkflowSelectorErr   = errors.New("工作流节点在工作流所在的 worker 上执行 不能设置 selector")
+	AppUnavailableErr     = errors.New("app 不存在或者已经被删除")
+	AppNoSecretErr        = errors.New("app 没有 secret_key 需要重新生成密钥")
+	AuthFailedErr         = errors.New("认证失败")
+	PermissionDeniedErr   = errors.New("没有权限")
+	SelectorMismatchErr   = errors.New("当前 worker 的标签不满足任务的 selector")
+	WorkerBusyErr         = errors.New("worker 正在执行的任务达到 capacity 并且等待队列已满")
 )
 
 type (
 
 	// 当前任务
 	// payload 请求体
-	// 如果 type 是 bash，则 payload 需要有 command  TODO: 需要进行高危命令检测
-	// 如果是 http，则需要有 url，method，以及 data, appKey, secretKey
+	// 如果 type 是 bash，则 payload 需要有 command 新增以及修改的时候进行高危命令检测
+	// 如果是 http，则需要有 endpoint，method，以及 prefix, data 分别对应 BashTaskPayload 和 HTTPTaskPayload
 	Task struct {
-		Id         primitive.ObjectID     `json:"-" bson:"_id,omitempty"`       // mongo object id  omitempty ,之后不能有空格
-		Tid        string                 `json:"tid" bson:"tid"`               // task id -> Id.Hex()
-		Name       string                 `json:"name" bson:"name"`             // task 名字 TODO: 唯一索引
-		Disable    bool                   `json:"disable" bson:"disable"`       // 是否禁用当前任务
-		TimeOut    int                    `json:"timeout" bson:"timeout"`       // 超时时间
-		CreateAt   int64                  `json:"create_at" bson:"create_at"`   // 创建时间
-		UpdateAt   int64                  `json:"update_at" bson:"update_at"`   // 修改时间
-		LogEnable  bool                   `json:"log_enable" bson:"log_enable"` // 是否启用日志
-		Expression string                 `json:"expression" bson:"expression"` // 表达式 支持@every [1s | 1m | 1h ] 参考 cron
-		Delay      bool                   `json:"delay" bson:"delay"`           // 是否是延迟作业
-		Timezone   string                 `json:"timezone" bson:"timezone"`     // 新增时区配置
-		Payload    map[string]interface{} `json:"payload" bson:"payload"`
-		Type       string                 `json:"type" bson:"type"` // 目前支持两种类型 bash 和 http
-	}
-
-	// 任务日志
+		Id          primitive.ObjectID     `json:"-" bson:"_id,omitempty"`       // mongo object id  omitempty ,之后不能有空格
+		Tid         string                 `json:"tid" bson:"tid"`               // task id -> Id.Hex()
+		Name        string                 `json:"name" bson:"name"`             // task 名字 TODO: 唯一索引
+		Disable     bool                   `json:"disable" bson:"disable"`       // 是否禁用当前任务
+		TimeOut     int                    `json:"timeout" bson:"timeout"`       // 超时时间
+		CreateAt    int64                  `json:"create_at" bson:"create_at"`   // 创建时间
+		UpdateAt    int64                  `json:"update_at" bson:"update_at"`   // 修改时间
+		LogEnable   bool                   `json:"log_enable" bson:"log_enable"` // 是否启用日志
+		Expression  string                 `json:"expression" bson:"expression"` // 表达式 支持@every [1s | 1m | 1h ] 参考 cron
+		Delay       bool                   `json:"delay" bson:"delay"`           // 是否是延迟作业 只在 run_at 执行一次 不需要 expression
+		Timezone    string                 `json:"timezone" bson:"timezone"`     // 新增时区配置
+		Payload     map[string]interface{} `json:"payload" bson:"payload"`
+		Type        string                 `json:"type" bson:"type"`                 // 目前支持两种类型 bash 和 http
+		Retry       RetryPolicy            `json:"retry" bson:"retry"`               // 失败重试策略
+		RunAt       int64                  `json:"run_at" bson:"run_at"`             // 延迟作业的执行时间
+		After       string                 `json:"after,omitempty" bson:"-"`         // 延迟作业多久之后执行 例如 15m 新增的时候转为 run_at
+		Completed   bool                   `json:"completed" bson:"completed"`       // 延迟作业是否已经执行
+		Aid         string                 `json:"aid" bson:"aid"`                   // 所属 app 为空表示只有 root 可以管理
+		CreatedBy   string                 `json:"created_by" bson:"created_by"`     // 创建任务的 app root 为 root
+		UpdatedBy   string                 `json:"updated_by" bson:"updated_by"`     // 最近一次修改任务的 app
+		Force       bool                   `json:"force,omitempty" bson:"-"`         // 跳过高危命令检测 只有 admin 可以使用 不存储
+		Selector    TaskSelector           `json:"selector" bson:"selector"`         // 根据 worker 的标签选择调度的 worker
+		Concurrency ConcurrencyPolicy      `json:"concurrency" bson:"concurrency"`   // 并发策略
+		Misfire     MisfirePolicy          `json:"misfire" bson:"misfire"`           // 错过调度之后的补跑策略
+		LastFireAt  int64                  `json:"last_fire_at" bson:"last_fire_at"` // 最近一次开始执行的调度触发时间 手动执行不记录
+		// 每个输出流最多保存的字节数 超过之后保留头尾 小于等于 0 使用 output.limit 配置
+		OutputLimit  int   `json:"output_limit" bson:"output_limit"`
+		StreamOutput bool  `json:"stream_output" bson:"stream_output"` // 运行过程中定时将输出写入运行记录 需要开启 log_enable
+		NextRunAt    int64 `json:"next_run_at" bson:"-"`               // 下一次执行时间 查询的时候计算 不存储
+	}
+
+	// 失败重试策略 重试期间一直持有分布式锁
+	RetryPolicy struct {
+		MaxAttempts    int      `json:"max_attempts" bson:"max_attempts"`       // 最多执行次数(包括第一次) 小于等于 1 不重试
+		InitialBackoff int      `json:"initial_backoff" bson:"initial_backoff"` // 第一次重试前等待时间 ms 默认 1000
+		Multiplier     float64  `json:"multiplier" bson:"multiplier"`           // 每次重试等待时间的倍数 默认 2
+		MaxBackoff     int      `json:"max_backoff" bson:"max_backoff"`         // 最长等待时间 ms 为 0 不限制
+		RetryOn        []string `json:"retry_on" bson:"retry_on"`               // 需要重试的失败类型 failed timeout 5xx 默认 failed 和 timeout
+	}
+
+	// 任务的 worker 标签选择器 标签来自 worker.labels 配置
+	TaskSelector struct {
+		Required  map[string]string `json:"required" bson:"required"`   // 必须全部匹配 只有匹配的 worker 可以调度以及执行
+		Preferred map[string]string `json:"preferred" bson:"preferred"` // 优先选择匹配最多的 worker 没有匹配的时候不限制
+	}
+
+	// 错过调度之后的补跑策略 skip once all 为空的时候为 skip
+	MisfirePolicy struct {
+		Policy   string `json:"policy" bson:"policy"`     // skip once all
+		Max      int    `json:"max" bson:"max"`           // all 时最多补跑的次数
+		Deadline int64  `json:"deadline" bson:"deadline"` // 只补跑最近多少秒之内错过的调度 0 不限制
+	}
+
+	// 任务的并发策略 forbid allow replace 为空的时候为 forbid
+	ConcurrencyPolicy struct {
+		Policy string `json:"policy" bson:"policy"` // forbid allow replace
+		Max    int    `json:"max" bson:"max"`       // allow 时最多同时运行的实例数
+	}
+
+	// 任务日志 每一次运行都会记录一条
 	TaskLog struct {
 		Id       primitive.ObjectID `json:"-" bson:"_id,omitempty"`     // mongo object id
 		Lid      string             `json:"lid"  bson:"lid"`            // 主键Key
@@ -74,6 +184,15 @@ type (
 		StartAt  int64              `json:"start_at" bson:"start_at"`   // 任务开始时间
 		EndAt    int64              `json:"end_at" bson:"end_at"`       // 任务结束时间
 		CreateAt int64              `json:"create_at" bson:"create_at"` // 创建时间
+		Status   string             `json:"status" bson:"status"`       // 运行状态 success failed timeout skipped cancelled
+		Code     int                `json:"code" bson:"code"`           // bash 退出码或者 http 状态码 没有成功启动为 -1
+		Worker   string             `json:"worker" bson:"worker"`       // 执行的 worker
+		Duration int64              `json:"duration" bson:"duration"`   // 耗时 ms
+		Attempt  int                `json:"attempt" bson:"attempt"`     // 第几次执行 从 1 开始
+
+		start    time.Time       // 精确的开始时间 用于计算耗时
+		streamed bool            // 运行开始的时候已经写入 结束的时候需要更新而不是新增
+		cancel   <-chan struct{} // 并发策略为 replace 的时候被新的实例取消
 	}
 
 	// 支持的时区列表选项
@@ -86,6 +205,43 @@ type (
 		UpdateAt int64              `json:"update_at" bson:"update_at"`
 	}
 
+	// 工作流 节点为已经存在的任务 通过 edges 描述上下游依赖
+	// 节点任务由工作流触发执行 不需要跟随工作流的任务可以设置 disable
+	Workflow struct {
+		Id         primitive.ObjectID `json:"-" bson:"_id,omitempty"`       // mongo object id
+		Wid        string             `json:"wid" bson:"wid"`               // workflow id -> Id.Hex()
+		Name       string             `json:"name" bson:"name"`             // 工作流名字
+		Disable    bool               `json:"disable" bson:"disable"`       // 是否禁用当前工作流
+		Expression string             `json:"expression" bson:"expression"` // 表达式 同 task
+		Timezone   string             `json:"timezone" bson:"timezone"`     // 时区
+		Nodes      []string           `json:"nodes" bson:"nodes"`           // 节点 task id
+		Edges      []WorkflowEdge     `json:"edges" bson:"edges"`           // 上下游依赖
+		CreateAt   int64              `json:"create_at" bson:"create_at"`   // 创建时间
+		UpdateAt   int64              `json:"update_at" bson:"update_at"`   // 修改时间
+		Aid        string             `json:"aid" bson:"aid"`               // 所属 app 节点只能是同一个 app 的任务
+	}
+
+	// 工作流的一条边 上游 from 的运行结果满足 trigger 时 下游 to 才会执行
+	WorkflowEdge struct {
+		From    string `json:"from" bson:"from"`       // 上游 task id
+		To      string `json:"to" bson:"to"`           // 下游 task id
+		Trigger string `json:"trigger" bson:"trigger"` // on_success on_failure always 默认 on_success
+	}
+
+	// 工作流运行记录 每一次运行都会记录一条
+	WorkflowRun struct {
+		Id       primitive.ObjectID `json:"-" bson:"_id,omitempty"`     // mongo object id
+		Rid      string             `json:"rid" bson:"rid"`             // run id
+		Wid      string             `json:"wid" bson:"wid"`             // workflow id
+		Status   string             `json:"status" bson:"status"`       // running success failed skipped
+		Nodes    map[string]string  `json:"nodes" bson:"nodes"`         // 各个节点的运行状态 task id -> status
+		Worker   string             `json:"worker" bson:"worker"`       // 执行的 worker
+		StartAt  int64              `json:"start_at" bson:"start_at"`   // 开始时间
+		EndAt    int64              `json:"end_at" bson:"end_at"`       // 结束时间
+		Duration int64              `json:"duration" bson:"duration"`   // 耗时 ms
+		CreateAt int64              `json:"create_at" bson:"create_at"` // 创建时间
+	}
+
 	App struct {
 		Id          primitive.ObjectID `json:"-" bson:"_id,omitempty"`           // mongo object id
 		Aid         string             `json:"aid" bson:"aid"`                   // app id
@@ -94,11 +250,41 @@ type (
 		SecretKey   string             `json:"secret_key" bson:"secret_key"`
 		AppKey      string             `json:"app_key" bson:"app_key"`
 		AppName     string             `json:"app_name" bson:"app_name"`   // unique
+		Role        string             `json:"role" bson:"role"`           // admin operator viewer 默认 operator
 		LastUsed    int64              `json:"last_used" bson:"last_used"` // 最近一次使用时间 TODO: echo middleware
 		Counter     int64              `json:"counter" bson:"counter"`     // 调用的次数
 		CreateAt    int64              `json:"create_at" bson:"create_at"`
 		UpdateAt    int64              `json:"update_at" bson:"update_at"`
 	}
+
+	// 审计日志 只追加 记录任务的每一次变更以及手动执行
+	AuditLog struct {
+		Id       primitive.ObjectID     `json:"-" bson:"_id,omitempty"`     // mongo object id
+		Lid      string                 `json:"lid" bson:"lid"`             // 主键
+		Tid      string                 `json:"tid" bson:"tid"`             // task id
+		Aid      string                 `json:"aid" bson:"aid"`             // 任务所属 app
+		Actor    string                 `json:"actor" bson:"actor"`         // 操作的 app 同 created_by
+		Action   string                 `json:"action" bson:"action"`       // create modify disable delete run
+		Diff     map[string]AuditChange `json:"diff" bson:"diff"`           // 变更的字段 key 为 json tag
+		Ip       string                 `json:"ip" bson:"ip"`               // 来源 ip
+		CreateAt int64                  `json:"create_at" bson:"create_at"` // 操作时间
+	}
+
+	// 某个字段变更前后 json 编码的值 新增时 before 为空 删除时 after 为空
+	AuditChange struct {
+		Before json.RawMessage `json:"before" bson:"before"`
+		After  json.RawMessage `json:"after" bson:"after"`
+	}
+)
+
+// 审计日志的操作类型
+const (
+	AuditCreate  = "create"
+	AuditModify  = "modify"
+	AuditDisable = "disable"
+	AuditEnable  = "enable"
+	AuditDelete  = "delete"
+	AuditRun     = "run" // 手动执行
 )
 
 type (
@@ -121,6 +307,21 @@ type (
 		Page
 		TaskLog
 	}
+
+	WorkflowQuery struct {
+		Page
+		Workflow
+	}
+
+	WorkflowRunQuery struct {
+		Page
+		WorkflowRun
+	}
+
+	AuditQuery struct {
+		Page
+		AuditLog
+	}
 )
 
 // 应用所需实体
@@ -146,7 +347,31 @@ type (
 	// 不过这样删除 task 的时候，worker 可能会找不到数据，因为在 master 中这条数据已经被操作删除了 所以需要进行一下处理
 	TaskEvent struct {
 		Event int    `json:"event"`
-		Tid   string `json:"tid"`
+		Tid   string `json:"tid"`  // task id 或者 workflow id
+		Kind  string `json:"kind"` // 为空是任务 workflow 是工作流
+	}
+
+	// worker 通过 cache 的 <lease.prefix>:worker:<id> 注册 超过 cluster.ttl 没有心跳视为下线
+	Worker struct {
+		Id        string            `json:"id"`        // hostname:pid
+		Hostname  string            `json:"hostname"`  // 主机名
+		Version   string            `json:"version"`   // 构建的 git commit hash
+		Labels    map[string]string `json:"labels"`    // worker.labels 配置
+		Capacity  int               `json:"capacity"`  // 最多同时执行的任务数 worker.capacity 配置 0 不限制
+		Running   []string          `json:"running"`   // 正在执行的任务 tid
+		Queued    int               `json:"queued"`    // 等待 capacity 的任务数
+		StartAt   int64             `json:"start_at"`  // 启动时间
+		Heartbeat int64             `json:"heartbeat"` // 最近一次心跳时间
+		Status    string            `json:"status"`    // alive dead 查询的时候根据心跳计算
+	}
+
+	// 实时日志 worker 通过 cache 的 <pubsub.channel>:log:<tid> 频道转发给 master
+	LogLine struct {
+		Lid    string `json:"lid"`              // 运行记录 id
+		Stream string `json:"stream,omitempty"` // stdout stderr
+		Line   string `json:"line,omitempty"`   // 一行输出
+		Status string `json:"status,omitempty"` // 运行结束时的状态 之后不会再有输出
+		Code   int    `json:"code"`             // 运行结束时的退出码或者状态码
 	}
 
 	// BashTask
@@ -156,10 +381,39 @@ type (
 
 	// HTTPTask
 	HTTPTaskPayload struct {
-		EndPoint string      `json:"endpoint"`
-		Prefix   string      `json:"prefix"`
-		Method   string      `json:"method"`
-		Data     interface{} `json:"data"`
+		Aid      string            `json:"aid,omitempty"` // 使用对应 app 的 app_key secret_key 对请求签名
+		EndPoint string            `json:"endpoint"`
+		Prefix   string            `json:"prefix"`
+		Method   string            `json:"method"` // GET POST PUT PATCH DELETE HEAD
+		Data     interface{}       `json:"data"`   // json 请求体 会加入 delay 字段
+		Headers  map[string]string `json:"headers,omitempty"`
+		Query    map[string]string `json:"query,omitempty"`
+		Body     string            `json:"body,omitempty"` // 请求体模板 设置之后替代 data
+		Auth     *HTTPAuth         `json:"auth,omitempty"`
+		TLS      *HTTPTLS          `json:"tls,omitempty"`
+		Success  *HTTPSuccess      `json:"success,omitempty"`
+	}
+
+	// http 任务认证
+	HTTPAuth struct {
+		Type     string `json:"type"` // basic bearer
+		Username string `json:"username,omitempty"`
+		Password string `json:"password,omitempty"`
+		Token    string `json:"token,omitempty"`
+	}
+
+	// http 任务 TLS 配置
+	HTTPTLS struct {
+		CA         string `json:"ca,omitempty"` // PEM 格式的 CA 证书
+		SkipVerify bool   `json:"skip_verify,omitempty"`
+	}
+
+	// http 任务成功条件 都不设置的时候状态码小于 400 即成功
+	HTTPSuccess struct {
+		StatusCodes []int  `json:"status_codes,omitempty"` // 允许的状态码
+		BodyRegex   string `json:"body_regex,omitempty"`   // 响应体需要匹配的正则
+		JSONPath    string `json:"json_path,omitempty"`    // 响应体中需要存在的字段 例如 $.data.items[0].status
+		JSONValue   string `json:"json_value,omitempty"`   // json_path 对应的值 不设置只校验存在
 	}
 )
 
@@ -176,8 +430,8 @@ func inCondition(s []string, e string) bool {
 //GetWhereDb 进行条件预筛选
 func GetWhereDb(object interface{}, filter []string) bson.D {
 	var doc bson.D
-	// 过滤 bool 和子类型为 struct 内容
-	filterKind := []string{"bool", "struct"}
+	// 过滤 bool、子类型为 struct 以及 chan 内容
+	filterKind := []string{"bool", "struct", "chan"}
 	// 过滤 Page 参数体
 	filterStruct := []string{"Page"}
 
@@ -0,0 +1,587 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//MongoDatabase mongo 存储实现
+type MongoDatabase struct {
+	Client *mongo.Client
+	Cron   *mongo.Database
+
+	task     *mongoTaskRepository
+	taskLog  *mongoTaskLogRepository
+	timezone *mongoTimezoneRepository
+	app      *mongoAppRepository
+	workflow *mongoWorkflowRepository
+	run      *mongoWorkflowRunRepository
+	audit    *mongoAuditRepository
+}
+
+//NewMongoDatabase 连接 mongo 并初始化对应集合
+func NewMongoDatabase(conn, db string) (*MongoDatabase, error) {
+	opts := options.Client().ApplyURI(conn)
+	client, err := mongo.Connect(context.Background(), opts)
+	if err != nil {
+		log.Errorf("[db] mongo connect err: %v", err)
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx, nil); err != nil {
+		log.Errorf("[db] mongo ping err: %v", err)
+		return nil, err
+	}
+	log.Debugf("[db] mongo ping success")
+	cron := client.Database(db)
+
+	return &MongoDatabase{
+		Client:   client,
+		Cron:     cron,
+		task:     &mongoTaskRepository{col: cron.Collection("task")},
+		taskLog:  &mongoTaskLogRepository{col: cron.Collection("task_log")},
+		timezone: &mongoTimezoneRepository{col: cron.Collection("timezone")},
+		app:      &mongoAppRepository{col: cron.Collection("app")},
+		workflow: &mongoWorkflowRepository{col: cron.Collection("workflow")},
+		run:      &mongoWorkflowRunRepository{col: cron.Collection("workflow_run")},
+		audit:    &mongoAuditRepository{col: cron.Collection("audit_log")},
+	}, nil
+}
+
+func (m *MongoDatabase) Task() TaskRepository {
+	return m.task
+}
+
+func (m *MongoDatabase) TaskLog() TaskLogRepository {
+	return m.taskLog
+}
+
+func (m *MongoDatabase) Timezone() TimezoneRepository {
+	return m.timezone
+}
+
+func (m *MongoDatabase) App() AppRepository {
+	return m.app
+}
+
+func (m *MongoDatabase) Workflow() WorkflowRepository {
+	return m.workflow
+}
+
+func (m *MongoDatabase) WorkflowRun() WorkflowRunRepository {
+	return m.run
+}
+
+func (m *MongoDatabase) Audit() AuditRepository {
+	return m.audit
+}
+
+func (m *MongoDatabase) Ping(ctx context.Context) error {
+	return m.Client.Ping(ctx, nil)
+}
+
+func (m *MongoDatabase) Close(ctx context.Context) error {
+	return m.Client.Disconnect(ctx)
+}
+
+// 任务集合
+type mongoTaskRepository struct {
+	col *mongo.Collection
+}
+
+func (r *mongoTaskRepository) Find(ctx context.Context, query *TaskQuery) ([]Task, error) {
+	tasks := make([]Task, 0)
+
+	queryDB := GetWhereDb(query, nil)
+	count, err := r.col.CountDocuments(ctx, queryDB.Map())
+	if err != nil {
+		return tasks, errors.Wrap(err, "failed to get the page total of tasks")
+	}
+	query.Total = int(count)
+
+	opts := options.Find()
+
+	if query.Order != "" {
+		opts = opts.SetSort(bson.D{{Key: query.Order, Value: DESC}}) // TODO: 默认 desc
+	}
+	opts = opts.SetSkip(int64((query.Index - 1) * query.Count)).SetLimit(int64(query.Count))
+
+	cursor, err := r.col.Find(ctx, queryDB.Map(), opts)
+	if err != nil {
+		return tasks, errors.Wrap(err, "get tasks err")
+	}
+	if err = cursor.All(ctx, &tasks); err != nil {
+		return tasks, errors.Wrap(err, "decode all tasks err")
+	}
+
+	return tasks, nil
+}
+
+func (r *mongoTaskRepository) FindAll(ctx context.Context) ([]Task, error) {
+	tasks := make([]Task, 0)
+	cursor, err := r.col.Find(ctx, bson.M{})
+	if err != nil {
+		return tasks, errors.Wrap(err, "get all tasks err")
+	}
+	if err = cursor.All(ctx, &tasks); err != nil {
+		return tasks, errors.Wrap(err, "decode all tasks err")
+	}
+	return tasks, nil
+}
+
+func (r *mongoTaskRepository) FindOne(ctx context.Context, tid string) (Task, error) {
+	var t Task
+
+	oid, err := primitive.ObjectIDFromHex(tid)
+	if err != nil {
+		return t, err
+	}
+
+	if err = r.col.FindOne(ctx, bson.M{"_id": oid}).Decode(&t); err != nil {
+		return t, errors.Wrap(err, fmt.Sprintf("decode task %s err", tid))
+	}
+	return t, nil
+}
+
+func (r *mongoTaskRepository) Insert(ctx context.Context, t *Task) error {
+	res, err := r.col.InsertOne(ctx, t)
+	if err != nil {
+		return errors.Wrap(err, "insert task err")
+	}
+	log.Debugf("[model] insert id is: %v", res.InsertedID)
+	return nil
+}
+
+func (r *mongoTaskRepository) Update(ctx context.Context, tid string, fields map[string]interface{}) error {
+	oid, err := primitive.ObjectIDFromHex(tid)
+	if err != nil {
+		return err
+	}
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": fields})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("update task %s err", tid))
+	}
+	log.Debugf("[model] match count: %v, modify count: %v", res.MatchedCount, res.ModifiedCount)
+	return nil
+}
+
+func (r *mongoTaskRepository) Delete(ctx context.Context, tid string) error {
+	oid, err := primitive.ObjectIDFromHex(tid)
+	if err != nil {
+		return err
+	}
+	res, err := r.col.DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("delete task %s err", tid))
+	}
+	log.Debugf("[model] delete count: %d", res.DeletedCount)
+	return nil
+}
+
+// 任务日志集合
+type mongoTaskLogRepository struct {
+	col *mongo.Collection
+}
+
+//logWhereDb 日志查询条件
+func logWhereDb(query *LogQuery) bson.D {
+	queryDB := GetWhereDb(query, []string{"lid"})
+	if query.LeftTs > 0 {
+		queryDB = append(queryDB, bson.E{Key: "create_at", Value: bson.D{{Key: "$gt", Value: query.LeftTs}}})
+	}
+
+	if query.RightTs > 0 {
+		queryDB = append(queryDB, bson.E{Key: "create_at", Value: bson.D{{Key: "$lt", Value: query.RightTs}}})
+	}
+	return queryDB
+}
+
+func (r *mongoTaskLogRepository) Find(ctx context.Context, query *LogQuery) ([]TaskLog, error) {
+	logs := make([]TaskLog, 0)
+
+	queryDB := logWhereDb(query)
+	count, err := r.col.CountDocuments(ctx, queryDB.Map())
+	if err != nil {
+		return logs, errors.Wrap(err, "failed to get the page total of logs")
+	}
+	query.Total = int(count)
+
+	opts := options.Find()
+
+	opts = opts.
+		SetSort(bson.D{{Key: "create_at", Value: DESC}}).
+		SetSkip(int64((query.Index - 1) * query.Count)).
+		SetLimit(int64(query.Count))
+
+	cursor, err := r.col.Find(ctx, queryDB.Map(), opts)
+	if err != nil {
+		return logs, errors.Wrap(err, "get logs err")
+	}
+	if err = cursor.All(ctx, &logs); err != nil {
+		return logs, errors.Wrap(err, "decode all logs err")
+	}
+
+	return logs, nil
+}
+
+func (r *mongoTaskLogRepository) FindOne(ctx context.Context, lid string) (TaskLog, error) {
+	var l TaskLog
+	if err := r.col.FindOne(ctx, bson.M{"lid": lid}).Decode(&l); err != nil {
+		return l, errors.Wrap(err, fmt.Sprintf("decode log %s err", lid))
+	}
+	return l, nil
+}
+
+func (r *mongoTaskLogRepository) Insert(ctx context.Context, l *TaskLog) error {
+	// TODO: MDB Batch
+	res, err := r.col.InsertOne(ctx, l)
+	if err != nil {
+		return errors.Wrap(err, "insert log err")
+	}
+	log.Debugf("[ostool] insert id: %v", res.InsertedID)
+	return nil
+}
+
+func (r *mongoTaskLogRepository) Update(ctx context.Context, lid string, fields map[string]interface{}) error {
+	res, err := r.col.UpdateOne(ctx, bson.M{"lid": lid}, bson.M{"$set": fields})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("update log %s err", lid))
+	}
+	log.Debugf("[model] match count: %v, modify count: %v", res.MatchedCount, res.ModifiedCount)
+	return nil
+}
+
+func (r *mongoTaskLogRepository) Delete(ctx context.Context, query *LogQuery) (int64, error) {
+	res, err := r.col.DeleteMany(ctx, logWhereDb(query))
+	if err != nil {
+		return 0, errors.Wrap(err, "delete logs err")
+	}
+	return res.DeletedCount, nil
+}
+
+// 时区集合
+type mongoTimezoneRepository struct {
+	col *mongo.Collection
+}
+
+func (r *mongoTimezoneRepository) FindAll(ctx context.Context) ([]Timezone, error) {
+	timezones := make([]Timezone, 0)
+	opts := options.Find()
+	opts.SetSort(bson.D{{Key: "label", Value: DESC}})
+	cursor, err := r.col.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return timezones, errors.Wrap(err, "get timezone err")
+	}
+	if err = cursor.All(ctx, &timezones); err != nil {
+		return timezones, err
+	}
+	return timezones, nil
+}
+
+func (r *mongoTimezoneRepository) FindOne(ctx context.Context, tid string) (Timezone, error) {
+	var t Timezone
+
+	oid, err := primitive.ObjectIDFromHex(tid)
+	if err != nil {
+		return t, errors.Wrap(err, fmt.Sprintf("[model] get timezone %s err", tid))
+	}
+
+	if err = r.col.FindOne(ctx, bson.M{"_id": oid}).Decode(&t); err != nil {
+		return t, errors.Wrap(err, fmt.Sprintf("[model] decode timezone %s err", tid))
+	}
+	return t, nil
+}
+
+func (r *mongoTimezoneRepository) Insert(ctx context.Context, t *Timezone) error {
+	res, err := r.col.InsertOne(ctx, t)
+	if err != nil {
+		return errors.Wrap(err, "[model] insert timezone err")
+	}
+	log.Debugf("[model] insert id is: %v", res.InsertedID)
+	return nil
+}
+
+func (r *mongoTimezoneRepository) Delete(ctx context.Context, tid string) error {
+	oid, err := primitive.ObjectIDFromHex(tid)
+	if err != nil {
+		return err
+	}
+	res, err := r.col.DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("[model] delete timezone %s err", tid))
+	}
+	log.Debugf("[model] delete count: %d", res.DeletedCount)
+	return nil
+}
+
+// 应用集合
+type mongoAppRepository struct {
+	col *mongo.Collection
+}
+
+func (r *mongoAppRepository) FindAll(ctx context.Context) ([]App, error) {
+	apps := make([]App, 0)
+	opts := options.Find()
+	opts.SetSort(bson.D{{Key: "create_at", Value: DESC}})
+	// filter appKey and secretKey
+	opts.SetProjection(bson.M{
+		"app_key":    0,
+		"secret_key": 0,
+	})
+	cursor, err := r.col.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return apps, errors.Wrap(err, "get app err")
+	}
+	if err = cursor.All(ctx, &apps); err != nil {
+		return apps, err
+	}
+	return apps, nil
+}
+
+func (r *mongoAppRepository) FindOne(ctx context.Context, aid string) (App, error) {
+	var a App
+
+	oid, err := primitive.ObjectIDFromHex(aid)
+	if err != nil {
+		return a, errors.Wrap(err, fmt.Sprintf("获取 app %s 失败", aid))
+	}
+
+	if err = r.col.FindOne(ctx, bson.M{"_id": oid}).Decode(&a); err != nil {
+		return a, errors.Wrap(err, fmt.Sprintf("decode app err: %v", err))
+	}
+	return a, nil
+}
+
+func (r *mongoAppRepository) FindByAppKey(ctx context.Context, appKey string) (App, error) {
+	var a App
+	if err := r.col.FindOne(ctx, bson.M{"app_key": appKey}).Decode(&a); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return a, NotFoundErr
+		}
+		return a, errors.Wrap(err, "decode app err")
+	}
+	return a, nil
+}
+
+func (r *mongoAppRepository) Insert(ctx context.Context, a *App) error {
+	res, err := r.col.InsertOne(ctx, a)
+	if err != nil {
+		return errors.Wrap(err, "新增 app 失败")
+	}
+	log.Debugf("[model] insert id is: %v", res.InsertedID)
+	return nil
+}
+
+func (r *mongoAppRepository) Update(ctx context.Context, aid string, fields map[string]interface{}) error {
+	oid, err := primitive.ObjectIDFromHex(aid)
+	if err != nil {
+		return err
+	}
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": fields})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("更新 app %s 失败", aid))
+	}
+	log.Debugf("[model] match count: %v, modify count: %v", res.MatchedCount, res.ModifiedCount)
+	return nil
+}
+
+func (r *mongoAppRepository) Touch(ctx context.Context, aid string, lastUsed int64) error {
+	oid, err := primitive.ObjectIDFromHex(aid)
+	if err != nil {
+		return err
+	}
+	if _, err = r.col.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{
+		"$set": bson.M{"last_used": lastUsed},
+		"$inc": bson.M{"counter": 1},
+	}); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("更新 app %s 调用次数失败", aid))
+	}
+	return nil
+}
+
+// 工作流集合
+type mongoWorkflowRepository struct {
+	col *mongo.Collection
+}
+
+func (r *mongoWorkflowRepository) Find(ctx context.Context, query *WorkflowQuery) ([]Workflow, error) {
+	workflows := make([]Workflow, 0)
+
+	queryDB := GetWhereDb(query, nil)
+	count, err := r.col.CountDocuments(ctx, queryDB.Map())
+	if err != nil {
+		return workflows, errors.Wrap(err, "failed to get the page total of workflows")
+	}
+	query.Total = int(count)
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "create_at", Value: DESC}}).
+		SetSkip(int64((query.Index - 1) * query.Count)).
+		SetLimit(int64(query.Count))
+
+	cursor, err := r.col.Find(ctx, queryDB.Map(), opts)
+	if err != nil {
+		return workflows, errors.Wrap(err, "get workflows err")
+	}
+	if err = cursor.All(ctx, &workflows); err != nil {
+		return workflows, errors.Wrap(err, "decode all workflows err")
+	}
+	return workflows, nil
+}
+
+func (r *mongoWorkflowRepository) FindAll(ctx context.Context) ([]Workflow, error) {
+	workflows := make([]Workflow, 0)
+	cursor, err := r.col.Find(ctx, bson.M{})
+	if err != nil {
+		return workflows, errors.Wrap(err, "get all workflows err")
+	}
+	if err = cursor.All(ctx, &workflows); err != nil {
+		return workflows, errors.Wrap(err, "decode all workflows err")
+	}
+	return workflows, nil
+}
+
+func (r *mongoWorkflowRepository) FindOne(ctx context.Context, wid string) (Workflow, error) {
+	var w Workflow
+
+	oid, err := primitive.ObjectIDFromHex(wid)
+	if err != nil {
+		return w, err
+	}
+
+	if err = r.col.FindOne(ctx, bson.M{"_id": oid}).Decode(&w); err != nil {
+		return w, errors.Wrap(err, fmt.Sprintf("decode workflow %s err", wid))
+	}
+	return w, nil
+}
+
+func (r *mongoWorkflowRepository) Insert(ctx context.Context, w *Workflow) error {
+	res, err := r.col.InsertOne(ctx, w)
+	if err != nil {
+		return errors.Wrap(err, "insert workflow err")
+	}
+	log.Debugf("[model] insert id is: %v", res.InsertedID)
+	return nil
+}
+
+func (r *mongoWorkflowRepository) Update(ctx context.Context, wid string, fields map[string]interface{}) error {
+	oid, err := primitive.ObjectIDFromHex(wid)
+	if err != nil {
+		return err
+	}
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": fields})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("update workflow %s err", wid))
+	}
+	log.Debugf("[model] match count: %v, modify count: %v", res.MatchedCount, res.ModifiedCount)
+	return nil
+}
+
+func (r *mongoWorkflowRepository) Delete(ctx context.Context, wid string) error {
+	oid, err := primitive.ObjectIDFromHex(wid)
+	if err != nil {
+		return err
+	}
+	res, err := r.col.DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("delete workflow %s err", wid))
+	}
+	log.Debugf("[model] delete count: %d", res.DeletedCount)
+	return nil
+}
+
+// 工作流运行记录集合
+type mongoWorkflowRunRepository struct {
+	col *mongo.Collection
+}
+
+func (r *mongoWorkflowRunRepository) Find(ctx context.Context, query *WorkflowRunQuery) ([]WorkflowRun, error) {
+	runs := make([]WorkflowRun, 0)
+
+	queryDB := GetWhereDb(query, []string{"rid"})
+	count, err := r.col.CountDocuments(ctx, queryDB.Map())
+	if err != nil {
+		return runs, errors.Wrap(err, "failed to get the page total of workflow runs")
+	}
+	query.Total = int(count)
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "create_at", Value: DESC}}).
+		SetSkip(int64((query.Index - 1) * query.Count)).
+		SetLimit(int64(query.Count))
+
+	cursor, err := r.col.Find(ctx, queryDB.Map(), opts)
+	if err != nil {
+		return runs, errors.Wrap(err, "get workflow runs err")
+	}
+	if err = cursor.All(ctx, &runs); err != nil {
+		return runs, errors.Wrap(err, "decode all workflow runs err")
+	}
+	return runs, nil
+}
+
+func (r *mongoWorkflowRunRepository) Insert(ctx context.Context, run *WorkflowRun) error {
+	res, err := r.col.InsertOne(ctx, run)
+	if err != nil {
+		return errors.Wrap(err, "insert workflow run err")
+	}
+	log.Debugf("[model] insert id is: %v", res.InsertedID)
+	return nil
+}
+
+func (r *mongoWorkflowRunRepository) Update(ctx context.Context, rid string, fields map[string]interface{}) error {
+	res, err := r.col.UpdateOne(ctx, bson.M{"rid": rid}, bson.M{"$set": fields})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("update workflow run %s err", rid))
+	}
+	log.Debugf("[model] match count: %v, modify count: %v", res.MatchedCount, res.ModifiedCount)
+	return nil
+}
+
+// 审计日志集合
+type mongoAuditRepository struct {
+	col *mongo.Collection
+}
+
+func (r *mongoAuditRepository) Find(ctx context.Context, query *AuditQuery) ([]AuditLog, error) {
+	audits := make([]AuditLog, 0)
+
+	queryDB := auditWhereDb(query)
+	count, err := r.col.CountDocuments(ctx, queryDB)
+	if err != nil {
+		return audits, errors.Wrap(err, "failed to get the page total of audits")
+	}
+	query.Total = int(count)
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "create_at", Value: DESC}}).
+		SetSkip(int64((query.Index - 1) * query.Count)).
+		SetLimit(int64(query.Count))
+
+	cursor, err := r.col.Find(ctx, queryDB, opts)
+	if err != nil {
+		return audits, errors.Wrap(err, "get audits err")
+	}
+	if err = cursor.All(ctx, &audits); err != nil {
+		return audits, errors.Wrap(err, "decode all audits err")
+	}
+	return audits, nil
+}
+
+func (r *mongoAuditRepository) Insert(ctx context.Context, a *AuditLog) error {
+	res, err := r.col.InsertOne(ctx, a)
+	if err != nil {
+		return errors.Wrap(err, "insert audit err")
+	}
+	log.Debugf("[model] insert id is: %v", res.InsertedID)
+	return nil
+}
@@ -1,9 +1,12 @@
 package storage
 
 import (
-	"bytes"
+	"clock/v3/config"
 	"context"
+	"fmt"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -26,29 +29,106 @@ func GenGuid(length int) (string, error) {
 	return guid[0:length], nil
 }
 
-func saveLog(t Task, stdOut, stdErr *bytes.Buffer, start, end int64) {
-
-	// 回写日志状态
-	if t.LogEnable {
-		id := primitive.NewObjectID()
-		l := TaskLog{
-			Id:       id,
-			Lid:      id.Hex(),
-			Tid:      t.Tid,
-			StdOut:   stdOut.String(),
-			StdErr:   stdErr.String(),
-			StartAt:  start,
-			EndAt:    end,
-			CreateAt: time.Now().Unix(),
-		}
-		// TODO: MDB Batch
-		res, err := TaskLogCol.InsertOne(context.Background(), &l)
-		if err != nil {
-			log.Errorf("[ostool] insert log to db err: %v", err)
-			return
-		}
-		log.Debugf("[ostool] insert id: %v", res.InsertedID)
+//WorkerId 当前 worker 的标识 默认为 hostname:pid
+func WorkerId() string {
+	if id := config.Config.GetString("worker.id"); id != "" {
+		return id
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
+
+//newTaskLog 每一次运行都会生成一条运行记录
+func newTaskLog(t Task, start time.Time) TaskLog {
+	id := primitive.NewObjectID()
+	return TaskLog{
+		Id:      id,
+		Lid:     id.Hex(),
+		Tid:     t.Tid,
+		Worker:  WorkerId(),
+		StartAt: start.Unix(),
+		Attempt: 1,
+		start:   start,
+	}
+}
+
+//lockFailedLog 没有抢到锁 锁被上一次运行持有或者当前 worker 排队已满的为 skipped，否则为 failed
+func lockFailedLog(t Task, start time.Time, err error) TaskLog {
+	l := newTaskLog(t, start)
+	switch err {
+	case WaitForNextScheduleErr:
+		l.finish(RunSkipped, 0)
+	case WorkerBusyErr:
+		l.StdErr = err.Error()
+		l.finish(RunSkipped, 0)
+	default:
+		l.StdErr = err.Error()
+		l.finish(RunFailed, -1)
+	}
+	return l
+}
+
+//finish 记录运行结束的状态以及耗时
+func (l *TaskLog) finish(status string, code int) {
+	now := time.Now()
+	l.Status = status
+	l.Code = code
+	l.EndAt = now.Unix()
+	l.Duration = now.Sub(l.start).Milliseconds()
+}
 
+//startStreamLog 开启 stream_output 并且执行器支持流式输出的任务在运行开始的时候先写入一条 running 的运行记录
+func startStreamLog(t Task, l *TaskLog) {
+	if !t.StreamOutput || !t.LogEnable {
+		return
+	}
+	if e, err := GetExecutor(t.Type); err != nil || !e.Streamable() {
+		return
+	}
+	l.Status = RunRunning
+	l.CreateAt = time.Now().Unix()
+	if err := DB.TaskLog().Insert(context.Background(), l); err != nil {
+		log.Errorf("[ostool] insert running log to db err: %v", err)
+		return
 	}
+	l.streamed = true
+}
+
+//pendingLogs 正在异步保存的运行记录 调度器停止的时候等待保存完成
+var pendingLogs sync.WaitGroup
 
+//saveLogAsync 异步保存运行记录 不阻塞任务执行
+func saveLogAsync(t Task, l TaskLog) {
+	pendingLogs.Add(1)
+	go func() {
+		defer pendingLogs.Done()
+		saveLog(t, l)
+	}()
+}
+
+//saveLog 保存运行记录 未开启日志的任务只记录运行状态 不记录输出
+func saveLog(t Task, l TaskLog) {
+	if !t.LogEnable {
+		l.StdOut = ""
+		l.StdErr = ""
+	}
+	if l.streamed {
+		if err := DB.TaskLog().Update(context.Background(), l.Lid, map[string]interface{}{
+			"std_out":  l.StdOut,
+			"std_err":  l.StdErr,
+			"end_at":   l.EndAt,
+			"status":   l.Status,
+			"code":     l.Code,
+			"duration": l.Duration,
+		}); err != nil {
+			log.Errorf("[ostool] update log %s err: %v", l.Lid, err)
+		}
+		return
+	}
+	l.CreateAt = time.Now().Unix()
+	if err := DB.TaskLog().Insert(context.Background(), &l); err != nil {
+		log.Errorf("[ostool] insert log to db err: %v", err)
+		return
+	}
+	log.Debugf("[ostool] task %s run %s status: %s", t.Tid, l.Lid, l.Status)
 }
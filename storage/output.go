@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"clock/v3/config"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 默认每个输出流最多保存 1MB
+const defaultOutputLimit = 1 << 20
+
+//outputLimit 任务配置优先 其次是 output.limit 配置
+func outputLimit(t Task) int {
+	if t.OutputLimit > 0 {
+		return t.OutputLimit
+	}
+	if limit := config.Config.GetInt("output.limit"); limit > 0 {
+		return limit
+	}
+	return defaultOutputLimit
+}
+
+//outputCapture 并发安全 有容量上限的输出 超过上限之后保留头部和尾部各一半
+//超时之后可能还有子进程在写入 所以需要加锁
+type outputCapture struct {
+	mu      sync.Mutex
+	limit   int
+	head    []byte
+	tail    []byte
+	dropped int64 // 被截断的字节数
+}
+
+func newOutputCapture(limit int) *outputCapture {
+	return &outputCapture{limit: limit}
+}
+
+func (o *outputCapture) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := len(p)
+
+	headCap := o.limit / 2
+	if room := headCap - len(o.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		o.head = append(o.head, p[:room]...)
+		p = p[room:]
+	}
+
+	tailCap := o.limit - headCap
+	o.tail = append(o.tail, p...)
+	if excess := len(o.tail) - tailCap; excess > 0 {
+		copy(o.tail, o.tail[excess:])
+		o.tail = o.tail[:tailCap]
+		o.dropped += int64(excess)
+	}
+	return n, nil
+}
+
+func (o *outputCapture) WriteString(s string) (int, error) {
+	return o.Write([]byte(s))
+}
+
+//String 被截断的时候在头尾之间加上标记
+func (o *outputCapture) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.dropped == 0 {
+		return string(o.head) + string(o.tail)
+	}
+	return fmt.Sprintf("%s\n... [truncated %d bytes] ...\n%s", o.head, o.dropped, o.tail)
+}
+
+//capOutput 截断一次性得到的输出 例如 http 的返回
+func capOutput(s string, limit int) string {
+	o := newOutputCapture(limit)
+	_, _ = o.WriteString(s)
+	return o.String()
+}
+
+//streamOutput 定时将输出写入运行记录 返回的函数用于停止
+func streamOutput(lid string, stdOut, stdErr *outputCapture) func() {
+	interval := config.Config.GetDuration("output.flush") * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := DB.TaskLog().Update(context.Background(), lid, map[string]interface{}{
+					"std_out": stdOut.String(),
+					"std_err": stdErr.String(),
+				}); err != nil {
+					log.Errorf("[output] stream log %s err: %v", lid, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputCapture(t *testing.T) {
+	o := newOutputCapture(10)
+	_, _ = o.WriteString("0123")
+	assert.Equal(t, "0123", o.String())
+
+	_, _ = o.WriteString("456789")
+	assert.Equal(t, "0123456789", o.String())
+
+	_, _ = o.WriteString("abc")
+	_, _ = o.WriteString("def")
+	assert.Equal(t, "01234\n... [truncated 6 bytes] ...\nbcdef", o.String())
+
+	assert.Equal(t, "ab\n... [truncated 16 bytes] ...\nyz", capOutput("ab"+strings.Repeat("-", 16)+"yz", 4))
+}
+
+func TestBashOutputLimit(t *testing.T) {
+	setKillGrace(t, 1)
+	task := Task{OutputLimit: 100, Payload: map[string]interface{}{"command": "seq 1 10000"}}
+	l := newTaskLog(task, time.Now())
+	assert.Nil(t, RunBashTask(task, &l))
+	assert.True(t, strings.HasPrefix(l.StdOut, "1\n2\n3\n"))
+	assert.True(t, strings.HasSuffix(l.StdOut, "9999\n10000\n"))
+	assert.Contains(t, l.StdOut, "truncated")
+	assert.Less(t, len(l.StdOut), 200)
+}
+
+func TestStreamOutput(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+	setTestConfig(t, "output.flush", 1)
+
+	task := Task{
+		Name:         "stream",
+		Expression:   "0 0 * * *",
+		Type:         BashTask,
+		LogEnable:    true,
+		StreamOutput: true,
+		Payload:      map[string]interface{}{"command": "echo first; sleep 2; echo second"},
+	}
+	assert.Nil(t, PostTask(&task))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- RunTask(task.Tid)
+	}()
+
+	// 运行过程中可以看到已经输出的内容
+	query := LogQuery{}
+	query.Tid = task.Tid
+	logs := waitLogs(t, query, 1)
+	assert.Len(t, logs, 1)
+	assert.Equal(t, RunRunning, logs[0].Status)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for logs[0].StdOut == "" && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		logs, _ = GetLogs(&query)
+	}
+	assert.Equal(t, "first\n", logs[0].StdOut)
+	assert.Equal(t, RunRunning, logs[0].Status)
+
+	assert.Nil(t, <-done)
+	deadline = time.Now().Add(2 * time.Second)
+	for logs[0].Status == RunRunning && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		logs, _ = GetLogs(&query)
+	}
+	assert.Len(t, logs, 1)
+	assert.Equal(t, RunSuccess, logs[0].Status)
+	assert.Equal(t, "first\nsecond\n", logs[0].StdOut)
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+//PayloadError payload 中某个字段的错误
+type PayloadError struct {
+	Field string `json:"field"`
+	Msg   string `json:"msg"`
+}
+
+func (e PayloadError) Error() string {
+	return fmt.Sprintf("payload.%s: %s", e.Field, e.Msg)
+}
+
+//PayloadErrors 一次返回所有字段的错误
+type PayloadErrors []PayloadError
+
+func (e PayloadErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+//orNil 没有错误的时候返回 nil 避免返回非空的 error 接口
+func (e PayloadErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+//decodePayload 将 payload 解析到对应类型的结构体中 类型不匹配返回字段级别的错误
+func decodePayload(payload map[string]interface{}, v interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			return PayloadErrors{{Field: typeErr.Field, Msg: fmt.Sprintf("expected %s but got %s", typeErr.Type, typeErr.Value)}}
+		}
+		return err
+	}
+	return nil
+}
+
+//Validate bash 任务需要 command
+func (p BashTaskPayload) Validate() error {
+	var errs PayloadErrors
+	if strings.TrimSpace(p.Command) == "" {
+		errs = append(errs, PayloadError{Field: "command", Msg: "is required"})
+	}
+	return errs.orNil()
+}
+
+//httpMethods http 任务支持的 method
+var httpMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"}
+
+//Validate http 任务需要合法的 endpoint 以及支持的 method data 需要是对象
+//同时校验 headers body 模板 认证 TLS 以及成功条件
+func (p HTTPTaskPayload) Validate() error {
+	var errs PayloadErrors
+	if p.EndPoint == "" {
+		errs = append(errs, PayloadError{Field: "endpoint", Msg: "is required"})
+	} else if u, err := url.Parse(p.EndPoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, PayloadError{Field: "endpoint", Msg: "must be an absolute http or https url"})
+	}
+	switch {
+	case p.Method == "":
+		errs = append(errs, PayloadError{Field: "method", Msg: "is required"})
+	case !inCondition(httpMethods, p.Method):
+		errs = append(errs, PayloadError{Field: "method", Msg: fmt.Sprintf("unsupported method %s, must be one of %s", p.Method, strings.Join(httpMethods, ", "))})
+	}
+	if p.Data != nil {
+		if _, ok := p.Data.(map[string]interface{}); !ok {
+			errs = append(errs, PayloadError{Field: "data", Msg: "must be an object"})
+		}
+	}
+	for k := range p.Headers {
+		if strings.TrimSpace(k) == "" {
+			errs = append(errs, PayloadError{Field: "headers", Msg: "header name must not be empty"})
+			break
+		}
+	}
+	if p.Body != "" {
+		switch {
+		case p.Data != nil:
+			errs = append(errs, PayloadError{Field: "body", Msg: "must not be set together with data"})
+		case !p.hasBody():
+			errs = append(errs, PayloadError{Field: "body", Msg: fmt.Sprintf("is not allowed for method %s", p.Method)})
+		default:
+			if _, err := renderHTTPBody(p.Body, httpBodyContext{}); err != nil {
+				errs = append(errs, PayloadError{Field: "body", Msg: fmt.Sprintf("invalid template: %v", err)})
+			}
+		}
+	}
+	if p.Auth != nil {
+		switch p.Auth.Type {
+		case "basic":
+			if p.Auth.Username == "" {
+				errs = append(errs, PayloadError{Field: "auth.username", Msg: "is required for basic auth"})
+			}
+		case "bearer":
+			if p.Auth.Token == "" {
+				errs = append(errs, PayloadError{Field: "auth.token", Msg: "is required for bearer auth"})
+			}
+		default:
+			errs = append(errs, PayloadError{Field: "auth.type", Msg: fmt.Sprintf("unsupported auth type %s, must be basic or bearer", p.Auth.Type)})
+		}
+	}
+	if _, err := p.TLS.config(); err != nil {
+		errs = append(errs, PayloadError{Field: "tls.ca", Msg: err.Error()})
+	}
+	if c := p.Success; c != nil {
+		for _, code := range c.StatusCodes {
+			if code < 100 || code > 599 {
+				errs = append(errs, PayloadError{Field: "success.status_codes", Msg: fmt.Sprintf("invalid status code %d", code)})
+				break
+			}
+		}
+		if c.BodyRegex != "" {
+			if _, err := regexp.Compile(c.BodyRegex); err != nil {
+				errs = append(errs, PayloadError{Field: "success.body_regex", Msg: err.Error()})
+			}
+		}
+		if c.JSONPath != "" {
+			if _, err := parseJSONPath(c.JSONPath); err != nil {
+				errs = append(errs, PayloadError{Field: "success.json_path", Msg: err.Error()})
+			}
+		} else if c.JSONValue != "" {
+			errs = append(errs, PayloadError{Field: "success.json_value", Msg: "requires json_path"})
+		}
+	}
+	return errs.orNil()
+}
+
+//BashPayload 解析并校验 bash 任务的 payload
+func (t Task) BashPayload() (BashTaskPayload, error) {
+	var p BashTaskPayload
+	if err := decodePayload(t.Payload, &p); err != nil {
+		return p, err
+	}
+	return p, p.Validate()
+}
+
+//HTTPPayload 解析并校验 http 任务的 payload
+func (t Task) HTTPPayload() (HTTPTaskPayload, error) {
+	var p HTTPTaskPayload
+	if err := decodePayload(t.Payload, &p); err != nil {
+		return p, err
+	}
+	return p, p.Validate()
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskPayloadValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		task    Task
+		message string
+	}{
+		{"bash ok", Task{Type: BashTask, Payload: map[string]interface{}{"command": "date"}}, ""},
+		{"bash empty", Task{Type: BashTask, Payload: map[string]interface{}{}}, "payload.command: is required"},
+		{"bash type", Task{Type: BashTask, Payload: map[string]interface{}{"command": 1}}, "payload.command: expected string but got number"},
+		{"http ok", Task{Type: HTTPTask, Payload: map[string]interface{}{"endpoint": "http://127.0.0.1", "method": "GET"}}, ""},
+		{"http empty", Task{Type: HTTPTask, Payload: map[string]interface{}{}}, "payload.endpoint: is required; payload.method: is required"},
+		{"http url", Task{Type: HTTPTask, Payload: map[string]interface{}{"endpoint": "127.0.0.1", "method": "GET"}}, "payload.endpoint: must be an absolute http or https url"},
+		{"http method", Task{Type: HTTPTask, Payload: map[string]interface{}{"endpoint": "http://127.0.0.1", "method": "TRACE"}}, "payload.method: unsupported method TRACE, must be one of GET, POST, PUT, PATCH, DELETE, HEAD"},
+		{"http body", Task{Type: HTTPTask, Payload: map[string]interface{}{"endpoint": "http://127.0.0.1", "method": "GET", "body": "x"}}, "payload.body: is not allowed for method GET"},
+		{"http template", Task{Type: HTTPTask, Payload: map[string]interface{}{"endpoint": "http://127.0.0.1", "method": "PUT", "body": "{{.Unknown}}"}}, "payload.body: invalid template: template: body:1:2: executing \"body\" at <.Unknown>: can't evaluate field Unknown in type storage.httpBodyContext"},
+		{"http auth", Task{Type: HTTPTask, Payload: map[string]interface{}{"endpoint": "http://127.0.0.1", "method": "GET", "auth": map[string]interface{}{"type": "bearer"}}}, "payload.auth.token: is required for bearer auth"},
+		{"http ca", Task{Type: HTTPTask, Payload: map[string]interface{}{"endpoint": "https://127.0.0.1", "method": "GET", "tls": map[string]interface{}{"ca": "x"}}}, "payload.tls.ca: no valid PEM certificate found"},
+		{"http success", Task{Type: HTTPTask, Payload: map[string]interface{}{"endpoint": "http://127.0.0.1", "method": "GET", "success": map[string]interface{}{"status_codes": []int{20}, "json_path": "data"}}}, "payload.success.status_codes: invalid status code 20; payload.success.json_path: json path \"data\" must start with $"},
+		{"http data", Task{Type: HTTPTask, Payload: map[string]interface{}{"endpoint": "http://127.0.0.1", "method": "POST", "data": "x"}}, "payload.data: must be an object"},
+		{"unknown type", Task{Type: "ftp", Payload: map[string]interface{}{}}, "unsupported task type ftp"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validatePayload(&c.task)
+			if c.message == "" {
+				assert.Nil(t, err)
+				return
+			}
+			assert.EqualError(t, err, c.message)
+		})
+	}
+}
+
+// 非法的 payload 记录为失败 而不是在 cron 的 goroutine 中 panic
+func TestExecutorInvalidPayload(t *testing.T) {
+	setKillGrace(t, 1)
+
+	task := Task{Tid: "bash", Type: BashTask, Payload: map[string]interface{}{}}
+	l := newTaskLog(task, time.Now())
+	assert.NotNil(t, RunBashTask(task, &l))
+	assert.Equal(t, RunFailed, l.Status)
+	assert.Contains(t, l.StdErr, "payload.command")
+
+	task = Task{Tid: "http", Type: HTTPTask, Payload: map[string]interface{}{"endpoint": "http://127.0.0.1"}}
+	l = newTaskLog(task, time.Now())
+	assert.NotNil(t, RunHTTPTask(task, time.Now().Format(time.RFC3339), &l))
+	assert.Equal(t, RunFailed, l.Status)
+	assert.Contains(t, l.StdErr, "payload.method")
+}
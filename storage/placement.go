@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"clock/v3/config"
+	"fmt"
+)
+
+//IsEmpty 没有设置任何标签 所有 worker 都可以调度
+func (s TaskSelector) IsEmpty() bool {
+	return len(s.Required) == 0 && len(s.Preferred) == 0
+}
+
+//matches worker 的标签是否满足 required
+func (s TaskSelector) matches(labels map[string]string) bool {
+	for k, v := range s.Required {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+//score worker 的标签匹配了多少个 preferred
+func (s TaskSelector) score(labels map[string]string) int {
+	n := 0
+	for k, v := range s.Preferred {
+		if labels[k] == v {
+			n++
+		}
+	}
+	return n
+}
+
+//candidates 可以调度任务的 worker 满足 required 的 worker 中 preferred 匹配最多的那些
+func (s TaskSelector) candidates(workers []Worker) map[string]bool {
+	best := 0
+	matched := make([]Worker, 0, len(workers))
+	for _, w := range workers {
+		if !s.matches(w.Labels) {
+			continue
+		}
+		matched = append(matched, w)
+		if n := s.score(w.Labels); n > best {
+			best = n
+		}
+	}
+	res := make(map[string]bool, len(matched))
+	for _, w := range matched {
+		if s.score(w.Labels) == best {
+			res[w.Id] = true
+		}
+	}
+	return res
+}
+
+//localLabels 当前进程的标签 调度器启动之后使用注册时上报的标签
+func localLabels() map[string]string {
+	if workerMembership != nil {
+		return workerMembership.labels()
+	}
+	return config.Config.GetStringMapString("worker.labels")
+}
+
+//validateSelector 设置了 required 的任务至少需要有一个存活的 worker 满足
+func validateSelector(s TaskSelector) error {
+	if len(s.Required) == 0 {
+		return nil
+	}
+	workers, err := LiveWorkers()
+	if err != nil {
+		return err
+	}
+	for _, w := range workers {
+		if s.matches(w.Labels) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no live worker matches selector %v", s.Required)
+}
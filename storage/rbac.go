@@ -0,0 +1,75 @@
+package storage
+
+import "fmt"
+
+// app 的角色 root 视为 admin
+const (
+	RoleAdmin    = "admin"    // 管理所有类型的任务
+	RoleOperator = "operator" // 管理除 bash 之外的任务 可以执行任务
+	RoleViewer   = "viewer"   // 只能查看任务 工作流以及日志
+)
+
+// 对任务以及工作流的操作
+const (
+	ActionRead  = "read"  // 查看
+	ActionWrite = "write" // 新增 修改 禁用 删除
+	ActionRun   = "run"   // 手动执行
+	ActionForce = "force" // 跳过 bash 任务的高危命令检测
+)
+
+//adminTaskTypes 只有 admin 可以新增以及修改的作业类型
+var adminTaskTypes = []string{BashTask}
+
+//rolePermissions 每个角色允许的操作
+var rolePermissions = map[string][]string{
+	RoleAdmin:    {ActionRead, ActionWrite, ActionRun, ActionForce},
+	RoleOperator: {ActionRead, ActionWrite, ActionRun},
+	RoleViewer:   {ActionRead},
+}
+
+//validRole 角色为空的时候默认 operator
+func validRole(role string) (string, error) {
+	if role == "" {
+		return RoleOperator, nil
+	}
+	if _, ok := rolePermissions[role]; !ok {
+		return "", fmt.Errorf("unsupported role %s, must be admin, operator or viewer", role)
+	}
+	return role, nil
+}
+
+//EffectiveRole 生效的角色 root 为 admin 没有设置角色的旧 app 为 operator
+func (a App) EffectiveRole() string {
+	if a.IsRoot() {
+		return RoleAdmin
+	}
+	if a.Role == "" {
+		return RoleOperator
+	}
+	return a.Role
+}
+
+//Actor 记录在 created_by 以及 updated_by 中的调用方标识
+func (a App) Actor() string {
+	if a.IsRoot() {
+		return RootApp.AppName
+	}
+	return a.Aid
+}
+
+//Authorize 判断 app 是否可以对某种类型的任务执行操作 工作流传入空的作业类型
+func (a App) Authorize(action string, taskTypes ...string) error {
+	role := a.EffectiveRole()
+	if !inCondition(rolePermissions[role], action) {
+		return fmt.Errorf("%w: role %s can not %s", PermissionDeniedErr, role, action)
+	}
+	if action != ActionWrite || role == RoleAdmin {
+		return nil
+	}
+	for _, typ := range taskTypes {
+		if inCondition(adminTaskTypes, typ) {
+			return fmt.Errorf("%w: only admin can write %s tasks", PermissionDeniedErr, typ)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorize(t *testing.T) {
+	admin := App{Aid: "a", Role: RoleAdmin}
+	operator := App{Aid: "o", Role: RoleOperator}
+	legacy := App{Aid: "l"}
+	viewer := App{Aid: "v", Role: RoleViewer}
+
+	cases := []struct {
+		name    string
+		app     App
+		action  string
+		types   []string
+		allowed bool
+	}{
+		{"root bash", RootApp, ActionWrite, []string{BashTask}, true},
+		{"admin bash", admin, ActionWrite, []string{BashTask}, true},
+		{"operator http", operator, ActionWrite, []string{HTTPTask}, true},
+		{"operator bash", operator, ActionWrite, []string{BashTask}, false},
+		{"operator http to bash", operator, ActionWrite, []string{HTTPTask, BashTask}, false},
+		{"operator run bash", operator, ActionRun, []string{BashTask}, true},
+		{"legacy app is operator", legacy, ActionWrite, []string{BashTask}, false},
+		{"viewer read", viewer, ActionRead, []string{BashTask}, true},
+		{"viewer write", viewer, ActionWrite, []string{HTTPTask}, false},
+		{"viewer run", viewer, ActionRun, []string{HTTPTask}, false},
+		{"viewer workflow", viewer, ActionWrite, nil, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.app.Authorize(c.action, c.types...)
+			if c.allowed {
+				assert.Nil(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, PermissionDeniedErr))
+		})
+	}
+}
+
+func TestAppRole(t *testing.T) {
+	for name, newDB := range testDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			setTestStorage(t, newDB())
+			defer RevokeDb()
+			ctx := context.Background()
+
+			a := App{AppName: "ops"}
+			assert.Nil(t, CreateApp(ctx, &a))
+			assert.Equal(t, RoleOperator, a.Role)
+
+			assert.NotNil(t, CreateApp(ctx, &App{AppName: "x", Role: "owner"}))
+			assert.NotNil(t, ModifyAppRole(ctx, a.Aid, "owner"))
+			assert.Nil(t, ModifyAppRole(ctx, a.Aid, RoleViewer))
+			got, err := GetOneApp(ctx, a.Aid)
+			assert.Nil(t, err)
+			assert.Equal(t, RoleViewer, got.Role)
+
+			// created_by 不能通过更新修改
+			task := Task{Name: "t", Expression: "0 0 * * *", Type: BashTask, Aid: a.Aid, CreatedBy: a.Aid, UpdatedBy: a.Aid,
+				Payload: map[string]interface{}{"command": "date"}}
+			assert.Nil(t, PostTask(&task))
+			updated, err := UpdateTask(task.Tid, map[string]interface{}{"created_by": "root", "updated_by": "root", "name": "t2"})
+			assert.Nil(t, err)
+			assert.Equal(t, a.Aid, updated.CreatedBy)
+			got2, err := GetTask(task.Tid)
+			assert.Nil(t, err)
+			assert.Equal(t, a.Aid, got2.CreatedBy)
+			assert.Equal(t, "root", got2.UpdatedBy)
+			assert.Equal(t, "t2", got2.Name)
+		})
+	}
+}
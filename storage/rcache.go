@@ -6,10 +6,10 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"github.com/vmihailenco/msgpack/v5"
 
 	"github.com/go-redis/redis/v8"
 )
@@ -25,11 +25,32 @@ func NewRedisCache(client *redis.Client) *RedisCache {
 //TryLock redis 分布式锁
 func (r *RedisCache) TryLock(t Task) (chan int, error) {
 	// 随机睡眠(0-1) 考虑到各个 worker 之间的时钟有相差 us，所以通过牺牲最多 1s 进行公平抢锁
-	time.Sleep(time.Duration(rand.Intn(1000)) * time.Millisecond)
+	// 开启分片之后每个任务只有一个 worker 调度 不需要公平抢锁 锁只用于防止重新分配期间重复执行
+	if !ShardEnabled() {
+		time.Sleep(time.Duration(rand.Intn(1000)) * time.Millisecond)
+	}
+	return r.lock(fmt.Sprintf("%s:%s", config.Config.GetString("lease.prefix"), t.Tid), t)
+}
+
+//TryLockN 依次尝试 n 个锁 <lease.prefix>:<tid>:<i> 抢到任意一个即可 只随机睡眠一次
+func (r *RedisCache) TryLockN(t Task, n int) (chan int, error) {
+	if !ShardEnabled() {
+		time.Sleep(time.Duration(rand.Intn(1000)) * time.Millisecond)
+	}
+	for i := 0; i < n; i++ {
+		jobDone, err := r.lock(fmt.Sprintf("%s:%s:%d", config.Config.GetString("lease.prefix"), t.Tid, i), t)
+		if err != WaitForNextScheduleErr {
+			return jobDone, err
+		}
+	}
+	return nil, WaitForNextScheduleErr
+}
+
+//lock 抢锁成功之后开始续租
+func (r *RedisCache) lock(key string, t Task) (chan int, error) {
 	ctx := context.Background()
 	rid, _ := GenGuid(8)         // 生成 uuid 和 task name 组合作为 key 的 value
 	jobDone := make(chan int, 1) // 任务完成的时候 done <- 1
-	key := fmt.Sprintf("%s:%s", config.Config.GetString("lease.prefix"), t.Tid)
 	res, err := r.Client.Exists(ctx, key).Result()
 	if err != nil {
 		log.Errorf("[ostool] redis exists key err: %v", err)
@@ -111,46 +132,7 @@ func (r *RedisCache) Subscribe(c chan os.Signal) {
 		case *redis.Subscription:
 			log.Infof("[scheduler] 成功订阅 %s", channelName)
 		case *redis.Message:
-			t := TaskEvent{}
-			if err := msgpack.Unmarshal([]byte(msg.Payload), &t); err != nil {
-				log.Errorf("[scheduler] msg 解析发生错误")
-			}
-			log.Debugf("[scheduler] taskEvent is %v", t)
-			task, err := GetTask(t.Tid)
-			if err != nil {
-				log.Errorf("[scheduler] task %s not found", t.Tid)
-				if t.Event == DELETE { // 删除事件特殊处理 数据可能已经被 master 删除了
-					if err := NewSchedulerDeleteTask(t.Tid); err != nil {
-						log.Errorf("[scheduler] DELETE 事件失败 %s", err.Error())
-					}
-					log.Infof("[scheduler] DELETE %d 事件处理成功", DELETE)
-				}
-				continue // 肯定是要 continue 的 后面的不用走
-			}
-			switch t.Event {
-			case CREATE:
-				if err := NewInitPutTask(task); err != nil {
-					log.Errorf("[scheduler] CREATE 事件失败 %s", err.Error())
-				}
-				log.Infof("[scheduler] CREATE %d 事件处理成功", CREATE)
-			case MODIFY:
-				if err := NewSchedulerModifyTask(task); err != nil {
-					log.Errorf("[scheduler] MODIFY 事件失败 %s", err.Error())
-				}
-				log.Infof("[scheduler] MODIFY %d 事件处理成功", MODIFY)
-			case DISABLE:
-				if err := NewSchedulerDisableTask(task); err != nil {
-					log.Errorf("[scheduler] DISABLE 事件失败 %s", err.Error())
-				}
-				log.Infof("[scheduler] DISABLE %d 事件处理成功", DISABLE)
-			case DELETE:
-				if err := NewSchedulerDeleteTask(task); err != nil {
-					log.Errorf("[scheduler] DELETE 事件失败 %s", err.Error())
-				}
-				log.Infof("[scheduler] DELETE %d 事件处理成功", DELETE)
-			default:
-				log.Errorf("[scheduler] 没有对应的类型")
-			}
+			handleTaskEvent([]byte(msg.Payload))
 		default:
 			log.Warnf("[scheduler] 默认是什么鬼 %v", msg)
 			goto End
@@ -161,6 +143,89 @@ End:
 	log.Errorf("[scheduler] 消息订阅发生错误")
 }
 
+//SetNX key 不存在的时候写入并设置过期时间
+func (r *RedisCache) SetNX(key string, ttl time.Duration) (bool, error) {
+	return r.Client.SetNX(context.Background(), key, 1, ttl).Result()
+}
+
+func (r *RedisCache) Set(key string, val []byte, ttl time.Duration) error {
+	return r.Client.Set(context.Background(), key, val, ttl).Err()
+}
+
+//GetPrefix 通过 SCAN 遍历 key 避免 KEYS 阻塞 redis
+func (r *RedisCache) GetPrefix(prefix string) (map[string][]byte, error) {
+	ctx := context.Background()
+	res := make(map[string][]byte)
+	iter := r.Client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	keys := make([]string, 0)
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return res, nil
+	}
+	vals, err := r.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range vals {
+		if s, ok := v.(string); ok { // 在 SCAN 和 MGET 之间过期的 key 为 nil
+			res[keys[i]] = []byte(s)
+		}
+	}
+	return res, nil
+}
+
+func (r *RedisCache) Del(key string) error {
+	return r.Client.Del(context.Background(), key).Err()
+}
+
+func (r *RedisCache) PublishTo(channel string, msg []byte) error {
+	return r.Client.Publish(context.Background(), channel, msg).Err()
+}
+
+//SubscribeTo 订阅成功之后才返回 避免丢失订阅之前发布的消息
+func (r *RedisCache) SubscribeTo(channel string) (<-chan []byte, func(), error) {
+	ctx := context.Background()
+	pubsub := r.Client.Subscribe(ctx, channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, nil, err
+	}
+
+	out := make(chan []byte, 64)
+	done := make(chan struct{})
+	go func() {
+		for msg := range pubsub.Channel() {
+			select {
+			case out <- []byte(msg.Payload):
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(done)
+			_ = pubsub.Close()
+		})
+	}
+	return out, cancel, nil
+}
+
+func (r *RedisCache) NumSub(channel string) (int64, error) {
+	res, err := r.Client.PubSubNumSub(context.Background(), channel).Result()
+	if err != nil {
+		return 0, err
+	}
+	return res[channel], nil
+}
+
 func (r *RedisCache) Ping() (string, error) {
 	return r.Client.Ping(context.Background()).Result()
 }
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	executors   = make(map[string]Executor)
+	executorsMu sync.RWMutex
+)
+
+func init() {
+	RegisterExecutor(BashTask, bashExecutor{})
+	RegisterExecutor(HTTPTask, httpExecutor{})
+}
+
+//RegisterExecutor 注册作业类型对应的执行器 重复注册会覆盖之前的执行器
+func RegisterExecutor(typ string, e Executor) {
+	if typ == "" || e == nil {
+		panic("storage: register executor with empty type or nil executor")
+	}
+	executorsMu.Lock()
+	defer executorsMu.Unlock()
+	executors[typ] = e
+}
+
+//GetExecutor 根据作业类型获取执行器
+func GetExecutor(typ string) (Executor, error) {
+	executorsMu.RLock()
+	defer executorsMu.RUnlock()
+	e, ok := executors[typ]
+	if !ok {
+		return nil, fmt.Errorf("unsupported task type %s", typ)
+	}
+	return e, nil
+}
+
+//bashExecutor 执行 bash 命令
+type bashExecutor struct{}
+
+//Validate 除了 command 必填之外还需要通过高危命令检测 force 为 true 时跳过检测
+func (bashExecutor) Validate(t Task) error {
+	p, err := t.BashPayload()
+	if err != nil || t.Force {
+		return err
+	}
+	if err = CheckCommand(p.Command); err != nil {
+		return PayloadError{Field: "command", Msg: err.Error() + ", only admin can set force to override"}
+	}
+	return nil
+}
+
+func (bashExecutor) Run(t Task, l *TaskLog) error {
+	return RunBashTask(t, l)
+}
+
+func (bashExecutor) Streamable() bool {
+	return true
+}
+
+//httpExecutor 请求 http 接口 请求数据中带上任务所在时区的当前时间
+type httpExecutor struct{}
+
+func (httpExecutor) Validate(t Task) error {
+	p, err := t.HTTPPayload()
+	if err != nil {
+		return err
+	}
+	if p.Aid != "" {
+		_, secretKey, err := GetAppKeyAndSecretKey(context.Background(), p.Aid)
+		if err != nil {
+			return PayloadError{Field: "aid", Msg: AppUnavailableErr.Error()}
+		}
+		if secretKey == "" {
+			return PayloadError{Field: "aid", Msg: AppNoSecretErr.Error()}
+		}
+	}
+	return nil
+}
+
+func (httpExecutor) Run(t Task, l *TaskLog) error {
+	loc, err := time.LoadLocation(t.Timezone)
+	if err != nil {
+		l.StdErr = err.Error()
+		l.finish(RunFailed, 0)
+		return err
+	}
+	return RunHTTPTask(t, time.Now().In(loc).Format(time.RFC3339), l)
+}
+
+func (httpExecutor) Streamable() bool {
+	return false
+}
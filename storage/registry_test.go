@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//echoExecutor 把 payload 中的 message 原样输出
+type echoExecutor struct{}
+
+func (echoExecutor) Validate(t Task) error {
+	if _, ok := t.Payload["message"].(string); !ok {
+		return PayloadError{Field: "message", Msg: "is required"}
+	}
+	return nil
+}
+
+func (echoExecutor) Run(t Task, l *TaskLog) error {
+	msg := t.Payload["message"].(string)
+	if msg == "fail" {
+		l.StdErr = msg
+		l.finish(RunFailed, 1)
+		return errors.New(msg)
+	}
+	l.StdOut = msg
+	l.finish(RunSuccess, 0)
+	return nil
+}
+
+func (echoExecutor) Streamable() bool {
+	return false
+}
+
+func TestExecutorRegistry(t *testing.T) {
+	setTestStorage(t, NewMemoryDatabase())
+	RegisterExecutor("echo", echoExecutor{})
+	t.Cleanup(func() {
+		executorsMu.Lock()
+		delete(executors, "echo")
+		executorsMu.Unlock()
+	})
+
+	_, err := GetExecutor("ftp")
+	assert.EqualError(t, err, "unsupported task type ftp")
+
+	task := Task{Name: "echo", Expression: "0 0 * * *", Type: "echo", LogEnable: true, Payload: map[string]interface{}{}}
+	assert.EqualError(t, PostTask(&task), "payload.message: is required")
+
+	task.Payload = map[string]interface{}{"message": "hello"}
+	assert.Nil(t, PostTask(&task))
+	assert.Nil(t, RunTask(task.Tid))
+	query := LogQuery{}
+	query.Tid = task.Tid
+	logs := waitLogs(t, query, 1)
+	if assert.Len(t, logs, 1) {
+		assert.Equal(t, RunSuccess, logs[0].Status)
+		assert.Equal(t, "hello", logs[0].StdOut)
+	}
+
+	fail := Task{Name: "fail", Expression: "0 0 * * *", Type: "echo", LogEnable: true, Payload: map[string]interface{}{"message": "fail"}}
+	assert.Nil(t, PostTask(&fail))
+	assert.EqualError(t, RunTask(fail.Tid), "fail")
+	query.Tid = fail.Tid
+	logs = waitLogs(t, query, 1)
+	if assert.Len(t, logs, 1) {
+		assert.Equal(t, RunFailed, logs[0].Status)
+		assert.Equal(t, 1, logs[0].Code)
+	}
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"math"
+	"time"
+)
+
+//attempts 最多执行次数
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+//backoff 第 attempt 次执行失败之后等待多久再重试
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	initial := float64(p.InitialBackoff)
+	if initial <= 0 {
+		initial = 1000
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	ms := initial * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && ms > float64(p.MaxBackoff) {
+		ms = float64(p.MaxBackoff)
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+//retryable 根据运行记录判断是否需要重试
+func (p RetryPolicy) retryable(l TaskLog) bool {
+	retryOn := p.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = []string{RetryOnFailed, RetryOnTimeout}
+	}
+	for _, on := range retryOn {
+		switch on {
+		case RetryOnFailed:
+			if l.Status == RunFailed {
+				return true
+			}
+		case RetryOnTimeout:
+			if l.Status == RunTimeout {
+				return true
+			}
+		case RetryOn5xx:
+			if l.Status == RunFailed && (l.Code == 0 || l.Code >= 500) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{}
+	assert.Equal(t, 1, p.attempts())
+	assert.Equal(t, time.Second, p.backoff(1))
+	assert.Equal(t, 4*time.Second, p.backoff(3))
+
+	p = RetryPolicy{InitialBackoff: 100, Multiplier: 3, MaxBackoff: 500}
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 300*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 500*time.Millisecond, p.backoff(3))
+}
+
+func TestRetryable(t *testing.T) {
+	p := RetryPolicy{}
+	assert.True(t, p.retryable(TaskLog{Status: RunFailed, Code: 1}))
+	assert.True(t, p.retryable(TaskLog{Status: RunTimeout, Code: -1}))
+	assert.False(t, p.retryable(TaskLog{Status: RunSuccess}))
+
+	p.RetryOn = []string{RetryOn5xx}
+	assert.True(t, p.retryable(TaskLog{Status: RunFailed, Code: 502}))
+	assert.True(t, p.retryable(TaskLog{Status: RunFailed, Code: 0}))
+	assert.False(t, p.retryable(TaskLog{Status: RunFailed, Code: 404}))
+	assert.False(t, p.retryable(TaskLog{Status: RunTimeout, Code: -1}))
+}
+
+func newRetryTask(t *testing.T, command string, retry RetryPolicy) Task {
+	task := Task{
+		Name:       command,
+		Expression: "0 0 * * *",
+		Type:       BashTask,
+		LogEnable:  true,
+		Payload:    map[string]interface{}{"command": command},
+		Retry:      retry,
+	}
+	assert.Nil(t, PostTask(&task))
+	return task
+}
+
+func TestTaskRetry(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+
+	fail := newRetryTask(t, "exit 1", RetryPolicy{MaxAttempts: 3, InitialBackoff: 10})
+	assert.NotNil(t, RunTask(fail.Tid))
+
+	query := LogQuery{}
+	query.Tid = fail.Tid
+	logs := waitLogs(t, query, 3)
+	assert.Len(t, logs, 3)
+	attempts := make(map[int]bool)
+	for _, l := range logs {
+		assert.Equal(t, RunFailed, l.Status)
+		attempts[l.Attempt] = true
+	}
+	assert.Equal(t, map[int]bool{1: true, 2: true, 3: true}, attempts)
+
+	// 不在 retry_on 中的失败类型不重试
+	once := newRetryTask(t, "exit 2", RetryPolicy{MaxAttempts: 3, InitialBackoff: 10, RetryOn: []string{RetryOnTimeout}})
+	assert.NotNil(t, RunTask(once.Tid))
+	query.Tid = once.Tid
+	time.Sleep(100 * time.Millisecond)
+	logs = waitLogs(t, query, 1)
+	assert.Len(t, logs, 1)
+	assert.Equal(t, 1, logs[0].Attempt)
+}
@@ -7,18 +7,24 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	standlog "log"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 var cronScheduler *CronScheduler
 
+// cronParser 调度器使用的解析器 校验表达式的时候也使用同一个
+var cronParser = cron.NewParser(
+	cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
 type CronScheduler struct {
 	mu        sync.Mutex
 	scheduler *cron.Cron
@@ -41,10 +47,13 @@ func (c *CronScheduler) GetTaskEntryId(tid string) cron.EntryID {
 	return c.tasks[tid] // if key not exists, value is zero
 }
 
-//PutTaskEntryId
+//PutTaskEntryId 已经存在的 entry 会被移除 避免重新分配和事件同时添加导致重复调度
 func (c *CronScheduler) PutTaskEntryId(tid string, id cron.EntryID) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if old, ok := c.tasks[tid]; ok && old != id {
+		c.scheduler.Remove(old)
+	}
 	c.tasks[tid] = id
 }
 
@@ -56,6 +65,7 @@ func (c *CronScheduler) RemoveTaskByTid(tid string) {
 	log.Infof("job %s, entryId is %v, now remove\n", tid, entryId)
 	if entryId != 0 {
 		c.scheduler.Remove(entryId)
+		delete(c.tasks, tid)
 	} else {
 		log.Infof("job %s, entryId is %v, 不需要进行调度器任务删除\n", tid, entryId)
 	}
@@ -70,15 +80,22 @@ func (c *CronScheduler) RemoveTask(t *Task) {
 	log.Infof("job %s-%s, entryId is %v\n", t.Tid, t.Name, entryId)
 	if entryId != 0 {
 		c.scheduler.Remove(entryId)
+		delete(c.tasks, t.Tid)
 	} else {
 		log.Infof("job %s-%s, entryId is %v, 不需要进行调度器任务删除\n", t.Tid, t.Name, entryId)
 	}
 	return
 }
 
+//AddTask 只添加分配给当前 worker 的任务 见 ownedByMe
 func (c *CronScheduler) AddTask(t *Task) error {
+	if !ownedByMe(t.Tid, t.Selector) {
+		log.Debugf("[scheduler] 任务 %s-%s 由其他 worker 调度", t.Tid, t.Name)
+		return nil
+	}
 	f := func() {
-		if e := RunTask(t.Tid); e != nil {
+		// cron 按秒触发 同一次触发在所有 worker 上得到相同的时间
+		if e := runTask(t.Tid, time.Now().Truncate(time.Second), false); e != nil && e != FireTakenErr {
 			log.Errorf("[scheduler] exec task %s err: %v", t.Tid, e)
 			// DONE: 如果 err 是没有找到 doc，则从调度器中 remove
 			if e == RunTaskNotFoundTaskErr {
@@ -89,8 +106,85 @@ func (c *CronScheduler) AddTask(t *Task) error {
 		}
 	}
 
+	if t.Delay {
+		return c.addOnce(t.Tid, t.Name, time.Unix(t.RunAt, 0), f)
+	}
+	return c.addFunc(t.Tid, t.Name, t.Timezone, t.Expression, f)
+}
+
+//AddWorkflow 添加工作流 和任务共用一个调度器 entryId 以 wid 记录
+func (c *CronScheduler) AddWorkflow(w *Workflow) error {
+	if !ownedByMe(w.Wid, TaskSelector{}) {
+		log.Debugf("[scheduler] 工作流 %s-%s 由其他 worker 调度", w.Wid, w.Name)
+		return nil
+	}
+	f := func() {
+		// 和任务一样 同一次触发只有一个 worker 执行
+		if e := runWorkflow(w.Wid, time.Now().Truncate(time.Second)); e != nil && e != FireTakenErr {
+			log.Errorf("[scheduler] exec workflow %s err: %v", w.Wid, e)
+			if e == WorkflowNotFoundErr {
+				log.Infof("[scheduler] 工作流 %s-%s 没有找到"+
+					"now remove from cron scheduler", w.Wid, w.Name)
+				c.RemoveTaskByTid(w.Wid)
+			}
+		}
+	}
+
+	return c.addFunc(w.Wid, w.Name, w.Timezone, w.Expression, f)
+}
+
+//ValidateExpression 按照调度器的方式加上时区进行解析
+func ValidateExpression(expression, timezone string) error {
+	if expression == "" {
+		return errors.New("expression is empty")
+	}
+	if _, err := cronParser.Parse(fmt.Sprintf("CRON_TZ=%s %s", timezone, expression)); err != nil {
+		return fmt.Errorf("invalid expression %s: %v", expression, err)
+	}
+	return nil
+}
+
+//PreviewExpression 从 from 开始接下来 n 次的执行时间
+func PreviewExpression(expression, timezone string, from time.Time, n int) ([]time.Time, error) {
+	if err := ValidateExpression(expression, timezone); err != nil {
+		return nil, err
+	}
+	schedule, _ := cronParser.Parse(fmt.Sprintf("CRON_TZ=%s %s", timezone, expression))
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	times := make([]time.Time, 0, n)
+	next := from
+	for i := 0; i < n; i++ {
+		next = schedule.Next(next)
+		if next.IsZero() {
+			break
+		}
+		times = append(times, next.In(loc))
+	}
+	return times, nil
+}
+
+//nextRunAt 任务的下一次执行时间 禁用或者已经完成的延迟作业为 0
+func nextRunAt(t Task, now time.Time) int64 {
+	if t.Disable || (t.Delay && t.Completed) {
+		return 0
+	}
+	if t.Delay {
+		return t.RunAt
+	}
+	times, err := PreviewExpression(t.Expression, t.Timezone, now, 1)
+	if err != nil || len(times) == 0 {
+		return 0
+	}
+	return times[0].Unix()
+}
+
+//addFunc 按照时区添加定时任务并记录 entryId
+func (c *CronScheduler) addFunc(id, name, timezone, spec string, f func()) error {
 	//加上时区的选择
-	expression := fmt.Sprintf("CRON_TZ=%s %s", t.Timezone, t.Expression)
+	expression := fmt.Sprintf("CRON_TZ=%s %s", timezone, spec)
 	entryId, err := c.scheduler.AddFunc(expression, f)
 	if err != nil {
 		log.Errorf("[scheduler] add func err: %v", err)
@@ -98,9 +192,33 @@ func (c *CronScheduler) AddTask(t *Task) error {
 	}
 
 	// 记录 entryId
-	c.PutTaskEntryId(t.Tid, entryId)
-	log.Infof("[scheduler] 添加定时任务 %s-%s, 表达式: %v, with entryID: %v", t.Tid, t.Name, expression, entryId)
+	c.PutTaskEntryId(id, entryId)
+	log.Infof("[scheduler] 添加定时任务 %s-%s, 表达式: %v, with entryID: %v", id, name, expression, entryId)
+
+	return nil
+}
+
+//onceSchedule 只触发一次的 cron.Schedule
+type onceSchedule struct {
+	at time.Time
+}
+
+//Next 触发之后返回零值 cron 不会再次触发
+func (s onceSchedule) Next(t time.Time) time.Time {
+	if t.Before(s.at) {
+		return s.at
+	}
+	return time.Time{}
+}
 
+//addOnce 延迟作业 已经过了执行时间的(例如 worker 重启期间)马上执行
+func (c *CronScheduler) addOnce(id, name string, at time.Time, f func()) error {
+	if now := time.Now(); !now.Before(at) {
+		at = now.Add(time.Second)
+	}
+	entryId := c.scheduler.Schedule(onceSchedule{at: at}, cron.FuncJob(f))
+	c.PutTaskEntryId(id, entryId)
+	log.Infof("[scheduler] 添加延迟作业 %s-%s, 执行时间: %v, with entryID: %v", id, name, at, entryId)
 	return nil
 }
 
@@ -110,9 +228,7 @@ func addScheduler() *cron.Cron {
 		cron.VerbosePrintfLogger(
 			standlog.New(os.Stdout, "[Cron]: ", standlog.LstdFlags)))
 
-	optParser := cron.WithParser(cron.NewParser(
-		cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
-	))
+	optParser := cron.WithParser(cronParser)
 
 	scheduler := cron.New(optLogs, optParser)
 	scheduler.Start() // 重复 start 也没事 幂等
@@ -123,17 +239,20 @@ func addScheduler() *cron.Cron {
 func InitScheduler() error {
 	// 初始化 cronScheduler
 	cronScheduler = NewCronScheduler(addScheduler())
+	// 先注册 worker 开启分片的时候只加载分配给自己的任务
+	m := newMembership(WorkerId())
+	if _, err := m.refresh(); err != nil {
+		log.Errorf("[scheduler] register worker err: %v", err)
+		return err
+	}
+	workerMembership = m
+	go m.run()
 	// 将任务加入时区定时器
-	tasks := make([]Task, 0)
-	cursor, err := TaskCol.Find(context.Background(), bson.M{})
+	tasks, err := DB.Task().FindAll(context.Background())
 	if err != nil {
 		log.Errorf("[scheduler] get all tasks err: %v", err)
 		return err
 	}
-	if err = cursor.All(context.Background(), &tasks); err != nil {
-		log.Errorf("[message] 加载数据失败: %v", err)
-		return err
-	}
 
 	for i := 0; i < len(tasks); i++ {
 		// 默认清空之前的状态
@@ -142,11 +261,36 @@ func InitScheduler() error {
 			log.Fatalf("[scheduler] error to init the task with error %v", err)
 		}
 	}
+
+	// 将工作流加入定时器
+	workflows, err := DB.Workflow().FindAll(context.Background())
+	if err != nil {
+		log.Errorf("[scheduler] get all workflows err: %v", err)
+		return err
+	}
+	for i := 0; i < len(workflows); i++ {
+		if err := NewInitPutWorkflow(workflows[i]); err != nil {
+			log.Fatalf("[scheduler] error to init the workflow with error %v", err)
+		}
+	}
 	return nil
 }
 
+//StopScheduler 先离开集群让其他 worker 接管任务 再停止调度器
 func StopScheduler() context.Context {
-	return cronScheduler.scheduler.Stop()
+	if workerMembership != nil {
+		workerMembership.leave()
+		workerMembership = nil
+	}
+	running := cronScheduler.scheduler.Stop()
+	// 正在执行的任务完成并且运行记录保存之后才算停止
+	stopped, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-running.Done()
+		pendingLogs.Wait()
+		cancel()
+	}()
+	return stopped
 }
 
 //SubCronJob 订阅频道用于更新任务
@@ -2,6 +2,7 @@ package storage
 
 import (
 	log "github.com/sirupsen/logrus"
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 //SchedulerDeleteTask 调度器删除对应任务
@@ -19,7 +20,7 @@ func NewSchedulerDeleteTask(t interface{}) error {
 	return nil
 }
 
-//NewInitPutTask 初始化的时候将 disable 为 false 的任务拉起
+//NewInitPutTask 初始化的时候将 disable 为 false 的任务拉起 并根据 misfire 策略补跑错过的调度
 func NewInitPutTask(t Task) error {
 	if t.Disable {
 		log.Debugf("[scheduler] 任务 %s-%s 不需要添加到定时调度器中\n", t.Tid, t.Name)
@@ -27,34 +28,132 @@ func NewInitPutTask(t Task) error {
 		err := cronScheduler.AddTask(&t)
 		if err != nil {
 			log.Errorf("[scheduler] 添加任务 %s-%s 失败 %v", t.Tid, t.Name, err)
+		} else if cronScheduler.GetTaskEntryId(t.Tid) != 0 {
+			catchUp(t)
 		}
 	}
 
 	return nil
 }
 
+//addTaskAndCatchUp 之前没有被当前 worker 调度的任务(例如重新启用)添加之后补跑错过的调度
+func addTaskAndCatchUp(t Task, scheduled bool) error {
+	if err := cronScheduler.AddTask(&t); err != nil {
+		return err
+	}
+	if !scheduled && cronScheduler.GetTaskEntryId(t.Tid) != 0 {
+		catchUp(t)
+	}
+	return nil
+}
+
 //NewSchedulerPutTask 用于程序运行过程中的 put task 支持 多 timezone
 //考虑原先时区的问题，需要进行原时区的任务删除,然后将任务新增到新时区
 func NewSchedulerModifyTask(t Task) error {
-	// 移除并重新启用
+	// 移除并重新启用 禁用的任务不需要重新启用
+	scheduled := cronScheduler.GetTaskEntryId(t.Tid) != 0
 	cronScheduler.RemoveTask(&t)
-	err := cronScheduler.AddTask(&t)
-	if err != nil {
-		return err
+	if t.Disable {
+		return nil
 	}
-	return nil
+	return addTaskAndCatchUp(t, scheduled)
 }
 
 func NewSchedulerDisableTask(t Task) error {
 	// 先移除再说
+	scheduled := cronScheduler.GetTaskEntryId(t.Tid) != 0
 	cronScheduler.RemoveTask(&t)
 	if t.Disable {
 		log.Infof("[scheduler] 成功禁用任务 %s-%s\n", t.Tid, t.Name)
 	} else {
-		err := cronScheduler.AddTask(&t)
-		if err != nil {
-			return err
+		return addTaskAndCatchUp(t, scheduled)
+	}
+	return nil
+}
+
+//handleTaskEvent 处理订阅到的任务事件 redis 和 memory 两种 cache 共用
+func handleTaskEvent(payload []byte) {
+	t := TaskEvent{}
+	if err := msgpack.Unmarshal(payload, &t); err != nil {
+		log.Errorf("[scheduler] msg 解析发生错误: %v", err)
+		return
+	}
+	log.Debugf("[scheduler] taskEvent is %v", t)
+	if t.Kind == WorkflowKind {
+		handleWorkflowEvent(t)
+		return
+	}
+	task, err := GetTask(t.Tid)
+	if err != nil {
+		log.Errorf("[scheduler] task %s not found", t.Tid)
+		if t.Event == DELETE { // 删除事件特殊处理 数据可能已经被 master 删除了
+			if err := NewSchedulerDeleteTask(t.Tid); err != nil {
+				log.Errorf("[scheduler] DELETE 事件失败 %s", err.Error())
+			}
+			log.Infof("[scheduler] DELETE %d 事件处理成功", DELETE)
+		}
+		return
+	}
+	switch t.Event {
+	case CREATE:
+		if err := NewInitPutTask(task); err != nil {
+			log.Errorf("[scheduler] CREATE 事件失败 %s", err.Error())
+		}
+		log.Infof("[scheduler] CREATE %d 事件处理成功", CREATE)
+	case MODIFY:
+		if err := NewSchedulerModifyTask(task); err != nil {
+			log.Errorf("[scheduler] MODIFY 事件失败 %s", err.Error())
+		}
+		log.Infof("[scheduler] MODIFY %d 事件处理成功", MODIFY)
+	case DISABLE:
+		if err := NewSchedulerDisableTask(task); err != nil {
+			log.Errorf("[scheduler] DISABLE 事件失败 %s", err.Error())
 		}
+		log.Infof("[scheduler] DISABLE %d 事件处理成功", DISABLE)
+	case DELETE:
+		if err := NewSchedulerDeleteTask(task); err != nil {
+			log.Errorf("[scheduler] DELETE 事件失败 %s", err.Error())
+		}
+		log.Infof("[scheduler] DELETE %d 事件处理成功", DELETE)
+	default:
+		log.Errorf("[scheduler] 没有对应的类型")
+	}
+}
+
+//NewInitPutWorkflow 将 disable 为 false 的工作流拉起
+func NewInitPutWorkflow(w Workflow) error {
+	if w.Disable {
+		log.Debugf("[scheduler] 工作流 %s-%s 不需要添加到定时调度器中\n", w.Wid, w.Name)
+		return nil
+	}
+	if err := cronScheduler.AddWorkflow(&w); err != nil {
+		log.Errorf("[scheduler] 添加工作流 %s-%s 失败 %v", w.Wid, w.Name, err)
 	}
 	return nil
 }
+
+//handleWorkflowEvent 处理工作流事件
+func handleWorkflowEvent(t TaskEvent) {
+	w, err := GetWorkflow(t.Tid)
+	if err != nil {
+		log.Errorf("[scheduler] workflow %s not found", t.Tid)
+		if t.Event == DELETE { // 数据可能已经被 master 删除了
+			cronScheduler.RemoveTaskByTid(t.Tid)
+		}
+		return
+	}
+	switch t.Event {
+	case CREATE:
+		_ = NewInitPutWorkflow(w)
+	case MODIFY, DISABLE:
+		// 先移除 再根据 disable 重新拉起
+		cronScheduler.RemoveTaskByTid(w.Wid)
+		_ = NewInitPutWorkflow(w)
+	case DELETE:
+		cronScheduler.RemoveTaskByTid(w.Wid)
+	default:
+		log.Errorf("[scheduler] 没有对应的类型")
+		return
+	}
+	log.Infof("[scheduler] workflow %d 事件处理成功", t.Event)
+}
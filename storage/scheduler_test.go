@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviewExpression(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	from := time.Date(2020, 7, 19, 12, 0, 0, 0, loc)
+
+	times, err := PreviewExpression("0 0 * * *", "Asia/Shanghai", from, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Time{
+		time.Date(2020, 7, 20, 0, 0, 0, 0, loc),
+		time.Date(2020, 7, 21, 0, 0, 0, 0, loc),
+	}, times)
+
+	// 支持秒以及 descriptor
+	times, err = PreviewExpression("*/30 * * * * *", "Asia/Shanghai", from, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, from.Add(30*time.Second), times[0])
+	times, err = PreviewExpression("@every 1h", "Asia/Shanghai", from, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, from.Add(time.Hour), times[0])
+
+	_, err = PreviewExpression("61 * * * *", "Asia/Shanghai", from, 1)
+	assert.NotNil(t, err)
+	_, err = PreviewExpression("0 0 * * *", "Mars/Olympus", from, 1)
+	assert.NotNil(t, err)
+}
+
+func TestTaskNextRunAt(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+
+	task := newBashTask(t, "date", false)
+	got, err := GetTask(task.Tid)
+	assert.Nil(t, err)
+	times, _ := PreviewExpression(task.Expression, task.Timezone, time.Now(), 1)
+	assert.Equal(t, times[0].Unix(), got.NextRunAt)
+
+	// 修改表达式的时候同样校验
+	assert.NotNil(t, ModifyTask(task.Tid, map[string]interface{}{"expression": "0 0 32 * *"}))
+
+	assert.Nil(t, DisableTask(task.Tid, map[string]interface{}{"disable": true}))
+	got, err = GetTask(task.Tid)
+	assert.Nil(t, err)
+	assert.Zero(t, got.NextRunAt)
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// http 任务签名使用的请求头 接收方使用 app 的 secret_key 校验
+const (
+	HeaderAppKey        = "X-Clock-App-Key"
+	HeaderTimestamp     = "X-Clock-Timestamp"
+	HeaderNonce         = "X-Clock-Nonce"
+	HeaderContentSHA256 = "X-Clock-Content-SHA256"
+	HeaderSignature     = "X-Clock-Signature"
+)
+
+// 签名的用途 作为待签名字符串的第一行 http 任务请求的签名不能用于调用 api 反之亦然
+const (
+	SignScopeTask = "CLOCK-TASK"
+	SignScopeAPI  = "CLOCK-API"
+)
+
+//randomHex 生成 n 个字节的随机数 以 hex 编码返回
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//genAppKeys 生成 app_key 以及 secret_key
+func genAppKeys() (appKey, secretKey string, err error) {
+	if appKey, err = randomHex(16); err != nil {
+		return "", "", err
+	}
+	if secretKey, err = randomHex(32); err != nil {
+		return "", "", err
+	}
+	return appKey, secretKey, nil
+}
+
+//ContentSHA256 请求体的 sha256 hex 编码 没有请求体的时候为空字符串的 hash
+func ContentSHA256(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+//StringToSign 待签名的字符串 scope method host 请求路径(包括 query) timestamp nonce 以及请求体 hash 每一项之间用换行分隔
+//签名绑定到具体的服务以及接口 同一个 app 的签名不能被转发到其他服务使用
+func StringToSign(scope, method, host, uri, timestamp, nonce, contentSHA256 string) string {
+	return strings.Join([]string{scope, strings.ToUpper(method), strings.ToLower(host), uri, timestamp, nonce, contentSHA256}, "\n")
+}
+
+//Sign 使用 secret_key 对待签名的字符串做 HMAC-SHA256 签名
+func Sign(secretKey, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//requestTarget 请求的 host 以及路径 发送方和接收方计算的结果相同
+func requestTarget(r *http.Request) (string, string) {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	return host, r.URL.RequestURI()
+}
+
+//requestStringToSign 根据请求以及请求头中的 timestamp nonce 和请求体 hash 计算待签名的字符串
+func requestStringToSign(scope string, r *http.Request, contentSHA256 string) string {
+	host, uri := requestTarget(r)
+	return StringToSign(scope, r.Method, host, uri, r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderNonce), contentSHA256)
+}
+
+//VerifySignature 校验接收到的 http 任务请求的签名 body 为请求体 不校验时间戳是否过期以及 nonce 是否重复
+//服务在反向代理之后的时候需要保留原始的 Host 请求头
+func VerifySignature(secretKey string, r *http.Request, body []byte) bool {
+	return verifySignature(SignScopeTask, secretKey, r, body)
+}
+
+func verifySignature(scope, secretKey string, r *http.Request, body []byte) bool {
+	contentSHA256 := ContentSHA256(body)
+	if r.Header.Get(HeaderContentSHA256) != contentSHA256 {
+		return false
+	}
+	expected := Sign(secretKey, requestStringToSign(scope, r, contentSHA256))
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get(HeaderSignature)))
+}
+
+//signHTTPRequest 使用 app 的密钥对 http 任务的请求签名
+func signHTTPRequest(req *http.Request, aid string, body []byte) error {
+	appKey, secretKey, err := GetAppKeyAndSecretKey(context.Background(), aid)
+	if err != nil {
+		return err
+	}
+	// 空的密钥签名任何人都可以伪造
+	if secretKey == "" {
+		return AppNoSecretErr
+	}
+	return signRequest(req, SignScopeTask, appKey, secretKey, body)
+}
+
+//signRequest 为请求加上签名相关的请求头 scope 为签名的用途
+func signRequest(req *http.Request, scope, appKey, secretKey string, body []byte) error {
+	nonce, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	contentSHA256 := ContentSHA256(body)
+	req.Header.Set(HeaderAppKey, appKey)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderContentSHA256, contentSHA256)
+	req.Header.Set(HeaderSignature, Sign(secretKey, requestStringToSign(scope, req, contentSHA256)))
+	return nil
+}
@@ -0,0 +1,821 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"           // postgres driver
+	_ "github.com/mattn/go-sqlite3" // sqlite driver
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 支持的 sql 方言
+const (
+	SQLite   = "sqlite3"
+	Postgres = "postgres"
+)
+
+// sqlMigrations 数据库迁移 只能追加 不能修改已经发布的迁移
+// 下标 + 1 即为迁移版本号，记录在 schema_migrations 中
+var sqlMigrations = []string{
+	`CREATE TABLE task (
+		tid        VARCHAR(24) PRIMARY KEY,
+		name       TEXT NOT NULL DEFAULT '',
+		disable    BOOLEAN NOT NULL DEFAULT FALSE,
+		timeout    INTEGER NOT NULL DEFAULT 0,
+		create_at  BIGINT NOT NULL DEFAULT 0,
+		update_at  BIGINT NOT NULL DEFAULT 0,
+		log_enable BOOLEAN NOT NULL DEFAULT FALSE,
+		expression TEXT NOT NULL DEFAULT '',
+		delay      BOOLEAN NOT NULL DEFAULT FALSE,
+		timezone   TEXT NOT NULL DEFAULT '',
+		payload    TEXT NOT NULL DEFAULT 'null',
+		type       TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE task_log (
+		lid       VARCHAR(24) PRIMARY KEY,
+		tid       VARCHAR(24) NOT NULL DEFAULT '',
+		std_out   TEXT NOT NULL DEFAULT '',
+		std_err   TEXT NOT NULL DEFAULT '',
+		start_at  BIGINT NOT NULL DEFAULT 0,
+		end_at    BIGINT NOT NULL DEFAULT 0,
+		create_at BIGINT NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX idx_task_log_tid ON task_log (tid)`,
+	`CREATE INDEX idx_task_log_create_at ON task_log (create_at)`,
+	`CREATE TABLE timezone (
+		tid       VARCHAR(24) PRIMARY KEY,
+		value     TEXT NOT NULL UNIQUE,
+		label     TEXT NOT NULL DEFAULT '',
+		create_at BIGINT NOT NULL DEFAULT 0,
+		update_at BIGINT NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE app (
+		aid          VARCHAR(24) PRIMARY KEY,
+		is_deleted   BIGINT NOT NULL DEFAULT 0,
+		valid_period BIGINT NOT NULL DEFAULT 0,
+		secret_key   TEXT NOT NULL DEFAULT '',
+		app_key      TEXT NOT NULL DEFAULT '',
+		app_name     TEXT NOT NULL DEFAULT '',
+		last_used    BIGINT NOT NULL DEFAULT 0,
+		counter      BIGINT NOT NULL DEFAULT 0,
+		create_at    BIGINT NOT NULL DEFAULT 0,
+		update_at    BIGINT NOT NULL DEFAULT 0
+	)`,
+	`ALTER TABLE task_log ADD COLUMN status TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE task_log ADD COLUMN code INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE task_log ADD COLUMN worker TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE task_log ADD COLUMN duration BIGINT NOT NULL DEFAULT 0`,
+	`CREATE INDEX idx_task_log_status ON task_log (status)`,
+	`ALTER TABLE task ADD COLUMN retry TEXT NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE task_log ADD COLUMN attempt INTEGER NOT NULL DEFAULT 1`,
+	`CREATE TABLE workflow (
+		wid        VARCHAR(24) PRIMARY KEY,
+		name       TEXT NOT NULL DEFAULT '',
+		disable    BOOLEAN NOT NULL DEFAULT FALSE,
+		expression TEXT NOT NULL DEFAULT '',
+		timezone   TEXT NOT NULL DEFAULT '',
+		nodes      TEXT NOT NULL DEFAULT '[]',
+		edges      TEXT NOT NULL DEFAULT '[]',
+		create_at  BIGINT NOT NULL DEFAULT 0,
+		update_at  BIGINT NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE workflow_run (
+		rid       VARCHAR(24) PRIMARY KEY,
+		wid       VARCHAR(24) NOT NULL DEFAULT '',
+		status    TEXT NOT NULL DEFAULT '',
+		nodes     TEXT NOT NULL DEFAULT '{}',
+		worker    TEXT NOT NULL DEFAULT '',
+		start_at  BIGINT NOT NULL DEFAULT 0,
+		end_at    BIGINT NOT NULL DEFAULT 0,
+		duration  BIGINT NOT NULL DEFAULT 0,
+		create_at BIGINT NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX idx_workflow_run_wid ON workflow_run (wid)`,
+	`ALTER TABLE task ADD COLUMN run_at BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE task ADD COLUMN completed BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE task ADD COLUMN output_limit INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE task ADD COLUMN stream_output BOOLEAN NOT NULL DEFAULT FALSE`,
+	`CREATE INDEX idx_app_app_key ON app (app_key)`,
+	`ALTER TABLE task ADD COLUMN aid VARCHAR(24) NOT NULL DEFAULT ''`,
+	`CREATE INDEX idx_task_aid ON task (aid)`,
+	`ALTER TABLE workflow ADD COLUMN aid VARCHAR(24) NOT NULL DEFAULT ''`,
+	`ALTER TABLE app ADD COLUMN role TEXT NOT NULL DEFAULT 'operator'`,
+	`ALTER TABLE task ADD COLUMN created_by TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE task ADD COLUMN updated_by TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE audit_log (
+		lid       VARCHAR(24) PRIMARY KEY,
+		tid       VARCHAR(24) NOT NULL DEFAULT '',
+		aid       VARCHAR(24) NOT NULL DEFAULT '',
+		actor     TEXT NOT NULL DEFAULT '',
+		action    TEXT NOT NULL DEFAULT '',
+		diff      TEXT NOT NULL DEFAULT '{}',
+		ip        TEXT NOT NULL DEFAULT '',
+		create_at BIGINT NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX idx_audit_log_tid ON audit_log (tid)`,
+	`CREATE INDEX idx_audit_log_create_at ON audit_log (create_at)`,
+	`ALTER TABLE task ADD COLUMN selector TEXT NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE task ADD COLUMN concurrency TEXT NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE task ADD COLUMN misfire TEXT NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE task ADD COLUMN last_fire_at BIGINT NOT NULL DEFAULT 0`,
+}
+
+//SQLDatabase sqlite/postgres 存储实现
+type SQLDatabase struct {
+	DB      *sql.DB
+	dialect string
+
+	task     *sqlTaskRepository
+	taskLog  *sqlTaskLogRepository
+	timezone *sqlTimezoneRepository
+	app      *sqlAppRepository
+	workflow *sqlWorkflowRepository
+	run      *sqlWorkflowRunRepository
+	audit    *sqlAuditRepository
+}
+
+//NewSQLDatabase 连接数据库并执行迁移 dialect 为 sqlite3 或者 postgres
+func NewSQLDatabase(dialect, dsn string) (*SQLDatabase, error) {
+	if dialect != SQLite && dialect != Postgres {
+		return nil, fmt.Errorf("不支持的 sql dialect: %s", dialect)
+	}
+	db, err := sql.Open(dialect, dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "[db] sql open err")
+	}
+	if dialect == SQLite {
+		// sqlite 不支持并发写
+		db.SetMaxOpenConns(1)
+	}
+	if err = db.Ping(); err != nil {
+		return nil, errors.Wrap(err, "[db] sql ping err")
+	}
+
+	s := &SQLDatabase{DB: db, dialect: dialect}
+	if err = s.migrate(context.Background()); err != nil {
+		return nil, err
+	}
+
+	s.task = &sqlTaskRepository{db: s, table: newSQLTable("task", "tid", Task{})}
+	s.taskLog = &sqlTaskLogRepository{db: s, table: newSQLTable("task_log", "lid", TaskLog{})}
+	s.timezone = &sqlTimezoneRepository{db: s, table: newSQLTable("timezone", "tid", Timezone{})}
+	s.app = &sqlAppRepository{db: s, table: newSQLTable("app", "aid", App{})}
+	s.workflow = &sqlWorkflowRepository{db: s, table: newSQLTable("workflow", "wid", Workflow{})}
+	s.run = &sqlWorkflowRunRepository{db: s, table: newSQLTable("workflow_run", "rid", WorkflowRun{})}
+	s.audit = &sqlAuditRepository{db: s, table: newSQLTable("audit_log", "lid", AuditLog{})}
+
+	return s, nil
+}
+
+func (s *SQLDatabase) Task() TaskRepository {
+	return s.task
+}
+
+func (s *SQLDatabase) TaskLog() TaskLogRepository {
+	return s.taskLog
+}
+
+func (s *SQLDatabase) Timezone() TimezoneRepository {
+	return s.timezone
+}
+
+func (s *SQLDatabase) App() AppRepository {
+	return s.app
+}
+
+func (s *SQLDatabase) Workflow() WorkflowRepository {
+	return s.workflow
+}
+
+func (s *SQLDatabase) WorkflowRun() WorkflowRunRepository {
+	return s.run
+}
+
+func (s *SQLDatabase) Audit() AuditRepository {
+	return s.audit
+}
+
+func (s *SQLDatabase) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
+func (s *SQLDatabase) Close(ctx context.Context) error {
+	return s.DB.Close()
+}
+
+//migrate 执行尚未执行的迁移
+func (s *SQLDatabase) migrate(ctx context.Context) error {
+	if _, err := s.DB.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return errors.Wrap(err, "[db] create schema_migrations err")
+	}
+
+	var version int
+	if err := s.DB.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return errors.Wrap(err, "[db] get schema version err")
+	}
+
+	for i := version; i < len(sqlMigrations); i++ {
+		tx, err := s.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, sqlMigrations[i]); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrap(err, fmt.Sprintf("[db] migrate version %d err", i+1))
+		}
+		if _, err = tx.ExecContext(ctx, s.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), i+1); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+		log.Infof("[db] migrate to version %d", i+1)
+	}
+	return nil
+}
+
+//rebind 将 ? 占位符转为 postgres 的 $n
+func (s *SQLDatabase) rebind(query string) string {
+	if s.dialect != Postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+//sqlTable 结构体和表的映射 列名取自 bson tag
+//map/slice/struct 等复杂类型以 json 形式存储
+type sqlTable struct {
+	name    string
+	pk      string
+	typ     reflect.Type
+	columns []string
+	fields  map[string]int // column -> field index
+}
+
+func newSQLTable(name, pk string, model interface{}) *sqlTable {
+	t := &sqlTable{
+		name:   name,
+		pk:     pk,
+		typ:    reflect.TypeOf(model),
+		fields: make(map[string]int),
+	}
+	for i := 0; i < t.typ.NumField(); i++ {
+		column := strings.Split(t.typ.Field(i).Tag.Get("bson"), ",")[0]
+		if column == "" || column == "-" || column == "_id" {
+			continue
+		}
+		t.columns = append(t.columns, column)
+		t.fields[column] = i
+	}
+	return t
+}
+
+func (t *sqlTable) hasColumn(column string) bool {
+	_, ok := t.fields[column]
+	return ok
+}
+
+//values 取出结构体中各列对应的值
+func (t *sqlTable) values(v interface{}) ([]interface{}, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	values := make([]interface{}, 0, len(t.columns))
+	for _, column := range t.columns {
+		value, err := sqlValue(rv.Field(t.fields[column]).Interface())
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("column %s", column))
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+//sqlValue 复杂类型转为 json 字符串
+func sqlValue(v interface{}) (interface{}, error) {
+	if v == nil {
+		return "null", nil
+	}
+	switch reflect.TypeOf(v).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Struct, reflect.Ptr, reflect.Interface, reflect.Array:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+	return v, nil
+}
+
+//scan 将一行数据写入新的结构体中
+func (t *sqlTable) scan(rows *sql.Rows) (reflect.Value, error) {
+	raws := make([]interface{}, len(t.columns))
+	dest := make([]interface{}, len(t.columns))
+	for i := range raws {
+		dest[i] = &raws[i]
+	}
+	rv := reflect.New(t.typ).Elem()
+	if err := rows.Scan(dest...); err != nil {
+		return rv, err
+	}
+	for i, column := range t.columns {
+		if err := setSQLField(rv.Field(t.fields[column]), raws[i]); err != nil {
+			return rv, errors.Wrap(err, fmt.Sprintf("column %s", column))
+		}
+	}
+	// 主键即为 ObjectID 的 hex
+	if id := rv.FieldByName("Id"); id.IsValid() && id.Type() == reflect.TypeOf(primitive.ObjectID{}) {
+		if oid, err := primitive.ObjectIDFromHex(rv.Field(t.fields[t.pk]).String()); err == nil {
+			id.Set(reflect.ValueOf(oid))
+		}
+	}
+	return rv, nil
+}
+
+//setSQLField 将数据库驱动返回的值写入字段
+func setSQLField(field reflect.Value, raw interface{}) error {
+	if raw == nil {
+		return nil
+	}
+	if b, ok := raw.([]byte); ok {
+		raw = string(b)
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(fmt.Sprintf("%v", raw))
+	case reflect.Bool:
+		switch b := raw.(type) {
+		case bool:
+			field.SetBool(b)
+		case int64:
+			field.SetBool(b != 0)
+		case string:
+			field.SetBool(b == "1" || b == "true" || b == "t")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch n := raw.(type) {
+		case int64:
+			field.SetInt(n)
+		case float64:
+			field.SetInt(int64(n))
+		case string:
+			i, err := strconv.ParseInt(n, 10, 64)
+			if err != nil {
+				return err
+			}
+			field.SetInt(i)
+		}
+	case reflect.Float32, reflect.Float64:
+		switch n := raw.(type) {
+		case float64:
+			field.SetFloat(n)
+		case int64:
+			field.SetFloat(float64(n))
+		}
+	default:
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("unexpected value %v", raw)
+		}
+		return json.Unmarshal([]byte(s), field.Addr().Interface())
+	}
+	return nil
+}
+
+//where 将 GetWhereDb 生成的条件转为 sql 只支持相等以及 $gt $lt
+func (t *sqlTable) where(filter bson.D) (string, []interface{}, error) {
+	conds := make([]string, 0, len(filter))
+	args := make([]interface{}, 0, len(filter))
+	for _, e := range filter {
+		if !t.hasColumn(e.Key) {
+			return "", nil, fmt.Errorf("unknown column %s", e.Key)
+		}
+		switch cond := e.Value.(type) {
+		case bson.D:
+			for _, c := range cond {
+				switch c.Key {
+				case "$gt":
+					conds = append(conds, e.Key+" > ?")
+				case "$lt":
+					conds = append(conds, e.Key+" < ?")
+				default:
+					return "", nil, fmt.Errorf("unsupported operator %s", c.Key)
+				}
+				args = append(args, c.Value)
+			}
+		default:
+			conds = append(conds, e.Key+" = ?")
+			args = append(args, cond)
+		}
+	}
+	if len(conds) == 0 {
+		return "", args, nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args, nil
+}
+
+func (s *SQLDatabase) insert(ctx context.Context, t *sqlTable, v interface{}) error {
+	values, err := t.values(v)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		t.name, strings.Join(t.columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(t.columns)), ", "))
+	if _, err = s.DB.ExecContext(ctx, s.rebind(query), values...); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("insert %s err", t.name))
+	}
+	return nil
+}
+
+//find 查询数据写入 dest(切片指针) limit 小于 1 不分页
+func (s *SQLDatabase) find(ctx context.Context, t *sqlTable, dest interface{}, filter bson.D, order string, limit, offset int) error {
+	where, args, err := t.where(filter)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s%s", strings.Join(t.columns, ", "), t.name, where)
+	if order != "" {
+		query += " ORDER BY " + order
+	}
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+	}
+	rows, err := s.DB.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("query %s err", t.name))
+	}
+	defer rows.Close()
+
+	slice := reflect.ValueOf(dest).Elem()
+	for rows.Next() {
+		rv, err := t.scan(rows)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("scan %s err", t.name))
+		}
+		slice.Set(reflect.Append(slice, rv))
+	}
+	return rows.Err()
+}
+
+//findOne 根据主键查询 dest 为结构体指针
+func (s *SQLDatabase) findOne(ctx context.Context, t *sqlTable, dest interface{}, id string) error {
+	slice := reflect.New(reflect.SliceOf(t.typ))
+	if err := s.find(ctx, t, slice.Interface(), bson.D{{Key: t.pk, Value: id}}, "", 0, 0); err != nil {
+		return err
+	}
+	if slice.Elem().Len() == 0 {
+		return NotFoundErr
+	}
+	reflect.ValueOf(dest).Elem().Set(slice.Elem().Index(0))
+	return nil
+}
+
+func (s *SQLDatabase) count(ctx context.Context, t *sqlTable, filter bson.D) (int, error) {
+	where, args, err := t.where(filter)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s%s", t.name, where)
+	if err = s.DB.QueryRowContext(ctx, s.rebind(query), args...).Scan(&count); err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("count %s err", t.name))
+	}
+	return count, nil
+}
+
+//update 根据主键更新部分字段 key 为 bson tag
+func (s *SQLDatabase) update(ctx context.Context, t *sqlTable, id string, fields map[string]interface{}) error {
+	sets := make([]string, 0, len(fields))
+	args := make([]interface{}, 0, len(fields)+1)
+	for column, value := range fields {
+		if !t.hasColumn(column) {
+			return fmt.Errorf("unknown column %s", column)
+		}
+		v, err := sqlValue(value)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("column %s", column))
+		}
+		sets = append(sets, column+" = ?")
+		args = append(args, v)
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+	args = append(args, id)
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", t.name, strings.Join(sets, ", "), t.pk)
+	res, err := s.DB.ExecContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("update %s %s err", t.name, id))
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return NotFoundErr
+	}
+	return nil
+}
+
+func (s *SQLDatabase) delete(ctx context.Context, t *sqlTable, filter bson.D) (int64, error) {
+	where, args, err := t.where(filter)
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.DB.ExecContext(ctx, s.rebind(fmt.Sprintf("DELETE FROM %s%s", t.name, where)), args...)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("delete %s err", t.name))
+	}
+	return res.RowsAffected()
+}
+
+// 任务表
+type sqlTaskRepository struct {
+	db    *SQLDatabase
+	table *sqlTable
+}
+
+func (r *sqlTaskRepository) Find(ctx context.Context, query *TaskQuery) ([]Task, error) {
+	tasks := make([]Task, 0)
+	filter := GetWhereDb(query, nil)
+	count, err := r.db.count(ctx, r.table, filter)
+	if err != nil {
+		return tasks, err
+	}
+	query.Total = count
+
+	order := ""
+	if query.Order != "" {
+		if !r.table.hasColumn(query.Order) {
+			return tasks, fmt.Errorf("unknown order column %s", query.Order)
+		}
+		order = query.Order + " DESC"
+	}
+	err = r.db.find(ctx, r.table, &tasks, filter, order, query.Count, (query.Index-1)*query.Count)
+	return tasks, err
+}
+
+func (r *sqlTaskRepository) FindAll(ctx context.Context) ([]Task, error) {
+	tasks := make([]Task, 0)
+	err := r.db.find(ctx, r.table, &tasks, nil, "create_at", 0, 0)
+	return tasks, err
+}
+
+func (r *sqlTaskRepository) FindOne(ctx context.Context, tid string) (Task, error) {
+	var t Task
+	err := r.db.findOne(ctx, r.table, &t, tid)
+	return t, err
+}
+
+func (r *sqlTaskRepository) Insert(ctx context.Context, t *Task) error {
+	return r.db.insert(ctx, r.table, t)
+}
+
+func (r *sqlTaskRepository) Update(ctx context.Context, tid string, fields map[string]interface{}) error {
+	return r.db.update(ctx, r.table, tid, fields)
+}
+
+func (r *sqlTaskRepository) Delete(ctx context.Context, tid string) error {
+	n, err := r.db.delete(ctx, r.table, bson.D{{Key: "tid", Value: tid}})
+	if err == nil && n == 0 {
+		return NotFoundErr
+	}
+	return err
+}
+
+// 任务日志表
+type sqlTaskLogRepository struct {
+	db    *SQLDatabase
+	table *sqlTable
+}
+
+func (r *sqlTaskLogRepository) Find(ctx context.Context, query *LogQuery) ([]TaskLog, error) {
+	logs := make([]TaskLog, 0)
+	filter := logWhereDb(query)
+	count, err := r.db.count(ctx, r.table, filter)
+	if err != nil {
+		return logs, err
+	}
+	query.Total = count
+
+	err = r.db.find(ctx, r.table, &logs, filter, "create_at DESC", query.Count, (query.Index-1)*query.Count)
+	return logs, err
+}
+
+func (r *sqlTaskLogRepository) FindOne(ctx context.Context, lid string) (TaskLog, error) {
+	var l TaskLog
+	err := r.db.findOne(ctx, r.table, &l, lid)
+	return l, err
+}
+
+func (r *sqlTaskLogRepository) Insert(ctx context.Context, l *TaskLog) error {
+	return r.db.insert(ctx, r.table, l)
+}
+
+func (r *sqlTaskLogRepository) Update(ctx context.Context, lid string, fields map[string]interface{}) error {
+	return r.db.update(ctx, r.table, lid, fields)
+}
+
+func (r *sqlTaskLogRepository) Delete(ctx context.Context, query *LogQuery) (int64, error) {
+	return r.db.delete(ctx, r.table, logWhereDb(query))
+}
+
+// 时区表
+type sqlTimezoneRepository struct {
+	db    *SQLDatabase
+	table *sqlTable
+}
+
+func (r *sqlTimezoneRepository) FindAll(ctx context.Context) ([]Timezone, error) {
+	timezones := make([]Timezone, 0)
+	err := r.db.find(ctx, r.table, &timezones, nil, "label DESC", 0, 0)
+	return timezones, err
+}
+
+func (r *sqlTimezoneRepository) FindOne(ctx context.Context, tid string) (Timezone, error) {
+	var t Timezone
+	err := r.db.findOne(ctx, r.table, &t, tid)
+	return t, err
+}
+
+func (r *sqlTimezoneRepository) Insert(ctx context.Context, t *Timezone) error {
+	return r.db.insert(ctx, r.table, t)
+}
+
+func (r *sqlTimezoneRepository) Delete(ctx context.Context, tid string) error {
+	n, err := r.db.delete(ctx, r.table, bson.D{{Key: "tid", Value: tid}})
+	if err == nil && n == 0 {
+		return NotFoundErr
+	}
+	return err
+}
+
+// 应用表
+type sqlAppRepository struct {
+	db    *SQLDatabase
+	table *sqlTable
+}
+
+func (r *sqlAppRepository) FindAll(ctx context.Context) ([]App, error) {
+	apps := make([]App, 0)
+	if err := r.db.find(ctx, r.table, &apps, nil, "create_at DESC", 0, 0); err != nil {
+		return apps, err
+	}
+	// filter appKey and secretKey
+	for i := range apps {
+		apps[i].AppKey = ""
+		apps[i].SecretKey = ""
+	}
+	return apps, nil
+}
+
+func (r *sqlAppRepository) FindOne(ctx context.Context, aid string) (App, error) {
+	var a App
+	err := r.db.findOne(ctx, r.table, &a, aid)
+	return a, err
+}
+
+func (r *sqlAppRepository) FindByAppKey(ctx context.Context, appKey string) (App, error) {
+	apps := make([]App, 0, 1)
+	if err := r.db.find(ctx, r.table, &apps, bson.D{{Key: "app_key", Value: appKey}}, "", 1, 0); err != nil {
+		return App{}, err
+	}
+	if len(apps) == 0 {
+		return App{}, NotFoundErr
+	}
+	return apps[0], nil
+}
+
+func (r *sqlAppRepository) Insert(ctx context.Context, a *App) error {
+	return r.db.insert(ctx, r.table, a)
+}
+
+func (r *sqlAppRepository) Update(ctx context.Context, aid string, fields map[string]interface{}) error {
+	return r.db.update(ctx, r.table, aid, fields)
+}
+
+//Touch counter 在数据库中加一 避免并发请求互相覆盖
+func (r *sqlAppRepository) Touch(ctx context.Context, aid string, lastUsed int64) error {
+	query := fmt.Sprintf("UPDATE %s SET last_used = ?, counter = counter + 1 WHERE %s = ?", r.table.name, r.table.pk)
+	res, err := r.db.DB.ExecContext(ctx, r.db.rebind(query), lastUsed, aid)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("touch app %s err", aid))
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return NotFoundErr
+	}
+	return nil
+}
+
+// 工作流表
+type sqlWorkflowRepository struct {
+	db    *SQLDatabase
+	table *sqlTable
+}
+
+func (r *sqlWorkflowRepository) Find(ctx context.Context, query *WorkflowQuery) ([]Workflow, error) {
+	workflows := make([]Workflow, 0)
+	filter := GetWhereDb(query, nil)
+	count, err := r.db.count(ctx, r.table, filter)
+	if err != nil {
+		return workflows, err
+	}
+	query.Total = count
+
+	err = r.db.find(ctx, r.table, &workflows, filter, "create_at DESC", query.Count, (query.Index-1)*query.Count)
+	return workflows, err
+}
+
+func (r *sqlWorkflowRepository) FindAll(ctx context.Context) ([]Workflow, error) {
+	workflows := make([]Workflow, 0)
+	err := r.db.find(ctx, r.table, &workflows, nil, "create_at", 0, 0)
+	return workflows, err
+}
+
+func (r *sqlWorkflowRepository) FindOne(ctx context.Context, wid string) (Workflow, error) {
+	var w Workflow
+	err := r.db.findOne(ctx, r.table, &w, wid)
+	return w, err
+}
+
+func (r *sqlWorkflowRepository) Insert(ctx context.Context, w *Workflow) error {
+	return r.db.insert(ctx, r.table, w)
+}
+
+func (r *sqlWorkflowRepository) Update(ctx context.Context, wid string, fields map[string]interface{}) error {
+	return r.db.update(ctx, r.table, wid, fields)
+}
+
+func (r *sqlWorkflowRepository) Delete(ctx context.Context, wid string) error {
+	n, err := r.db.delete(ctx, r.table, bson.D{{Key: "wid", Value: wid}})
+	if err == nil && n == 0 {
+		return NotFoundErr
+	}
+	return err
+}
+
+// 工作流运行记录表
+type sqlWorkflowRunRepository struct {
+	db    *SQLDatabase
+	table *sqlTable
+}
+
+func (r *sqlWorkflowRunRepository) Find(ctx context.Context, query *WorkflowRunQuery) ([]WorkflowRun, error) {
+	runs := make([]WorkflowRun, 0)
+	filter := GetWhereDb(query, []string{"rid"})
+	count, err := r.db.count(ctx, r.table, filter)
+	if err != nil {
+		return runs, err
+	}
+	query.Total = count
+
+	err = r.db.find(ctx, r.table, &runs, filter, "create_at DESC", query.Count, (query.Index-1)*query.Count)
+	return runs, err
+}
+
+func (r *sqlWorkflowRunRepository) Insert(ctx context.Context, run *WorkflowRun) error {
+	return r.db.insert(ctx, r.table, run)
+}
+
+func (r *sqlWorkflowRunRepository) Update(ctx context.Context, rid string, fields map[string]interface{}) error {
+	return r.db.update(ctx, r.table, rid, fields)
+}
+
+// 审计日志表
+type sqlAuditRepository struct {
+	db    *SQLDatabase
+	table *sqlTable
+}
+
+func (r *sqlAuditRepository) Find(ctx context.Context, query *AuditQuery) ([]AuditLog, error) {
+	audits := make([]AuditLog, 0)
+	filter := auditWhereDb(query)
+	count, err := r.db.count(ctx, r.table, filter)
+	if err != nil {
+		return audits, err
+	}
+	query.Total = count
+
+	err = r.db.find(ctx, r.table, &audits, filter, "create_at DESC", query.Count, (query.Index-1)*query.Count)
+	return audits, err
+}
+
+func (r *sqlAuditRepository) Insert(ctx context.Context, a *AuditLog) error {
+	return r.db.insert(ctx, r.table, a)
+}
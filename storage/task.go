@@ -1,23 +1,21 @@
 package storage
 
 import (
+	"bytes"
 	"clock/v3/config"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/vmihailenco/msgpack/v5"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 //GetTasks 取出所有任务 默认前 10
 func GetTasks(query *TaskQuery) ([]Task, error) {
-	var err error
-	tasks := make([]Task, 0)
-
 	if query.Count < 1 {
 		query.Count = 10
 	}
@@ -26,55 +24,32 @@ func GetTasks(query *TaskQuery) ([]Task, error) {
 		query.Index = 1
 	}
 
-	queryDB := GetWhereDb(query, nil)
-	count, err := TaskCol.CountDocuments(context.Background(), queryDB.Map())
-	if err != nil {
-		log.Errorf("[model] failed to get the page total of tasks : %v", err.Error())
-		return tasks, err
-	}
-	query.Total = int(count)
-
-	opts := options.Find()
-
-	if query.Order != "" {
-		opts = opts.SetSort(bson.D{{query.Order, DESC}}) // TODO: 默认 desc
-	}
-	opts = opts.SetSkip(int64((query.Index - 1) * query.Count)).SetLimit(int64(query.Count))
-
-	cursor, err := TaskCol.Find(context.Background(), queryDB.Map(), opts)
+	tasks, err := DB.Task().Find(context.Background(), query)
 	if err != nil {
 		log.Errorf("[model] get tasks err: %v", err)
 		return tasks, err
 	}
-	if err = cursor.All(context.Background(), &tasks); err != nil {
-		log.Errorf("[model] decode all tasks err: %v", err)
-		return tasks, err
+	now := time.Now()
+	for i := range tasks {
+		tasks[i].NextRunAt = nextRunAt(tasks[i], now)
 	}
 
 	return tasks, nil
 }
 
-//GetTask 根据 ObjectId 进行查询
+//GetTask 根据 tid 进行查询
 func GetTask(tid string) (Task, error) {
-	var t Task
-
-	oid, err := primitive.ObjectIDFromHex(tid)
+	t, err := DB.Task().FindOne(context.Background(), tid)
 	if err != nil {
 		log.Errorf("[model] get task %s err:%v", tid, err)
 		return t, err
 	}
-
-	if err = TaskCol.
-		FindOne(context.Background(), bson.D{{"_id", oid}}).
-		Decode(&t); err != nil {
-		log.Errorf("[model] decode task %s err:%v", tid, err)
-		return t, err
-	}
+	t.NextRunAt = nextRunAt(t, time.Now())
 	return t, nil
 }
 
-//PostTask 新增任务
-func PostTask(t *Task) error {
+//validateTask 新增以及更新任务时的校验 延迟作业的 run_at 由 setRunAt 处理
+func validateTask(t *Task) error {
 	if t.Type == "" {
 		t.Type = "http"
 	}
@@ -83,28 +58,74 @@ func PostTask(t *Task) error {
 		log.Errorf(msg)
 		return errors.New(msg)
 	}
-	if t.Expression == "" {
-		return errors.New("task expression is nil")
+	if err := validatePayload(t); err != nil {
+		return err
+	}
+	if t.Aid != "" {
+		if _, _, err := GetAppKeyAndSecretKey(context.Background(), t.Aid); err != nil {
+			return AppUnavailableErr
+		}
+	}
+	if t.TimeOut < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if err := t.Concurrency.validate(); err != nil {
+		return err
+	}
+	if err := t.Misfire.validate(); err != nil {
+		return err
 	}
 	if t.Timezone == "" {
 		t.Timezone = "Asia/Shanghai"
 	}
 	// 检验 timezone 是否是允许的 timezone 中
-	err := timezoneIsValid(t.Timezone)
+	if err := timezoneIsValid(t.Timezone); err != nil {
+		return err
+	}
+	if t.Delay {
+		if t.RunAt <= 0 {
+			return errors.New("delay task need run_at or after")
+		}
+		return nil
+	}
+	// 使用和调度器相同的解析器
+	return ValidateExpression(t.Expression, t.Timezone)
+}
+
+//validatePayload 根据作业类型校验 payload 返回字段级别的错误
+func validatePayload(t *Task) error {
+	e, err := GetExecutor(t.Type)
 	if err != nil {
 		return err
 	}
+	return e.Validate(*t)
+}
+
+//PostTask 新增任务
+func PostTask(t *Task) error {
+	if t.Delay {
+		if err := setRunAt(t); err != nil {
+			return err
+		}
+	}
+	err := validateTask(t)
+	if err != nil {
+		return err
+	}
+	if err = validateSelector(t.Selector); err != nil {
+		return err
+	}
 	t.CreateAt = time.Now().Unix()
+	t.LastFireAt = 0
+	t.NextRunAt = nextRunAt(*t, time.Now())
 	t.UpdateAt = t.CreateAt
 	t.Id = primitive.NewObjectID()
 	t.Tid = t.Id.Hex()
 	log.Debugf("[model] tid is %v", t.Tid)
-	res, err := TaskCol.InsertOne(context.Background(), &t)
-	if err != nil {
+	if err = DB.Task().Insert(context.Background(), t); err != nil {
 		log.Errorf("[model] insert task err: %v", err)
 		return err
 	}
-	log.Debugf("[model] insert id is: %v", res.InsertedID)
 
 	if err = PubRedis(t.Tid, CREATE); err != nil {
 		log.Errorf("[post task] pub event to redis err: %v", err)
@@ -114,6 +135,132 @@ func PostTask(t *Task) error {
 	return nil
 }
 
+//setRunAt 延迟作业需要 run_at 或者 after 两者都有以 after 为准
+func setRunAt(t *Task) error {
+	if t.After != "" {
+		d, err := time.ParseDuration(t.After)
+		if err != nil {
+			return fmt.Errorf("invalid after %s: %v", t.After, err)
+		}
+		if d < 0 {
+			return fmt.Errorf("invalid after %s: must not be negative", t.After)
+		}
+		t.RunAt = time.Now().Add(d).Unix()
+		t.After = ""
+	}
+	if t.RunAt <= 0 {
+		return errors.New("delay task need run_at or after")
+	}
+	t.Completed = false
+	return nil
+}
+
+//UpdateTask 全量或者部分更新任务 patch 的 key 为 json tag 没有出现的字段保持不变
+//tid create_at update_at completed last_fire_at 由服务端维护 不能修改
+func UpdateTask(tid string, patch map[string]interface{}) (Task, error) {
+	old, err := GetTask(tid)
+	if err != nil {
+		return old, err
+	}
+	for _, key := range []string{"tid", "create_at", "update_at", "completed", "next_run_at", "created_by", "last_fire_at"} {
+		delete(patch, key)
+	}
+
+	// 以 json 的形式合并 再严格解析 未知的字段直接报错
+	b, err := json.Marshal(old)
+	if err != nil {
+		return old, err
+	}
+	doc := make(map[string]interface{})
+	if err = json.Unmarshal(b, &doc); err != nil {
+		return old, err
+	}
+	for key, value := range patch {
+		doc[key] = value
+	}
+	if b, err = json.Marshal(doc); err != nil {
+		return old, err
+	}
+	t := Task{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&t); err != nil {
+		return old, fmt.Errorf("invalid task: %v", err)
+	}
+	t.Id = old.Id
+	t.Tid = old.Tid
+	t.CreateAt = old.CreateAt
+	t.Completed = old.Completed
+	t.CreatedBy = old.CreatedBy
+	t.LastFireAt = old.LastFireAt
+
+	_, runAt := patch["run_at"]
+	_, after := patch["after"]
+	if t.Delay && (runAt || after || !old.Delay) {
+		// 重新设置执行时间之后可以再执行一次
+		if err = setRunAt(&t); err != nil {
+			return old, err
+		}
+	}
+	if err = validateTask(&t); err != nil {
+		return old, err
+	}
+	// selector 没有变化的时候不校验 worker 全部下线期间也可以修改或者禁用任务
+	if !equalLabels(old.Selector.Required, t.Selector.Required) {
+		if err = validateSelector(t.Selector); err != nil {
+			return old, err
+		}
+		// 工作流节点不能设置 selector 见 validateWorkflow
+		if len(t.Selector.Required) > 0 {
+			node, err := isWorkflowNode(t.Tid)
+			if err != nil {
+				return old, err
+			}
+			if node {
+				return old, WorkflowSelectorErr
+			}
+		}
+	}
+	t.UpdateAt = time.Now().Unix()
+	t.NextRunAt = nextRunAt(t, time.Now())
+
+	if err = DB.Task().Update(context.Background(), tid, taskFields(t)); err != nil {
+		log.Errorf("[model] update task %s err: %v", tid, err)
+		return old, err
+	}
+	if err = PubRedis(tid, MODIFY); err != nil {
+		log.Errorf("[update task] pub event to redis err: %v", err)
+		return t, err
+	}
+	return t, nil
+}
+
+//taskFields 可以修改的字段 key 为 bson tag
+func taskFields(t Task) map[string]interface{} {
+	return map[string]interface{}{
+		"name":          t.Name,
+		"disable":       t.Disable,
+		"timeout":       t.TimeOut,
+		"update_at":     t.UpdateAt,
+		"log_enable":    t.LogEnable,
+		"expression":    t.Expression,
+		"delay":         t.Delay,
+		"timezone":      t.Timezone,
+		"payload":       t.Payload,
+		"type":          t.Type,
+		"retry":         t.Retry,
+		"run_at":        t.RunAt,
+		"completed":     t.Completed,
+		"output_limit":  t.OutputLimit,
+		"stream_output": t.StreamOutput,
+		"aid":           t.Aid,
+		"updated_by":    t.UpdatedBy,
+		"selector":      t.Selector,
+		"concurrency":   t.Concurrency,
+		"misfire":       t.Misfire,
+	}
+}
+
 //DisableTask
 func DisableTask(tid string, tMap map[string]interface{}) error {
 	return PutTask(tid, tMap, DISABLE)
@@ -132,21 +279,30 @@ func PutTask(tid string, tMap map[string]interface{}, event int) error {
 		return err
 	}
 
+	if event == MODIFY {
+		// 表达式或者时区变更之后需要重新校验
+		expression, timezone := oldTask.Expression, oldTask.Timezone
+		if v, ok := tMap["expression"].(string); ok {
+			expression = v
+		}
+		if v, ok := tMap["timezone"].(string); ok {
+			timezone = v
+		}
+		if err = timezoneIsValid(timezone); err != nil {
+			return err
+		}
+		if !oldTask.Delay {
+			if err = ValidateExpression(expression, timezone); err != nil {
+				return err
+			}
+		}
+	}
+
 	// 注意要先进行存储 task 的 tid 会被赋值，然后再带过去 redis
-	res, err := TaskCol.UpdateOne(context.Background(),
-		bson.D{{
-			"_id",
-			oldTask.Id,
-		}},
-		bson.D{{
-			"$set",
-			&tMap,
-		}})
-	if err != nil {
+	if err = DB.Task().Update(context.Background(), oldTask.Tid, tMap); err != nil {
 		log.Errorf("[model] update err: %v", err)
 		return err
 	}
-	log.Debugf("[model] match count: %v, modify count: %v", res.MatchedCount, res.ModifiedCount)
 
 	if err = PubRedis(tid, event); err != nil {
 		log.Errorf("[put task] pub event to redis err: %v", err)
@@ -158,15 +314,19 @@ func PutTask(tid string, tMap map[string]interface{}, event int) error {
 
 //PubRedis 发布事件到 redis
 func PubRedis(tid string, event int) error {
+	return publishEvent(TaskEvent{
+		Event: event,
+		Tid:   tid,
+	})
+}
+
+//publishEvent 发布任务或者工作流事件
+func publishEvent(e TaskEvent) error {
 	if !config.Config.GetBool("pubsub.open") {
 		log.Debugf("[pub redis] 通道关闭")
 		return nil
 	}
 	// DONE: pub to redis
-	e := TaskEvent{
-		Event: event,
-		Tid:   tid,
-	}
 	msg, err := msgpack.Marshal(e)
 	log.Debugf("[model] 发布消息到 redis %v", e)
 	if err != nil {
@@ -180,31 +340,118 @@ func PubRedis(tid string, event int) error {
 	return nil
 }
 
-//RunTask 执行任务
+//RunTask 执行任务 失败之后根据重试策略进行重试 重试期间一直持有分布式锁
 func RunTask(tid string) error {
+	return runTask(tid, time.Time{}, false)
+}
+
+//runTask fireAt 为调度器触发的时间 用于保证同一次触发只执行一次 catchUp 为补跑错过的调度
+func runTask(tid string, fireAt time.Time, catchUp bool) error {
+	_, err := runTaskStatus(tid, fireAt, catchUp)
+	return err
+}
+
+//runTaskStatus 同 runTask 同时返回最后一次运行记录的状态 没有写入运行记录的时候为空
+func runTaskStatus(tid string, fireAt time.Time, catchUp bool) (string, error) {
 	task, err := GetTask(tid)
 	if err != nil {
 		log.Errorf("error to find the task with: %v", err)
-		return RunTaskNotFoundTaskErr
+		return "", RunTaskNotFoundTaskErr
+	}
+	if task.Delay && task.Completed {
+		return "", DelayTaskCompletedErr
+	}
+
+	log.Debugf("[ostool] execute job name: %s, tid: %s", task.Name, task.Tid)
+	start := time.Now()
+	// 例如工作流节点或者手动执行 在不满足 selector 的 worker 上不执行
+	if !task.Selector.matches(localLabels()) {
+		l := lockFailedLog(task, start, SelectorMismatchErr)
+		saveLogAsync(task, l)
+		return l.Status, SelectorMismatchErr
+	}
+	// DONE: 分布式锁 根据并发策略决定同时执行的实例数
+	release, cancel, err := acquireRun(task, fireAt, catchUp)
+	if err == FireTakenErr {
+		// 本次调度由其他 worker 执行 不是跳过 不记录运行记录
+		log.Debugf("[ostool] task %s: %v", task.Tid, err)
+		return "", err
 	}
-	l, err := time.LoadLocation(task.Timezone)
 	if err != nil {
-		log.Errorf("[task] load time location err: %v", err)
-		return err
+		log.Errorf("[ostool] 加锁失败: %v", err)
+		l := lockFailedLog(task, start, err)
+		saveLogAsync(task, l)
+		return l.Status, err
+	}
+	defer release()
+
+	if task.Delay {
+		// 抢到锁之后重新确认 防止其他 worker 已经执行过了
+		if latest, e := GetTask(tid); e != nil || latest.Completed {
+			return "", DelayTaskCompletedErr
+		}
+	}
+
+	// 超过 worker.capacity 之后排队 队列满了直接跳过
+	done, err := acquireSlot()
+	if err != nil {
+		log.Warnf("[ostool] task %s: %v", task.Tid, err)
+		l := lockFailedLog(task, start, err)
+		saveLogAsync(task, l)
+		return l.Status, err
 	}
-	now := time.Now().In(l).Format(time.RFC3339)
+	defer done()
+	defer markRunning(task.Tid)()
+	recordFire(task, fireAt)
 
 	// 这里要阻塞 不然调度器会以为任务已经完成，所以直接 stop
-	switch task.Type {
-	case BashTask:
-		// TODO: shellcheck https://github.com/koalaman/shellcheck
-		err = RunBashTask(task)
-	case HTTPTask:
-		err = RunHTTPTask(task, now)
-	default:
-		err = errors.New("[task] 暂时不支持除 http,bash 之外的作业类型")
+	var status string
+	for attempt := 1; ; attempt++ {
+		taskLog := newTaskLog(task, time.Now())
+		taskLog.Attempt = attempt
+		taskLog.cancel = cancel
+		startStreamLog(task, &taskLog)
+		err = runTaskOnce(task, &taskLog)
+		publishRunStatus(task, taskLog)
+		saveLogAsync(task, taskLog)
+		status = taskLog.Status
+		if err == nil || taskLog.Status == RunCancelled || attempt >= task.Retry.attempts() || !task.Retry.retryable(taskLog) {
+			break
+		}
+		backoff := task.Retry.backoff(attempt)
+		log.Infof("[task] %s 第 %d 次执行失败: %v, %v 之后重试", task.Tid, attempt, err, backoff)
+		time.Sleep(backoff)
 	}
-	return err
+
+	task.UpdateAt = time.Now().Unix()
+	log.Debugf("[%v] - now finish task [%s]", task.Tid, task.Name)
+	if e := DB.Task().Update(context.Background(), task.Tid, map[string]interface{}{
+		"update_at": task.UpdateAt,
+	}); e != nil {
+		log.Errorf("[ostool] update task %s err: %v", task.Tid, e)
+	}
+
+	if task.Delay {
+		// 在释放锁之前标记完成 并通知所有 worker 从调度器中移除
+		if e := DisableTask(task.Tid, map[string]interface{}{
+			"completed": true,
+			"disable":   true,
+		}); e != nil {
+			log.Errorf("[task] mark delay task %s completed err: %v", task.Tid, e)
+		}
+	}
+	return status, err
+}
+
+//runTaskOnce 根据作业类型找到执行器执行一次
+func runTaskOnce(task Task, l *TaskLog) error {
+	e, err := GetExecutor(task.Type)
+	if err != nil {
+		l.StdErr = err.Error()
+		l.finish(RunFailed, -1)
+		return err
+	}
+	return e.Run(task, l)
 }
 
 //DeleteTask 删除任务
@@ -233,11 +480,9 @@ func DeleteTask(tid string) error {
 	// 即使 redis publish 有问题，数据库中的任务删除后，
 	// 因为每次 worker RunTask 都会从数据库中取出数据，所以如果数据库的任务被删除了, worker 中也无法跑这个任务
 	// 2、删除数据库中的 task
-	res, err := TaskCol.DeleteOne(context.Background(), bson.D{{"_id", task.Id}})
-	if err != nil {
+	if err = DB.Task().Delete(context.Background(), task.Tid); err != nil {
 		log.Errorf("[model] delete task %s err: %v", task.Tid, err)
 		return err
 	}
-	log.Debugf("[model] delete count: %d", res.DeletedCount)
 	return nil
 }
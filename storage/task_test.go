@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"clock/v3/config"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 使用进程内的 database 以及 cache，不依赖外部服务
+func setMemoryStorage(t *testing.T) {
+	setTestStorage(t, NewMemoryDatabase())
+}
+
+func setTestStorage(t *testing.T, db Database) {
+	setMemoryCacheConfig(20, 120)
+	config.Config.Set("pubsub.open", true)
+	DB = db
+	cache := NewMemoryCache()
+	RCache = cache
+	// 测试结束之前停止续租 等待后台的补跑以及异步保存的运行记录 避免读取下一个测试替换的全局变量
+	// 先关闭 cache 等待任务锁的补跑会直接返回
+	t.Cleanup(func() {
+		cache.Close()
+		catchingUp.Wait()
+		pendingLogs.Wait()
+	})
+
+	err := CreateSupportTimezone(context.Background(), &Timezone{Value: "Asia/Shanghai", Label: "上海"})
+	assert.Nil(t, err)
+}
+
+// 修改当前的配置 测试结束之后恢复 不影响之后的测试
+func setTestConfig(t *testing.T, key string, value interface{}) {
+	c := config.Config
+	prev := c.Get(key)
+	c.Set(key, value)
+	t.Cleanup(func() {
+		c.Set(key, prev)
+	})
+}
+
+// 需要进行测试的 database 实现
+func testDatabases(t *testing.T) map[string]func() Database {
+	return map[string]func() Database{
+		"memory": func() Database {
+			return NewMemoryDatabase()
+		},
+		"sqlite": func() Database {
+			db, err := NewSQLDatabase(SQLite, ":memory:")
+			if err != nil {
+				t.Fatalf("open sqlite err: %v", err)
+			}
+			return db
+		},
+	}
+}
+
+func TestTaskCRUD(t *testing.T) {
+	for name, newDB := range testDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			setTestStorage(t, newDB())
+			defer RevokeDb()
+			testTaskCRUD(t)
+		})
+	}
+}
+
+func testTaskCRUD(t *testing.T) {
+	task := Task{
+		Name:       "date",
+		Expression: "* * * * *",
+		Type:       BashTask,
+		Payload:    map[string]interface{}{"command": "date"},
+	}
+	assert.Nil(t, PostTask(&task))
+	assert.NotEmpty(t, task.Tid)
+	assert.Equal(t, "Asia/Shanghai", task.Timezone)
+
+	// 不支持的时区
+	assert.NotNil(t, PostTask(&Task{Expression: "* * * * *", Timezone: "UTC", Payload: map[string]interface{}{}}))
+
+	got, err := GetTask(task.Tid)
+	assert.Nil(t, err)
+	assert.Equal(t, "date", got.Payload["command"])
+
+	query := TaskQuery{}
+	query.Name = "date"
+	tasks, err := GetTasks(&query)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, query.Total)
+	assert.Len(t, tasks, 1)
+
+	query.Order = "create_at"
+	tasks, err = GetTasks(&query)
+	assert.Nil(t, err)
+	assert.Len(t, tasks, 1)
+
+	query.Name = "not exists"
+	tasks, err = GetTasks(&query)
+	assert.Nil(t, err)
+	assert.Len(t, tasks, 0)
+
+	assert.Nil(t, ModifyTask(task.Tid, map[string]interface{}{"expression": "*/5 * * * *"}))
+	got, err = GetTask(task.Tid)
+	assert.Nil(t, err)
+	assert.Equal(t, "*/5 * * * *", got.Expression)
+	assert.Equal(t, "date", got.Name)
+
+	assert.Nil(t, DeleteTask(task.Tid))
+	_, err = GetTask(task.Tid)
+	assert.NotNil(t, err)
+}
+
+func TestTaskLogs(t *testing.T) {
+	for name, newDB := range testDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			setTestStorage(t, newDB())
+			defer RevokeDb()
+			testTaskLogs(t)
+		})
+	}
+}
+
+func newBashTask(t *testing.T, command string, logEnable bool) Task {
+	task := Task{
+		Name:       command,
+		Expression: "0 0 * * *",
+		Type:       BashTask,
+		LogEnable:  logEnable,
+		Payload:    map[string]interface{}{"command": command},
+	}
+	assert.Nil(t, PostTask(&task))
+	return task
+}
+
+// 运行记录是异步写入的
+func waitLogs(t *testing.T, query LogQuery, n int) []TaskLog {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		logs, err := GetLogs(&query)
+		assert.Nil(t, err)
+		if len(logs) >= n || time.Now().After(deadline) {
+			return logs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testTaskLogs(t *testing.T) {
+	ok := newBashTask(t, "echo ok", true)
+	fail := newBashTask(t, "echo oops >&2; exit 3", true)
+	quiet := newBashTask(t, "echo quiet", false)
+	held := newBashTask(t, "echo held", false)
+
+	assert.Nil(t, RunTask(ok.Tid))
+	assert.NotNil(t, RunTask(fail.Tid))
+	assert.Nil(t, RunTask(quiet.Tid))
+
+	// 锁被持有的时候记录为 skipped
+	done, err := RCache.TryLock(held)
+	assert.Nil(t, err)
+	assert.Equal(t, WaitForNextScheduleErr, RunTask(held.Tid))
+	done <- 1
+
+	query := LogQuery{}
+	query.Tid = ok.Tid
+	logs := waitLogs(t, query, 1)
+	assert.Len(t, logs, 1)
+	assert.Equal(t, "ok\n", logs[0].StdOut)
+	assert.Equal(t, RunSuccess, logs[0].Status)
+	assert.Equal(t, WorkerId(), logs[0].Worker)
+
+	query.Tid = fail.Tid
+	logs = waitLogs(t, query, 1)
+	assert.Len(t, logs, 1)
+	assert.Equal(t, RunFailed, logs[0].Status)
+	assert.Equal(t, 3, logs[0].Code)
+	assert.Contains(t, logs[0].StdErr, "oops")
+
+	// 未开启日志 只记录状态
+	query.Tid = quiet.Tid
+	logs = waitLogs(t, query, 1)
+	assert.Len(t, logs, 1)
+	assert.Equal(t, RunSuccess, logs[0].Status)
+	assert.Empty(t, logs[0].StdOut)
+
+	query.Tid = ""
+	query.Status = RunSkipped
+	logs = waitLogs(t, query, 1)
+	assert.Len(t, logs, 1)
+	assert.Equal(t, held.Tid, logs[0].Tid)
+
+	assert.Nil(t, DeleteLogs(&LogQuery{}))
+	logs, err = GetLogs(&LogQuery{})
+	assert.Nil(t, err)
+	assert.Len(t, logs, 0)
+}
+
+func TestTaskEventSubscribe(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+
+	assert.Nil(t, InitScheduler())
+	defer StopScheduler()
+	go SubCronJob(make(chan os.Signal, 1))
+	time.Sleep(100 * time.Millisecond) // 等待订阅完成
+
+	task := Task{
+		Name:       "date",
+		Expression: "0 0 * * *",
+		Type:       BashTask,
+		Payload:    map[string]interface{}{"command": "date"},
+	}
+	assert.Nil(t, PostTask(&task))
+
+	deadline := time.Now().Add(time.Second)
+	for cronScheduler.GetTaskEntryId(task.Tid) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NotZero(t, cronScheduler.GetTaskEntryId(task.Tid))
+}
+
+func TestDelayTask(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+
+	// 延迟作业需要 run_at 或者 after
+	assert.NotNil(t, PostTask(&Task{Delay: true, Type: BashTask, Payload: map[string]interface{}{"command": "date"}}))
+	assert.NotNil(t, PostTask(&Task{Delay: true, After: "soon", Type: BashTask, Payload: map[string]interface{}{"command": "date"}}))
+
+	later := Task{Delay: true, After: "15m", Type: BashTask, Payload: map[string]interface{}{"command": "date"}}
+	assert.Nil(t, PostTask(&later))
+	assert.InDelta(t, time.Now().Add(15*time.Minute).Unix(), later.RunAt, 1)
+
+	assert.Nil(t, InitScheduler())
+	defer StopScheduler()
+	go SubCronJob(make(chan os.Signal, 1))
+	time.Sleep(100 * time.Millisecond) // 等待订阅完成
+
+	// 已经过了执行时间的马上执行
+	task := Task{
+		Delay:     true,
+		RunAt:     time.Now().Add(-time.Minute).Unix(),
+		Type:      BashTask,
+		LogEnable: true,
+		Payload:   map[string]interface{}{"command": "echo once"},
+	}
+	assert.Nil(t, PostTask(&task))
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if got, _ := GetTask(task.Tid); got.Completed {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	got, err := GetTask(task.Tid)
+	assert.Nil(t, err)
+	assert.True(t, got.Completed)
+	assert.True(t, got.Disable)
+
+	// 只会执行一次
+	assert.Equal(t, DelayTaskCompletedErr, RunTask(task.Tid))
+	query := LogQuery{}
+	query.Tid = task.Tid
+	logs := waitLogs(t, query, 1)
+	assert.Len(t, logs, 1)
+	assert.Equal(t, "once\n", logs[0].StdOut)
+}
+
+func TestUpdateTask(t *testing.T) {
+	for name, newDB := range testDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			setTestStorage(t, newDB())
+			defer RevokeDb()
+			testUpdateTask(t)
+		})
+	}
+}
+
+func testUpdateTask(t *testing.T) {
+	assert.NotNil(t, PostTask(&Task{Expression: "61 * * * *", Type: BashTask, Payload: map[string]interface{}{"command": "date"}}))
+	assert.NotNil(t, PostTask(&Task{Expression: "* * * * *", Type: BashTask, Payload: map[string]interface{}{}}))
+
+	task := newBashTask(t, "date", false)
+
+	got, err := UpdateTask(task.Tid, map[string]interface{}{
+		"name":       "renamed",
+		"timeout":    30,
+		"log_enable": true,
+		"payload":    map[string]interface{}{"command": "echo updated"},
+		"tid":        "ignored",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, task.Tid, got.Tid)
+
+	got, err = GetTask(task.Tid)
+	assert.Nil(t, err)
+	assert.Equal(t, "renamed", got.Name)
+	assert.Equal(t, 30, got.TimeOut)
+	assert.True(t, got.LogEnable)
+	assert.Equal(t, "echo updated", got.Payload["command"])
+	assert.Equal(t, task.Expression, got.Expression)
+	assert.Equal(t, task.CreateAt, got.CreateAt)
+
+	// 校验失败不会修改
+	_, err = UpdateTask(task.Tid, map[string]interface{}{"expression": "not a cron"})
+	assert.NotNil(t, err)
+	_, err = UpdateTask(task.Tid, map[string]interface{}{"type": HTTPTask})
+	assert.NotNil(t, err)
+	_, err = UpdateTask(task.Tid, map[string]interface{}{"unknown": 1})
+	assert.NotNil(t, err)
+	_, err = UpdateTask("000000000000000000000000", map[string]interface{}{"name": "x"})
+	assert.NotNil(t, err)
+
+	got, err = GetTask(task.Tid)
+	assert.Nil(t, err)
+	assert.Equal(t, task.Expression, got.Expression)
+	assert.Equal(t, BashTask, got.Type)
+}
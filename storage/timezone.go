@@ -2,31 +2,15 @@ package storage
 
 import (
 	"context"
-	"fmt"
 	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func GetAllSupportTimezone(ctx context.Context) ([]Timezone, error) {
-	var err error
-
-	timezones := make([]Timezone, 0)
-	opts := options.Find()
-	opts.SetSort(bson.D{{"label", DESC}})
-	cursor, err := TimezoneCol.Find(ctx, bson.D{}, opts)
-	if err != nil {
-		return timezones, errors.Wrap(err, "get timezone err")
-	}
-	if err = cursor.All(ctx, &timezones); err != nil {
-		return timezones, err
-	}
-
-	return timezones, nil
+	return DB.Timezone().FindAll(ctx)
 }
 
 //timezoneIsValid 验证时区是否有效
@@ -76,29 +60,15 @@ func CreateSupportTimezone(ctx context.Context, t *Timezone) error {
 	if timezoneIsExists(ctx, t.Value) {
 		return TimezoneIsExistsErr
 	}
-	res, err := TimezoneCol.InsertOne(ctx, &t)
-	if err != nil {
-		return errors.Wrap(err, "[model] insert task err")
-	}
-	log.Debugf("[model] insert id is: %v", res.InsertedID)
-
-	return nil
+	return DB.Timezone().Insert(ctx, t)
 }
 
 //GetSupportTimezone
 func GetSupportTimezone(ctx context.Context, tid string) (Timezone, error) {
-	var t Timezone
-
-	oid, err := primitive.ObjectIDFromHex(tid)
+	t, err := DB.Timezone().FindOne(ctx, tid)
 	if err != nil {
-		return t, errors.Wrap(err, fmt.Sprintf("[model] get timezone %s err", tid))
-	}
-
-	if err = TimezoneCol.
-		FindOne(ctx, bson.D{{"_id", oid}}).
-		Decode(&t); err != nil {
-		log.Errorf("[model] decode task %s err:%v", tid, err)
-		return t, errors.Wrap(err, fmt.Sprintf("[model] decode task %s err", tid))
+		log.Errorf("[model] get timezone %s err:%v", tid, err)
+		return t, err
 	}
 	return t, nil
 }
@@ -110,10 +80,5 @@ func DeleteSupportTimezone(ctx context.Context, tid string) error {
 		return err
 	}
 
-	res, err := TimezoneCol.DeleteOne(ctx, bson.D{{"_id", timezone.Id}})
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("[model] delete task %s err", tid))
-	}
-	log.Debugf("[model] delete count: %d", res.DeletedCount)
-	return nil
+	return DB.Timezone().Delete(ctx, timezone.Tid)
 }
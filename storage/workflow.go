@@ -0,0 +1,386 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//GetWorkflows 取出所有工作流 默认前 10
+func GetWorkflows(query *WorkflowQuery) ([]Workflow, error) {
+	if query.Count < 1 {
+		query.Count = 10
+	}
+
+	if query.Index < 1 {
+		query.Index = 1
+	}
+
+	workflows, err := DB.Workflow().Find(context.Background(), query)
+	if err != nil {
+		log.Errorf("[workflow] get workflows err: %v", err)
+		return workflows, err
+	}
+	return workflows, nil
+}
+
+//GetWorkflow 根据 wid 进行查询
+func GetWorkflow(wid string) (Workflow, error) {
+	w, err := DB.Workflow().FindOne(context.Background(), wid)
+	if err != nil {
+		log.Errorf("[workflow] get workflow %s err: %v", wid, err)
+		return w, err
+	}
+	return w, nil
+}
+
+//PostWorkflow 新增工作流
+func PostWorkflow(w *Workflow) error {
+	if w.Timezone == "" {
+		w.Timezone = "Asia/Shanghai"
+	}
+	if err := timezoneIsValid(w.Timezone); err != nil {
+		return err
+	}
+	// 和任务一样使用调度器的解析器校验 否则保存成功之后无法调度
+	if err := ValidateExpression(w.Expression, w.Timezone); err != nil {
+		return err
+	}
+	if err := validateWorkflow(w); err != nil {
+		return err
+	}
+
+	w.CreateAt = time.Now().Unix()
+	w.UpdateAt = w.CreateAt
+	w.Id = primitive.NewObjectID()
+	w.Wid = w.Id.Hex()
+	if err := DB.Workflow().Insert(context.Background(), w); err != nil {
+		log.Errorf("[workflow] insert workflow err: %v", err)
+		return err
+	}
+
+	return PubWorkflowEvent(w.Wid, CREATE)
+}
+
+//DisableWorkflow 禁用/启用工作流
+func DisableWorkflow(wid string, disable bool) error {
+	if _, err := GetWorkflow(wid); err != nil {
+		return err
+	}
+	if err := DB.Workflow().Update(context.Background(), wid, map[string]interface{}{
+		"disable":   disable,
+		"update_at": time.Now().Unix(),
+	}); err != nil {
+		log.Errorf("[workflow] update err: %v", err)
+		return err
+	}
+	return PubWorkflowEvent(wid, DISABLE)
+}
+
+//DeleteWorkflow 删除工作流 运行记录保留
+func DeleteWorkflow(wid string) error {
+	if _, err := GetWorkflow(wid); err != nil {
+		return err
+	}
+	if err := PubWorkflowEvent(wid, DELETE); err != nil {
+		return err
+	}
+	// 和任务一样 worker 每次运行都会重新查询 所以即使事件没有送达也不会再执行
+	if err := DB.Workflow().Delete(context.Background(), wid); err != nil {
+		log.Errorf("[workflow] delete workflow %s err: %v", wid, err)
+		return err
+	}
+	return nil
+}
+
+//GetWorkflowRuns 获取工作流运行记录 默认前 10
+func GetWorkflowRuns(query *WorkflowRunQuery) ([]WorkflowRun, error) {
+	if query.Count < 1 {
+		query.Count = 10
+	}
+
+	if query.Index < 1 {
+		query.Index = 1
+	}
+
+	runs, err := DB.WorkflowRun().Find(context.Background(), query)
+	if err != nil {
+		log.Errorf("[workflow] get workflow runs err: %v", err)
+		return runs, err
+	}
+	return runs, nil
+}
+
+//PubWorkflowEvent 发布工作流事件
+func PubWorkflowEvent(wid string, event int) error {
+	return publishEvent(TaskEvent{
+		Event: event,
+		Tid:   wid,
+		Kind:  WorkflowKind,
+	})
+}
+
+//validateWorkflow 检查节点以及依赖关系 没有填写 trigger 的边默认为 on_success
+func validateWorkflow(w *Workflow) error {
+	if len(w.Nodes) == 0 {
+		return WorkflowNoNodeErr
+	}
+	nodes := make(map[string]bool, len(w.Nodes))
+	for _, tid := range w.Nodes {
+		if nodes[tid] {
+			return fmt.Errorf("工作流节点 %s 重复", tid)
+		}
+		task, err := GetTask(tid)
+		if err != nil {
+			return fmt.Errorf("工作流节点 %s 对应的任务不存在", tid)
+		}
+		if w.Aid != "" && task.Aid != w.Aid {
+			return fmt.Errorf("工作流节点 %s 不属于 app %s", tid, w.Aid)
+		}
+		// 节点在调度工作流的 worker 上执行 标签不满足的时候一定失败
+		if len(task.Selector.Required) > 0 {
+			return fmt.Errorf("工作流节点 %s: %w", tid, WorkflowSelectorErr)
+		}
+		nodes[tid] = true
+	}
+
+	for i := range w.Edges {
+		e := &w.Edges[i]
+		if !nodes[e.From] || !nodes[e.To] {
+			return fmt.Errorf("工作流的边 %s -> %s 引用了不存在的节点", e.From, e.To)
+		}
+		if e.From == e.To {
+			return WorkflowCycleErr
+		}
+		switch e.Trigger {
+		case "":
+			e.Trigger = TriggerOnSuccess
+		case TriggerOnSuccess, TriggerOnFailure, TriggerAlways:
+		default:
+			return fmt.Errorf("不支持的触发条件 %s", e.Trigger)
+		}
+	}
+
+	// 拓扑排序 所有节点都能被访问到说明没有环
+	visited := 0
+	indegree, downstream := workflowGraph(w)
+	ready := workflowRoots(w, indegree)
+	for len(ready) > 0 {
+		visited += len(ready)
+		ready = nextWorkflowNodes(ready, indegree, downstream)
+	}
+	if visited != len(w.Nodes) {
+		return WorkflowCycleErr
+	}
+	return nil
+}
+
+//workflowGraph 各个节点的入度以及下游节点
+//isWorkflowNode 任务是否是某个工作流的节点
+func isWorkflowNode(tid string) (bool, error) {
+	workflows, err := DB.Workflow().FindAll(context.Background())
+	if err != nil {
+		return false, err
+	}
+	for _, w := range workflows {
+		for _, node := range w.Nodes {
+			if node == tid {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func workflowGraph(w *Workflow) (map[string]int, map[string][]string) {
+	indegree := make(map[string]int, len(w.Nodes))
+	downstream := make(map[string][]string, len(w.Nodes))
+	for _, e := range w.Edges {
+		indegree[e.To]++
+		downstream[e.From] = append(downstream[e.From], e.To)
+	}
+	return indegree, downstream
+}
+
+//workflowRoots 没有上游的节点
+func workflowRoots(w *Workflow, indegree map[string]int) []string {
+	roots := make([]string, 0)
+	for _, tid := range w.Nodes {
+		if indegree[tid] == 0 {
+			roots = append(roots, tid)
+		}
+	}
+	return roots
+}
+
+//nextWorkflowNodes 当前这一批节点完成之后 上游全部完成的下游节点
+func nextWorkflowNodes(done []string, indegree map[string]int, downstream map[string][]string) []string {
+	next := make([]string, 0)
+	for _, tid := range done {
+		for _, to := range downstream[tid] {
+			indegree[to]--
+			if indegree[to] == 0 {
+				next = append(next, to)
+			}
+		}
+	}
+	return next
+}
+
+//triggered 判断节点的所有上游是否满足触发条件
+func triggered(w *Workflow, tid string, statuses map[string]string) bool {
+	for _, e := range w.Edges {
+		if e.To != tid {
+			continue
+		}
+		status := statuses[e.From]
+		switch e.Trigger {
+		case TriggerAlways:
+		case TriggerOnFailure:
+			if status != RunFailed && status != RunTimeout {
+				return false
+			}
+		default:
+			if status != RunSuccess {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//runWorkflowNode 和 RunTask 一样执行节点对应的任务 节点的状态为最后一次运行记录的状态 例如 timeout
+func runWorkflowNode(tid string) string {
+	status, err := runTaskStatus(tid, time.Time{}, false)
+	switch {
+	case err == nil:
+		return RunSuccess
+	case err == WaitForNextScheduleErr: // 任务正在被其他 worker 执行
+		return RunSkipped
+	}
+	log.Errorf("[workflow] run node %s err: %v", tid, err)
+	if status == "" {
+		return RunFailed
+	}
+	return status
+}
+
+//RunWorkflow 执行工作流 按照拓扑顺序一批一批执行 同一批的节点并发执行
+//上游不满足触发条件的节点记录为 skipped
+func RunWorkflow(wid string) error {
+	return runWorkflow(wid, time.Time{})
+}
+
+//runWorkflow fireAt 为调度器触发的时间 已经被其他 worker 执行的触发不记录运行记录
+//只有上一次运行还没有结束的时候记录为 skipped
+func runWorkflow(wid string, fireAt time.Time) error {
+	w, err := GetWorkflow(wid)
+	if err != nil {
+		return WorkflowNotFoundErr
+	}
+
+	start := time.Now()
+	id := primitive.NewObjectID()
+	run := WorkflowRun{
+		Id:       id,
+		Rid:      id.Hex(),
+		Wid:      w.Wid,
+		Status:   RunRunning,
+		Nodes:    make(map[string]string, len(w.Nodes)),
+		Worker:   WorkerId(),
+		StartAt:  start.Unix(),
+		CreateAt: start.Unix(),
+	}
+
+	// 和任务共用分布式锁 wid 和 tid 都是 ObjectID 不会冲突
+	lock := Task{Tid: w.Wid, Name: w.Name}
+	if !fireAt.IsZero() {
+		if err = claimFire(lock, fireAt); err == FireTakenErr {
+			return err
+		}
+	}
+	var jobDone chan int
+	if err == nil {
+		jobDone, err = RCache.TryLock(lock)
+	}
+	if err != nil {
+		run.Status = RunSkipped
+		if err != WaitForNextScheduleErr {
+			run.Status = RunFailed
+		}
+		run.EndAt = run.StartAt
+		if e := DB.WorkflowRun().Insert(context.Background(), &run); e != nil {
+			log.Errorf("[workflow] insert workflow run err: %v", e)
+		}
+		return err
+	}
+	defer func() {
+		jobDone <- 1 // 完成 job
+	}()
+
+	if err = DB.WorkflowRun().Insert(context.Background(), &run); err != nil {
+		log.Errorf("[workflow] insert workflow run err: %v", err)
+		return err
+	}
+
+	var mu sync.Mutex
+	indegree, downstream := workflowGraph(&w)
+	ready := workflowRoots(&w, indegree)
+	for len(ready) > 0 {
+		var wg sync.WaitGroup
+		for _, tid := range ready {
+			// 上游都在之前的批次中完成了
+			mu.Lock()
+			ok := triggered(&w, tid, run.Nodes)
+			if !ok {
+				run.Nodes[tid] = RunSkipped
+			}
+			mu.Unlock()
+			if !ok {
+				log.Infof("[workflow] %s 节点 %s 不满足触发条件 跳过", w.Wid, tid)
+				continue
+			}
+
+			wg.Add(1)
+			go func(tid string) {
+				defer wg.Done()
+				status := runWorkflowNode(tid)
+				mu.Lock()
+				run.Nodes[tid] = status
+				mu.Unlock()
+			}(tid)
+		}
+		wg.Wait()
+		ready = nextWorkflowNodes(ready, indegree, downstream)
+	}
+
+	run.Status = RunSuccess
+	for _, status := range run.Nodes {
+		if status == RunFailed || status == RunTimeout {
+			run.Status = RunFailed
+			break
+		}
+	}
+	end := time.Now()
+	run.EndAt = end.Unix()
+	run.Duration = end.Sub(start).Milliseconds()
+	if err = DB.WorkflowRun().Update(context.Background(), run.Rid, map[string]interface{}{
+		"status":   run.Status,
+		"nodes":    run.Nodes,
+		"end_at":   run.EndAt,
+		"duration": run.Duration,
+	}); err != nil {
+		log.Errorf("[workflow] update workflow run %s err: %v", run.Rid, err)
+		return err
+	}
+	log.Infof("[workflow] %s-%s 运行结束 status: %s", w.Wid, w.Name, run.Status)
+
+	if run.Status == RunFailed {
+		return fmt.Errorf("workflow %s failed", w.Wid)
+	}
+	return nil
+}
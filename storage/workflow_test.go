@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkflow(t *testing.T) {
+	for name, newDB := range testDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			setTestStorage(t, newDB())
+			defer RevokeDb()
+			testWorkflow(t)
+		})
+	}
+}
+
+func testWorkflow(t *testing.T) {
+	export := newBashTask(t, "echo export", true)
+	transform := newBashTask(t, "exit 1", true)
+	load := newBashTask(t, "echo load", true)
+	alert := newBashTask(t, "echo alert", true)
+	cleanup := newBashTask(t, "echo cleanup", true)
+
+	// 校验
+	assert.Equal(t, WorkflowNoNodeErr, PostWorkflow(&Workflow{Expression: "0 0 * * *"}))
+	assert.EqualError(t, PostWorkflow(&Workflow{Expression: "0 0 * *", Nodes: []string{export.Tid}}),
+		"invalid expression 0 0 * *: expected 5 to 6 fields, found 4: [0 0 * *]")
+	assert.NotNil(t, PostWorkflow(&Workflow{Expression: "0 0 * * *", Nodes: []string{export.Tid, "not exists"}}))
+	assert.NotNil(t, PostWorkflow(&Workflow{
+		Expression: "0 0 * * *",
+		Nodes:      []string{export.Tid, load.Tid},
+		Edges:      []WorkflowEdge{{From: export.Tid, To: load.Tid, Trigger: "sometimes"}},
+	}))
+	assert.Equal(t, WorkflowCycleErr, PostWorkflow(&Workflow{
+		Expression: "0 0 * * *",
+		Nodes:      []string{export.Tid, load.Tid},
+		Edges:      []WorkflowEdge{{From: export.Tid, To: load.Tid}, {From: load.Tid, To: export.Tid}},
+	}))
+
+	w := Workflow{
+		Name:       "nightly",
+		Expression: "0 0 * * *",
+		Nodes:      []string{export.Tid, transform.Tid, load.Tid, alert.Tid, cleanup.Tid},
+		Edges: []WorkflowEdge{
+			{From: export.Tid, To: transform.Tid},
+			{From: transform.Tid, To: load.Tid},
+			{From: transform.Tid, To: alert.Tid, Trigger: TriggerOnFailure},
+			{From: load.Tid, To: cleanup.Tid, Trigger: TriggerAlways},
+		},
+	}
+	assert.Nil(t, PostWorkflow(&w))
+	assert.NotEmpty(t, w.Wid)
+
+	got, err := GetWorkflow(w.Wid)
+	assert.Nil(t, err)
+	assert.Len(t, got.Nodes, 5)
+	assert.Equal(t, TriggerOnSuccess, got.Edges[0].Trigger)
+
+	assert.NotNil(t, RunWorkflow(w.Wid))
+
+	query := WorkflowRunQuery{}
+	query.Wid = w.Wid
+	runs, err := GetWorkflowRuns(&query)
+	assert.Nil(t, err)
+	assert.Len(t, runs, 1)
+	assert.Equal(t, RunFailed, runs[0].Status)
+	assert.Equal(t, map[string]string{
+		export.Tid:    RunSuccess,
+		transform.Tid: RunFailed,
+		load.Tid:      RunSkipped,
+		alert.Tid:     RunSuccess,
+		cleanup.Tid:   RunSuccess,
+	}, runs[0].Nodes)
+
+	// 下游只有在触发的时候才会执行
+	logQuery := LogQuery{}
+	logQuery.Tid = load.Tid
+	logs, err := GetLogs(&logQuery)
+	assert.Nil(t, err)
+	assert.Len(t, logs, 0)
+
+	assert.Nil(t, DisableWorkflow(w.Wid, true))
+	got, err = GetWorkflow(w.Wid)
+	assert.Nil(t, err)
+	assert.True(t, got.Disable)
+
+	assert.Nil(t, DeleteWorkflow(w.Wid))
+	_, err = GetWorkflow(w.Wid)
+	assert.NotNil(t, err)
+	assert.Equal(t, WorkflowNotFoundErr, RunWorkflow(w.Wid))
+}
+
+func TestWorkflowNodeStatus(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+	setKillGrace(t, 1)
+
+	// 节点保留任务自己的状态 例如超时
+	slow := newBashTask(t, "sleep 10", true)
+	assert.Nil(t, DB.Task().Update(context.Background(), slow.Tid, map[string]interface{}{"timeout": 1}))
+	w := Workflow{Name: "slow", Expression: "0 0 * * *", Nodes: []string{slow.Tid}}
+	assert.Nil(t, PostWorkflow(&w))
+	assert.NotNil(t, RunWorkflow(w.Wid))
+
+	query := WorkflowRunQuery{}
+	query.Wid = w.Wid
+	runs, err := GetWorkflowRuns(&query)
+	assert.Nil(t, err)
+	assert.Len(t, runs, 1)
+	assert.Equal(t, RunTimeout, runs[0].Nodes[slow.Tid])
+}
+
+func TestWorkflowNodeSelector(t *testing.T) {
+	setMemoryStorage(t)
+	defer RevokeDb()
+	setTestConfig(t, "worker.labels", map[string]string{"zone": "sh"})
+	assert.Nil(t, InitScheduler())
+	defer StopScheduler()
+
+	// 节点在工作流所在的 worker 上执行 不能设置 selector
+	selected := Task{Name: "s", Expression: "0 0 * * *", Type: BashTask,
+		Payload:  map[string]interface{}{"command": "date"},
+		Selector: TaskSelector{Required: map[string]string{"zone": "sh"}}}
+	assert.Nil(t, PostTask(&selected))
+	err := PostWorkflow(&Workflow{Expression: "0 0 * * *", Nodes: []string{selected.Tid}})
+	assert.True(t, errors.Is(err, WorkflowSelectorErr))
+
+	// 已经是工作流节点的任务不能再设置 selector
+	node := newBashTask(t, "date", false)
+	assert.Nil(t, PostWorkflow(&Workflow{Expression: "0 0 * * *", Nodes: []string{node.Tid}}))
+	_, err = UpdateTask(node.Tid, map[string]interface{}{"selector": map[string]interface{}{
+		"required": map[string]interface{}{"zone": "sh"}}})
+	assert.Equal(t, WorkflowSelectorErr, err)
+	_, err = UpdateTask(node.Tid, map[string]interface{}{"selector": map[string]interface{}{
+		"preferred": map[string]interface{}{"zone": "sh"}}})
+	assert.Nil(t, err)
+}
@@ -51,6 +51,9 @@ func main() {
 
 	// 设置配置文件和静态变量
 	config.SetConfig(filePath)
+	if gitHash != "" {
+		storage.Version = gitHash
+	}
 
 	if err := storage.SetDb(); err != nil {
 		log.Errorf("[main] set up error: %v", err)